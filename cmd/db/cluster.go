@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/raft"
+)
+
+var (
+	raftId    = flag.String("raft-id", "", "id of this node in the raft cluster")
+	raftPeers = flag.String("raft-peers", "", "initial raft cluster as id=url pairs separated by commas, e.g. db1=http://db1:8100 (empty to join an existing cluster)")
+	raftDir   = flag.String("raft-dir", "./raft", "directory with the raft log and snapshots")
+)
+
+// proposeTimeout limits how long a write waits for the cluster to commit it.
+const proposeTimeout = 5 * time.Second
+
+// node is the raft node when the server runs with --role=raft.
+var node *raft.Node
+
+// startRaft creates the raft node that applies committed writes to db and
+// registers the endpoints used by the other nodes and by operators.
+func startRaft(h *http.ServeMux) (*raft.FileStorage, error) {
+	if *raftId == "" {
+		return nil, fmt.Errorf("--raft-id is required in the raft role")
+	}
+	peers, err := parsePeers(*raftPeers)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := raft.NewFileStorage(*raftDir)
+	if err != nil {
+		return nil, err
+	}
+	node, err = raft.NewNode(*raftId, peers, raft.NewDbStateMachine(db), storage, raft.NewHTTPTransport(), raft.DefaultOptions())
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	h.Handle("/raft/", raft.NewHTTPHandler(node))
+	h.HandleFunc("/raft/status", handleRaftStatus)
+	h.HandleFunc("/raft/members", handleRaftMembers)
+	return storage, nil
+}
+
+func parsePeers(s string) ([]raft.Peer, error) {
+	var peers []raft.Peer
+	if s == "" {
+		return peers, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		id, address, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || address == "" {
+			return nil, fmt.Errorf("invalid raft peer %q, expected id=url", pair)
+		}
+		peers = append(peers, raft.Peer{ID: id, Address: address})
+	}
+	return peers, nil
+}
+
+// propose replicates a write through the raft log and returns the version
+// of the record, which is the index of its entry.
+func propose(key, vType, value string, ifVersion uint64) (uint64, error) {
+	command, err := json.Marshal(raft.Command{Key: key, Type: vType, Value: value, IfVersion: ifVersion})
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proposeTimeout)
+	defer cancel()
+	return node.Propose(ctx, command)
+}
+
+// redirectToLeader sends the client to the leader with 307, so the method
+// and the body of the request are preserved. It returns false if the node
+// is the leader itself and should handle the request.
+func redirectToLeader(rw http.ResponseWriter, r *http.Request) bool {
+	if node.IsLeader() {
+		return false
+	}
+	leader, ok := node.Leader()
+	if !ok || leader.Address == "" {
+		httpError(rw, http.StatusServiceUnavailable, codeUnavailable, "No raft leader elected, try again later", "")
+		return true
+	}
+	http.Redirect(rw, r, strings.TrimSuffix(leader.Address, "/")+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	return true
+}
+
+// consistentRead makes a read requested with ?consistent=true observe every
+// write completed before it, by redirecting it to the leader and waiting
+// there until all committed entries are applied.
+func consistentRead(rw http.ResponseWriter, r *http.Request) bool {
+	if node == nil || r.URL.Query().Get("consistent") != "true" {
+		return true
+	}
+	if redirectToLeader(rw, r) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), proposeTimeout)
+	defer cancel()
+	if err := node.Barrier(ctx); err != nil {
+		writeError(rw, "", err)
+		return false
+	}
+	return true
+}
+
+// raftErrorStatus reports failures to reach the majority as 503, so
+// clients know it's safe to retry.
+func raftErrorStatus(err error) int {
+	if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) ||
+		errors.Is(err, raft.ErrStopped) || errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable
+	}
+	return 0
+}
+
+func handleRaftStatus(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(node.Status())
+}
+
+// handleRaftMembers lists the members on GET, adds a member given as
+// {"id": ..., "address": ...} on POST and removes the member with the id
+// from the query on DELETE. Changes are redirected to the leader.
+func handleRaftMembers(rw http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		rw.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(rw).Encode(node.Members())
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	if redirectToLeader(rw, r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), proposeTimeout)
+	defer cancel()
+	var err error
+	if r.Method == http.MethodPost {
+		var peer raft.Peer
+		if err := json.NewDecoder(r.Body).Decode(&peer); err != nil || peer.ID == "" || peer.Address == "" {
+			httpError(rw, http.StatusBadRequest, codeInvalid, "Expected a member with an id and an address", "")
+			return
+		}
+		err = node.AddMember(ctx, peer)
+	} else {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			httpError(rw, http.StatusBadRequest, codeInvalid, "Missing member id", "")
+			return
+		}
+		err = node.RemoveMember(ctx, id)
+	}
+	if errors.Is(err, raft.ErrConfigChangeInProgress) {
+		httpError(rw, http.StatusConflict, codeConflict, err.Error(), "")
+		return
+	}
+	if err != nil {
+		status, code := raftErrorStatus(err), codeUnavailable
+		if status == 0 {
+			status, code = http.StatusBadRequest, codeInvalid
+		}
+		httpError(rw, status, code, err.Error(), "")
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(node.Members())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// etag formats a version of a record as an entity tag. Every node numbers
+// the versions on its own, except for raft members and followers, which
+// keep the versions of the leader, so an ETag of a shard is only valid for
+// the node that returned it.
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag returns the version of an entity tag; weak tags are accepted
+// when weak is true.
+func parseETag(tag string, weak bool) (uint64, bool) {
+	tag = strings.TrimSpace(tag)
+	if weak {
+		tag = strings.TrimPrefix(tag, "W/")
+	}
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version == datastore.AnyVersion || version == datastore.ExistingVersion {
+		return 0, false
+	}
+	return version, true
+}
+
+// ifMatch turns the If-Match header into the version a write expects. "*"
+// only requires the key to exist. Just one entity tag can be given, as
+// there is a single current version to compare with.
+func ifMatch(r *http.Request) (uint64, error) {
+	header := strings.TrimSpace(r.Header.Get("if-match"))
+	switch {
+	case header == "":
+		return datastore.AnyVersion, nil
+	case header == "*":
+		return datastore.ExistingVersion, nil
+	case strings.Contains(header, ","):
+		return 0, invalidf("Only one entity tag is supported in If-Match")
+	}
+	version, ok := parseETag(header, false)
+	if !ok {
+		return 0, invalidf("Malformed If-Match entity tag %s", header)
+	}
+	return version, nil
+}
+
+// notModified reports whether the If-None-Match header of a GET lists the
+// current version, so the client's copy is still valid.
+func notModified(r *http.Request, version uint64) bool {
+	header := strings.TrimSpace(r.Header.Get("if-none-match"))
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if v, ok := parseETag(tag, true); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func serveIf(method, target, header, tag string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if form != nil {
+		r.Header.Set("content-type", "application/x-www-form-urlencoded")
+	}
+	r.Header.Set(header, tag)
+	rw := httptest.NewRecorder()
+	handleDb(rw, r)
+	return rw
+}
+
+func TestHandleDb_Conditional(t *testing.T) {
+	var err error
+	db, err = datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	rw := serve(http.MethodPost, "/db/name", url.Values{"value": {"first"}})
+	first := rw.Header().Get("etag")
+	if rw.Code != http.StatusOK || first == "" {
+		t.Fatalf("Put failed with %d, ETag %q", rw.Code, first)
+	}
+	if rw := serve(http.MethodGet, "/db/name", nil); rw.Header().Get("etag") != first {
+		t.Errorf("GET returned ETag %q instead of %q", rw.Header().Get("etag"), first)
+	}
+	rw = serveIf(http.MethodGet, "/db/name", "if-none-match", `"1000", W/`+first, nil)
+	if rw.Code != http.StatusNotModified || rw.Body.Len() != 0 {
+		t.Errorf("Expected 304 for the current version, got %d: %s", rw.Code, rw.Body)
+	}
+	if rw := serveIf(http.MethodGet, "/db/name", "if-none-match", `"1000"`, nil); rw.Code != http.StatusOK {
+		t.Errorf("Expected 200 for another version, got %d", rw.Code)
+	}
+
+	rw = serveIf(http.MethodPost, "/db/name", "if-match", first, url.Values{"value": {"second"}})
+	second := rw.Header().Get("etag")
+	if rw.Code != http.StatusOK || second == "" || second == first {
+		t.Fatalf("Conditional put failed with %d, ETag %q", rw.Code, second)
+	}
+	rw = serveIf(http.MethodPost, "/db/name", "if-match", first, url.Values{"value": {"lost update"}})
+	if rw.Code != http.StatusPreconditionFailed || !strings.Contains(rw.Body.String(), codePrecondition) {
+		t.Errorf("Expected 412 for a stale ETag, got %d: %s", rw.Code, rw.Body)
+	}
+	if value, _ := db.Get("name"); value != "second" {
+		t.Errorf("Stale write was applied: %q", value)
+	}
+	if rw := serveIf(http.MethodPost, "/db/missing", "if-match", "*", url.Values{"value": {"value"}}); rw.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 for If-Match: * of a missing key, got %d", rw.Code)
+	}
+	if rw := serveIf(http.MethodPost, "/db/name", "if-match", "W/"+second, url.Values{"value": {"value"}}); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a weak ETag in If-Match, got %d", rw.Code)
+	}
+
+	if rw := serveIf(http.MethodDelete, "/db/name", "if-match", first, nil); rw.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 deleting with a stale ETag, got %d", rw.Code)
+	}
+	if rw := serveIf(http.MethodDelete, "/db/name", "if-match", second, nil); rw.Code != http.StatusOK {
+		t.Errorf("Conditional delete failed with %d: %s", rw.Code, rw.Body)
+	}
+	if db.Exists("name") {
+		t.Error("Key is not deleted")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// readValue takes the value of a write and its type from the request body.
+// A JSON body is an object like {"value": 42, "type": "int64"}; without the
+// type it is inferred from the JSON value. Raw octet-stream and form bodies
+// take the type from the "type" query parameter.
+func readValue(r *http.Request) (string, string, error) {
+	vType := r.URL.Query().Get("type")
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+	switch mediaType {
+	case "application/json":
+		return readJSONValue(r.Body, vType)
+	case "application/octet-stream":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", "", bodyError(err)
+		}
+		return string(data), vType, nil
+	default:
+		if err := r.ParseForm(); err != nil {
+			return "", "", bodyError(err)
+		}
+		return r.FormValue("value"), vType, nil
+	}
+}
+
+func readJSONValue(body io.Reader, vType string) (string, string, error) {
+	var data struct {
+		Value json.RawMessage `json:"value"`
+		Type  string          `json:"type"`
+	}
+	if err := json.NewDecoder(body).Decode(&data); err != nil {
+		return "", "", bodyError(err)
+	}
+	if data.Type != "" {
+		vType = data.Type
+	}
+	raw := strings.TrimSpace(string(data.Value))
+	switch {
+	case raw == "" || raw == "null":
+		return "", "", invalidf("Missing value")
+	case raw[0] == '"':
+		var value string
+		if err := json.Unmarshal(data.Value, &value); err != nil {
+			return "", "", invalidf("Bad value: %s", err)
+		}
+		return value, vType, nil
+	case raw[0] == '-' || raw[0] >= '0' && raw[0] <= '9':
+		// Число зберігається як int64, якщо тип не вказано явно.
+		if vType == "" {
+			vType = "int64"
+		}
+		return raw, vType, nil
+	default:
+		return "", "", invalidf("Value must be a JSON string or number")
+	}
+}
+
+func bodyError(err error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return err
+	}
+	return invalidError(err.Error())
+}
+
+// negotiate picks the representation of a value for the Accept header:
+// application/json, or the raw value as application/octet-stream or
+// text/plain. It returns "" if none of them is acceptable.
+func negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return "application/json"
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		var offered string
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			offered = "application/json"
+		case "application/octet-stream":
+			offered = mediaType
+		case "text/plain", "text/*":
+			offered = "text/plain"
+		}
+		if offered != "" && q > bestQ {
+			best, bestQ = offered, q
+		}
+	}
+	return best
+}
+
+// writeRecord answers a GET with the record in the representation chosen
+// by the Accept header of the request.
+func writeRecord(rw http.ResponseWriter, r *http.Request, data record) {
+	rw.Header().Set("vary", "Accept")
+	switch mediaType := negotiate(r.Header.Get("accept")); mediaType {
+	case "application/json":
+		rw.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(rw).Encode(data)
+	case "":
+		httpError(rw, http.StatusNotAcceptable, codeNotAcceptable,
+			"Values are available as application/json, application/octet-stream and text/plain", data.Key)
+	default:
+		if mediaType == "text/plain" {
+			mediaType += "; charset=utf-8"
+		}
+		rw.Header().Set("content-type", mediaType)
+		rw.Header().Set("x-value-type", data.Type)
+		_, _ = fmt.Fprint(rw, data.Value)
+	}
+}
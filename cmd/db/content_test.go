@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func send(method, target, contentType, body, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("content-type", contentType)
+	}
+	if accept != "" {
+		r.Header.Set("accept", accept)
+	}
+	rw := httptest.NewRecorder()
+	handleDb(rw, r)
+	return rw
+}
+
+func TestHandleDb_Content(t *testing.T) {
+	var err error
+	db, err = datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, tc := range []struct {
+		target, contentType, body string
+	}{
+		{"/db/form", "application/x-www-form-urlencoded", "value=from+form"},
+		{"/db/json", "application/json; charset=utf-8", `{"value": "from json"}`},
+		{"/db/number", "application/json", `{"value": -42}`},
+		{"/db/quoted-number", "application/json", `{"value": "17", "type": "int64"}`},
+		{"/db/number-text", "application/json", `{"value": 5, "type": "string"}`},
+		{"/db/raw", "application/octet-stream", "raw\x00bytes"},
+		{"/db/raw-number?type=int64", "application/octet-stream", "7"},
+	} {
+		if rw := send(http.MethodPost, tc.target, tc.contentType, tc.body, ""); rw.Code != http.StatusOK {
+			t.Errorf("POST %s failed with %d: %s", tc.target, rw.Code, rw.Body)
+		}
+	}
+
+	for _, tc := range []struct {
+		target, accept, contentType, body string
+	}{
+		{"/db/form", "", "application/json", `{"key":"form","value":"from form"}` + "\n"},
+		{"/db/json", "application/json", "application/json", `{"key":"json","value":"from json"}` + "\n"},
+		{"/db/number?type=int64", "*/*", "application/json", `{"key":"number","value":-42}` + "\n"},
+		{"/db/quoted-number?type=int64", "text/plain", "text/plain; charset=utf-8", "17"},
+		{"/db/number-text", "text/plain", "text/plain; charset=utf-8", "5"},
+		{"/db/raw", "application/json;q=0.5, application/octet-stream", "application/octet-stream", "raw\x00bytes"},
+		{"/db/raw-number?type=int64", "application/octet-stream", "application/octet-stream", "7"},
+	} {
+		rw := send(http.MethodGet, tc.target, "", "", tc.accept)
+		if rw.Code != http.StatusOK || rw.Header().Get("content-type") != tc.contentType || rw.Body.String() != tc.body {
+			t.Errorf("GET %s (%s): got %d %s %q", tc.target, tc.accept, rw.Code, rw.Header().Get("content-type"), rw.Body)
+		}
+	}
+
+	if rw := send(http.MethodGet, "/db/form", "", "", "image/png"); rw.Code != http.StatusNotAcceptable {
+		t.Errorf("Expected 406 for an unsupported Accept, got %d", rw.Code)
+	}
+	for _, body := range []string{`{"value": true}`, `{"value": null}`, `{"value": 1.5}`, `{"value": `} {
+		if rw := send(http.MethodPost, "/db/bad", "application/json", body, ""); rw.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d: %s", body, rw.Code, rw.Body)
+		}
+	}
+}
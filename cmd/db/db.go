@@ -1,66 +1,286 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 
-	"github.com/roman-mazur/design-practice-2-template/datastore"
-	"github.com/roman-mazur/design-practice-2-template/httptools"
-	"github.com/roman-mazur/design-practice-2-template/signal"
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/dbrpc"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/raft"
+	"github.com/roman-mazur/architecture-practice-4-template/replication"
+	"github.com/roman-mazur/architecture-practice-4-template/resp"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+	"google.golang.org/grpc"
 )
 
 var port = flag.Int("port", 8100, "server port")
+var role = flag.String("role", "leader", "replication role: leader, follower or raft")
+var leaderUrl = flag.String("leader-url", "http://db:8100", "leader address used by a follower")
+var replicationState = flag.String("replication-state", "./replication.json", "file where a follower keeps its position")
+var respPort = flag.Int("resp-port", 0, "port of the Redis protocol (RESP) listener, 0 disables it")
+var grpcPort = flag.Int("grpc-port", 0, "port of the gRPC API, 0 disables it")
 var db *datastore.Db
 
 func main() {
 	flag.Parse()
+	err := applyEnv(flag.CommandLine)
+	if err != nil {
+		log.Fatal(err)
+	}
 	h := new(http.ServeMux)
-	newDb, err := datastore.NewDb("./out")
+	newDb, err := datastore.NewDbWithOptions(*dir, options())
 	if err != nil {
 		panic(err)
 	}
 	db = newDb
 
-	h.HandleFunc("/db/", handleDb)
+	ctx, cancel := context.WithCancel(context.Background())
+	followerDone := make(chan struct{})
+	var raftStorage *raft.FileStorage
+	switch *role {
+	case "leader":
+		close(followerDone)
+		namespaces, err = openNamespaces(*namespacesDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		leader := replication.NewLeader(db)
+		h.HandleFunc(adminPrefix+"replication", leader.HandleStream)
+		h.HandleFunc(adminPrefix+"snapshot", leader.HandleSnapshot)
+	case "follower":
+		follower, err := replication.NewFollower(db, *leaderUrl, *replicationState)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			follower.Run(ctx)
+			close(followerDone)
+		}()
+	case "raft":
+		close(followerDone)
+		raftStorage, err = startRaft(h)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("Unknown replication role %q", *role)
+	}
+
+	handleAPI(h)
+
+	var respServer *resp.Server
+	if *respPort != 0 {
+		respServer, err = startResp()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var grpcServer *grpc.Server
+	if *grpcPort != 0 {
+		grpcServer, err = startGrpc()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	server := httptools.CreateServer(*port, h)
 	server.Start()
 	signal.WaitForTerminationSignal()
+	if respServer != nil {
+		respServer.Close()
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	cancel()
+	<-followerDone
+	if node != nil {
+		node.Stop()
+		raftStorage.Close()
+	}
+	if namespaces != nil {
+		namespaces.close()
+	}
+	db.Close()
+}
+
+// startResp serves the Redis protocol on --resp-port. Writes through it go
+// straight to the datastore, so it is not available in the raft role; a
+// follower serves reads only. The times to live set with EXPIRE are kept
+// in memory and are lost on restart.
+func startResp() (*resp.Server, error) {
+	if node != nil {
+		return nil, fmt.Errorf("the RESP listener is not supported in the raft role")
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *respPort))
+	if err != nil {
+		return nil, err
+	}
+	s := resp.NewServer(db)
+	s.ReadOnly = *role == "follower"
+	go func() {
+		if err := s.Serve(l); err != resp.ErrServerClosed {
+			log.Fatalf("RESP listener failed: %s", err)
+		}
+	}()
+	log.Printf("Serving RESP on %s", l.Addr())
+	return s, nil
+}
+
+// startGrpc serves the gRPC API on --grpc-port, with the same restrictions
+// as the RESP listener.
+func startGrpc() (*grpc.Server, error) {
+	if node != nil {
+		return nil, fmt.Errorf("the gRPC API is not supported in the raft role")
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+	if err != nil {
+		return nil, err
+	}
+	server := grpc.NewServer()
+	s := dbrpc.NewServer(db)
+	s.ReadOnly = *role == "follower"
+	dbrpc.RegisterDbServer(server, s)
+	go func() {
+		if err := server.Serve(l); err != nil {
+			log.Fatalf("gRPC server failed: %s", err)
+		}
+	}()
+	log.Printf("Serving gRPC on %s", l.Addr())
+	return server, nil
+}
+
+// writeHandler rejects writes on a follower, which only applies the leader's
+// records. In a raft cluster writes are redirected to the leader instead.
+func writeHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if node != nil && redirectToLeader(rw, r) {
+			return
+		}
+		if *role == "follower" {
+			httpError(rw, http.StatusForbidden, codeReadOnly, "Read-only replica, send writes to "+*leaderUrl, "")
+			return
+		}
+		handler(rw, r)
+	}
 }
 
+// adminPrefix starts the paths of the endpoints managing the database, kept
+// apart from /db/<key> so that any key can be read and written.
+const adminPrefix = "/admin/"
+
+// handleAPI registers the keys and the admin endpoints available in every
+// role.
+func handleAPI(h *http.ServeMux) {
+	h.HandleFunc(adminPrefix+"export", handleExport)
+	h.HandleFunc(adminPrefix+"import", writeHandler(handleImport))
+	h.HandleFunc(adminPrefix+"stats", handleStats)
+	h.HandleFunc(adminPrefix+"compact", handleCompact)
+	h.HandleFunc(adminPrefix+"namespaces", handleNamespaces)
+	h.HandleFunc(adminPrefix+"namespaces/", handleNamespaces)
+	h.HandleFunc("/db/", handleDb)
+}
+
+// namespacePrefix starts the paths of the namespaces, /db/_ns/<ns>/<key>.
+// The default keyspace is served at /db/<key>, where a key may contain
+// slashes, so the namespaces are kept under a reserved prefix instead.
+const namespacePrefix = "_ns/"
+
+// handleDb serves the default keyspace at /db/<key> and the namespaces at
+// /db/_ns/<ns>/<key>.
 func handleDb(rw http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/db/")
+	rest, namespaced := strings.CutPrefix(path, namespacePrefix)
+	if !namespaced {
+		serveKey(rw, r, nil, path)
+		return
+	}
+	name, key, ok := strings.Cut(rest, "/")
+	if !ok || key == "" {
+		httpError(rw, http.StatusBadRequest, codeInvalid, "Expected a key of a namespace as /db/_ns/<ns>/<key>", "")
+		return
+	}
+	if namespaces == nil {
+		httpError(rw, http.StatusNotImplemented, codeNotImplemented, "Namespaces are not replicated and are only available in the leader role", key)
+		return
+	}
+	ns, ok := namespaces.acquire(name)
+	if !ok {
+		writeError(rw, key, fmt.Errorf("%w: %s", errNoNamespace, name))
+		return
+	}
+	defer ns.release()
+	serveKey(rw, r, ns, key)
+}
+
+// serveKey handles a request to the key of the namespace, which is nil for
+// the default keyspace.
+func serveKey(rw http.ResponseWriter, r *http.Request, ns *namespace, key string) {
 	switch r.Method {
 	case http.MethodGet:
-		handleDbGet(rw, r)
+		handleDbGet(rw, r, ns, key)
 	case http.MethodPost:
-		handleDbPost(rw, r)
+		writeHandler(func(rw http.ResponseWriter, r *http.Request) {
+			handleDbPost(rw, r, ns, key)
+		})(rw, r)
+	case http.MethodDelete:
+		writeHandler(func(rw http.ResponseWriter, r *http.Request) {
+			handleDbDelete(rw, r, ns, key)
+		})(rw, r)
 	default:
-		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
+
+// storeOf returns the database of the namespace, or the default one.
+func storeOf(ns *namespace) *datastore.Db {
+	if ns != nil {
+		return ns.db
 	}
+	return db
 }
 
-func handleDbGet(rw http.ResponseWriter, r *http.Request) {
-	key := strings.TrimPrefix(r.URL.Path, "/db/")
+func handleDbGet(rw http.ResponseWriter, r *http.Request, ns *namespace, key string) {
+	if ns == nil && !consistentRead(rw, r) {
+		return
+	}
 	t := r.URL.Query().Get("type")
 	getter := typeToGetter(t)
 	if getter == nil {
-		http.Error(rw, "Unknown data type", http.StatusBadRequest)
+		httpError(rw, http.StatusBadRequest, codeInvalid, "Unknown data type", key)
 		return
 	}
-	data, err := getter(key)
+	data, err := getter(storeOf(ns), key)
 
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-	} else {
-		_ = json.NewEncoder(rw).Encode(data)
+		writeError(rw, key, err)
+		return
+	}
+	rw.Header().Set("etag", etag(data.Version))
+	if notModified(r, data.Version) {
+		rw.WriteHeader(http.StatusNotModified)
+		return
 	}
+	writeRecord(rw, r, data)
 }
 
-func typeToGetter(t string) func(string) (interface{}, error) {
+// record is a value read from the db.
+type record struct {
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value"`
+	Type    string      `json:"-"`
+	Version uint64      `json:"-"`
+}
+
+func typeToGetter(t string) func(*datastore.Db, string) (record, error) {
 	if t == "" || t == "string" {
 		return get
 	} else if t == "int64" {
@@ -70,46 +290,58 @@ func typeToGetter(t string) func(string) (interface{}, error) {
 	}
 }
 
-func get(key string) (interface{}, error) {
-	value, err := db.Get(key)
+func get(store *datastore.Db, key string) (record, error) {
+	value, version, err := store.GetWithVersion(key)
 	if err != nil {
-		return nil, err
+		return record{}, err
 	}
-	data := struct {
-		Key   string `json:"key"`
-		Value string `json:"value"`
-	}{key, value}
-	return data, nil
+	return record{key, value, "string", version}, nil
 }
 
-func getInt64(key string) (interface{}, error) {
-	value, err := db.GetInt64(key)
+func getInt64(store *datastore.Db, key string) (record, error) {
+	value, version, err := store.GetInt64WithVersion(key)
 	if err != nil {
-		return nil, err
+		return record{}, err
 	}
-	data := struct {
-		Key   string `json:"key"`
-		Value int64  `json:"value"`
-	}{key, value}
-	return data, nil
+	return record{key, value, "int64", version}, nil
 }
 
-func handleDbPost(rw http.ResponseWriter, r *http.Request) {
-	key := strings.TrimPrefix(r.URL.Path, "/db/")
-	value := r.FormValue("value")
-	t := r.URL.Query().Get("type")
+func handleDbPost(rw http.ResponseWriter, r *http.Request, ns *namespace, key string) {
+	r.Body = http.MaxBytesReader(rw, r.Body, int64(*maxKeySize+*maxValueSize)*3+1024)
+	value, t, err := readValue(r)
+	if err != nil {
+		writeError(rw, key, err)
+		return
+	}
 	putter := typeToPutter(t)
 	if putter == nil {
-		http.Error(rw, "Unknown data type", http.StatusBadRequest)
+		httpError(rw, http.StatusBadRequest, codeInvalid, "Unknown data type", key)
+		return
+	}
+	ifVersion, err := ifMatch(r)
+	if err != nil {
+		writeError(rw, key, err)
+		return
+	}
+	version, err := putter(ns, key, value, ifVersion)
+	if err != nil {
+		writeError(rw, key, err)
 		return
 	}
-	err := putter(key, value)
+	rw.Header().Set("etag", etag(version))
+}
+
+func handleDbDelete(rw http.ResponseWriter, r *http.Request, ns *namespace, key string) {
+	ifVersion, err := ifMatch(r)
+	if err == nil {
+		_, err = write(ns, key, "tombstone", "", ifVersion)
+	}
 	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+		writeError(rw, key, err)
 	}
 }
 
-func typeToPutter(t string) func(string, string) error {
+func typeToPutter(t string) func(*namespace, string, string, uint64) (uint64, error) {
 	if t == "" || t == "string" {
 		return put
 	} else if t == "int64" {
@@ -119,17 +351,117 @@ func typeToPutter(t string) func(string, string) error {
 	}
 }
 
-func put(key, value string) error {
+func put(ns *namespace, key, value string, ifVersion uint64) (uint64, error) {
 	if value == "" {
-		return fmt.Errorf("Can't save empty value")
+		return 0, invalidf("Can't save empty value")
 	}
-	return db.Put(key, value)
+	return write(ns, key, "string", value, ifVersion)
 }
 
-func putInt64(key, value string) error {
+func putInt64(ns *namespace, key, value string, ifVersion uint64) (uint64, error) {
 	i, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		return fmt.Errorf("Can't convert value to the given type")
+		return 0, invalidf("Can't convert value to the given type")
 	}
-	return db.PutInt64(key, i)
-}
\ No newline at end of file
+	return write(ns, key, "int64", strconv.FormatInt(i, 10), ifVersion)
+}
+
+// write stores the value, or deletes the key for a "tombstone", if its
+// version is ifVersion, and returns the new version.
+func write(ns *namespace, key, vType, value string, ifVersion uint64) (uint64, error) {
+	if ns == nil && node != nil {
+		return propose(key, vType, value, ifVersion)
+	}
+	return storeOf(ns).PutValueWith(key, vType, value, datastore.WriteOptions{IfVersion: ifVersion})
+}
+
+func handleExport(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	format := formatOf(r)
+	if format == "" {
+		httpError(rw, http.StatusBadRequest, codeInvalid, "Unknown export format", "")
+		return
+	}
+	if format == datastore.FormatCSV {
+		rw.Header().Set("content-type", "text/csv")
+	} else {
+		rw.Header().Set("content-type", "application/x-ndjson")
+	}
+	err := db.Export(rw, format)
+	if err != nil {
+		log.Printf("Export failed: %s", err)
+	}
+}
+
+func handleImport(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	if node != nil {
+		httpError(rw, http.StatusNotImplemented, codeNotImplemented, "Import bypasses the raft log and is not supported in the raft role", "")
+		return
+	}
+	format := formatOf(r)
+	if format == "" {
+		httpError(rw, http.StatusBadRequest, codeInvalid, "Unknown import format", "")
+		return
+	}
+	importRecords := db.Import
+	if r.URL.Query().Get("overwrite") == "false" {
+		importRecords = db.ImportMissing
+	}
+	n, err := importRecords(r.Body, format)
+	if err != nil {
+		status, code := classify(err)
+		httpError(rw, status, code, fmt.Sprintf("imported %d records: %s", n, err), "")
+		return
+	}
+	_ = json.NewEncoder(rw).Encode(struct {
+		Imported int `json:"imported"`
+	}{n})
+}
+
+func handleStats(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(db.Stats())
+}
+
+func handleCompact(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	err := db.Compact()
+	if err != nil {
+		writeError(rw, "", err)
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(db.Stats())
+}
+
+// formatOf picks the export format from the "format" query parameter,
+// falling back to the content type of the request.
+func formatOf(r *http.Request) string {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		switch r.Header.Get("content-type") {
+		case "text/csv":
+			format = datastore.FormatCSV
+		default:
+			format = datastore.FormatJSONL
+		}
+	}
+	if format != datastore.FormatJSONL && format != datastore.FormatCSV {
+		return ""
+	}
+	return format
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func TestHandleAPI_Keys(t *testing.T) {
+	var err error
+	db, err = datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	h := http.NewServeMux()
+	handleAPI(h)
+
+	// Імена адмінських ендпоінтів є звичайними ключами.
+	for _, key := range []string{"_export", "_import", "_stats", "_compact", "_namespaces", "_replication", "_snapshot"} {
+		form := url.Values{"value": {"value" + key}}
+		r := httptest.NewRequest(http.MethodPost, "/db/"+key, strings.NewReader(form.Encode()))
+		r.Header.Set("content-type", "application/x-www-form-urlencoded")
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		if rw.Code != http.StatusOK {
+			t.Errorf("Put of %s failed with %d: %s", key, rw.Code, rw.Body)
+			continue
+		}
+		rw = httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/db/"+key, nil))
+		if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "value"+key) {
+			t.Errorf("Get of %s returned %d: %s", key, rw.Code, rw.Body)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if rw.Code != http.StatusOK {
+		t.Errorf("Stats failed with %d: %s", rw.Code, rw.Body)
+	}
+}
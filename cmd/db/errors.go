@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var legacyErrors = flag.Bool("legacy-errors", false, "answer failed requests with plain text and 400 instead of JSON errors with specific status codes")
+
+// Error codes of the JSON error bodies.
+const (
+	codeNotFound         = "not_found"
+	codeTypeMismatch     = "type_mismatch"
+	codeInvalid          = "invalid_argument"
+	codeTooLarge         = "too_large"
+	codeStorage          = "storage_error"
+	codeUnavailable      = "unavailable"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeReadOnly         = "read_only"
+	codeNotImplemented   = "not_implemented"
+	codeConflict         = "conflict"
+	codeNotAcceptable    = "not_acceptable"
+	codePrecondition     = "precondition_failed"
+	codeNoNamespace      = "namespace_not_found"
+	codeQuotaExceeded    = "quota_exceeded"
+)
+
+// apiError is the body of a failed response.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Key     string `json:"key,omitempty"`
+}
+
+// invalidError rejects a request before it reaches the storage.
+type invalidError string
+
+func (e invalidError) Error() string {
+	return string(e)
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return invalidError(fmt.Sprintf(format, args...))
+}
+
+// classify maps an error to an HTTP status and an error code. Anything that
+// isn't recognised is a failure of the storage.
+func classify(err error) (int, string) {
+	var tooLarge *datastore.ErrTooLarge
+	var maxBytes *http.MaxBytesError
+	var invalid invalidError
+	switch {
+	case errors.As(err, &tooLarge) || errors.As(err, &maxBytes):
+		return http.StatusRequestEntityTooLarge, codeTooLarge
+	case errors.Is(err, datastore.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage, codeQuotaExceeded
+	case errors.Is(err, datastore.ErrNotFound):
+		return http.StatusNotFound, codeNotFound
+	case errors.Is(err, errNoNamespace):
+		return http.StatusNotFound, codeNoNamespace
+	case errors.Is(err, errNamespaceExists):
+		return http.StatusConflict, codeConflict
+	case errors.Is(err, datastore.ErrWrongType):
+		return http.StatusConflict, codeTypeMismatch
+	case errors.Is(err, datastore.ErrVersionMismatch):
+		return http.StatusPreconditionFailed, codePrecondition
+	case errors.As(err, &invalid) || errors.Is(err, datastore.ErrInvalidRecord) ||
+		errors.Is(err, datastore.ErrEmptyKey) || errors.Is(err, datastore.ErrUnknownFormat):
+		return http.StatusBadRequest, codeInvalid
+	}
+	if status := raftErrorStatus(err); status != 0 {
+		return status, codeUnavailable
+	}
+	return http.StatusInternalServerError, codeStorage
+}
+
+// writeError answers with the status and the code of err.
+func writeError(rw http.ResponseWriter, key string, err error) {
+	status, code := classify(err)
+	httpError(rw, status, code, err.Error(), key)
+}
+
+// httpError writes a JSON error body. In the legacy mode the body is plain
+// text and the errors that had no status of their own before the error
+// codes were introduced are reported as 400.
+func httpError(rw http.ResponseWriter, status int, code, message, key string) {
+	if *legacyErrors {
+		switch code {
+		case codeNotFound, codeTypeMismatch, codeStorage:
+			status = http.StatusBadRequest
+		}
+		http.Error(rw, message, status)
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	rw.Header().Set("x-content-type-options", "nosniff")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(apiError{Code: code, Message: message, Key: key})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func serve(method, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	if form != nil {
+		r.Header.Set("content-type", "application/x-www-form-urlencoded")
+	}
+	rw := httptest.NewRecorder()
+	handleDb(rw, r)
+	return rw
+}
+
+func TestHandleDb_Errors(t *testing.T) {
+	var err error
+	db, err = datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if rw := serve(http.MethodPost, "/db/name", url.Values{"value": {"value"}}); rw.Code != http.StatusOK {
+		t.Fatalf("Put failed with %d: %s", rw.Code, rw.Body)
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		form           url.Values
+		status         int
+		code           string
+	}{
+		{http.MethodGet, "/db/missing", nil, http.StatusNotFound, codeNotFound},
+		{http.MethodGet, "/db/name?type=int64", nil, http.StatusConflict, codeTypeMismatch},
+		{http.MethodGet, "/db/name?type=float", nil, http.StatusBadRequest, codeInvalid},
+		{http.MethodPost, "/db/name?type=int64", url.Values{"value": {"abc"}}, http.StatusBadRequest, codeInvalid},
+		{http.MethodPost, "/db/name", url.Values{"value": {""}}, http.StatusBadRequest, codeInvalid},
+		{http.MethodPost, "/db/name", url.Values{"value": {strings.Repeat("v", *maxValueSize+1)}}, http.StatusRequestEntityTooLarge, codeTooLarge},
+		{http.MethodDelete, "/db/missing", nil, http.StatusNotFound, codeNotFound},
+		{http.MethodPut, "/db/name", nil, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+	} {
+		rw := serve(tc.method, tc.target, tc.form)
+		var body apiError
+		if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+			t.Errorf("%s %s: bad error body: %s", tc.method, tc.target, err)
+			continue
+		}
+		if rw.Code != tc.status || body.Code != tc.code || body.Message == "" {
+			t.Errorf("%s %s: got %d %+v, expected %d %s", tc.method, tc.target, rw.Code, body, tc.status, tc.code)
+		}
+	}
+	if rw := serve(http.MethodGet, "/db/missing", nil); !strings.Contains(rw.Body.String(), `"key":"missing"`) {
+		t.Errorf("Error body has no key: %s", rw.Body)
+	}
+
+	*legacyErrors = true
+	t.Cleanup(func() { *legacyErrors = false })
+	rw := serve(http.MethodGet, "/db/missing", nil)
+	if rw.Code != http.StatusBadRequest || rw.Body.String() != "record does not exist\n" {
+		t.Errorf("Unexpected legacy response %d %q", rw.Code, rw.Body)
+	}
+	if rw := serve(http.MethodPut, "/db/name", nil); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Legacy mode changed the status of a method error to %d", rw.Code)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var namespacesDir = flag.String("namespaces-dir", "./namespaces", "directory with the databases of the namespaces served at /db/_ns/<ns>/<key>")
+
+var (
+	errNoNamespace     = errors.New("namespace does not exist")
+	errNamespaceExists = errors.New("namespace already exists")
+)
+
+// namespaces holds the keyspaces served at /db/_ns/<ns>/<key>. Their data is
+// not replicated, so they are only available in the leader role and the
+// variable is nil otherwise.
+var namespaces *namespaceRegistry
+
+// Назва файлу реєстру не може збігтися з назвою простору імен через крапку.
+const registryFile = "namespaces.json"
+
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// namespace is a keyspace with a datastore.Db of its own.
+type namespace struct {
+	Name  string          `json:"name"`
+	Quota datastore.Quota `json:"quota"`
+
+	db *datastore.Db
+	// use is held for reading while a request works with db, so the
+	// namespace is not dropped under it.
+	use     sync.RWMutex
+	dropped bool
+}
+
+// release ends the use of a namespace returned by acquire.
+func (ns *namespace) release() {
+	ns.use.RUnlock()
+}
+
+// namespaceInfo describes a namespace in the responses of the admin API.
+type namespaceInfo struct {
+	Name  string          `json:"name"`
+	Quota datastore.Quota `json:"quota"`
+	Keys  int             `json:"keys"`
+	// Bytes are the live bytes counted against the quota, DiskBytes
+	// include the garbage not merged yet.
+	Bytes     int64 `json:"bytes"`
+	DiskBytes int64 `json:"diskBytes"`
+}
+
+func (ns *namespace) info() namespaceInfo {
+	stats := ns.db.Stats()
+	return namespaceInfo{ns.Name, ns.Quota, stats.Keys, stats.LiveBytes, stats.TotalBytes}
+}
+
+// namespaceRegistry keeps the list of namespaces with their quotas in a
+// file next to their directories.
+type namespaceRegistry struct {
+	mu    sync.Mutex
+	dir   string
+	items map[string]*namespace
+	// dropping are the names of the namespaces whose data is still in
+	// their directories, which can't be created again until it is moved.
+	dropping map[string]bool
+}
+
+// droppedPrefix starts the names of the directories of dropped namespaces,
+// which are renamed before their data is deleted. A namespace name can't
+// start with a dot.
+const droppedPrefix = ".dropped-"
+
+// openNamespaces opens the databases of the namespaces registered in dir.
+func openNamespaces(dir string) (*namespaceRegistry, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	n := &namespaceRegistry{dir: dir, items: make(map[string]*namespace), dropping: make(map[string]bool)}
+	data, err := os.ReadFile(filepath.Join(dir, registryFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var saved []*namespace
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("corrupted namespace registry %s: %w", dir, err)
+		}
+	}
+	for _, ns := range saved {
+		if err := n.open(ns); err != nil {
+			n.close()
+			return nil, fmt.Errorf("namespace %s: %w", ns.Name, err)
+		}
+	}
+
+	// Перейменовані каталоги лишилися від незавершеного видалення, решту не чіпаємо.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		n.close()
+		return nil, err
+	}
+	for _, e := range entries {
+		_, ok := n.items[e.Name()]
+		switch {
+		case !e.IsDir() || ok:
+		case strings.HasPrefix(e.Name(), droppedPrefix):
+			log.Printf("Removing the data of the dropped namespace %s", e.Name())
+			if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+				n.close()
+				return nil, err
+			}
+		default:
+			log.Printf("Directory %s is not a registered namespace, leaving it alone", filepath.Join(dir, e.Name()))
+		}
+	}
+	return n, nil
+}
+
+func (n *namespaceRegistry) open(ns *namespace) error {
+	o := options()
+	o.Quota = ns.Quota
+	db, err := datastore.NewDbWithOptions(filepath.Join(n.dir, ns.Name), o)
+	if err != nil {
+		return err
+	}
+	ns.db = db
+	n.items[ns.Name] = ns
+	return nil
+}
+
+// acquire returns the namespace, which must be released after the request.
+func (n *namespaceRegistry) acquire(name string) (*namespace, bool) {
+	n.mu.Lock()
+	ns, ok := n.items[name]
+	n.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	ns.use.RLock()
+	if ns.dropped {
+		ns.use.RUnlock()
+		return nil, false
+	}
+	return ns, true
+}
+
+// create registers a new namespace with an empty database.
+func (n *namespaceRegistry) create(name string, quota datastore.Quota) (*namespace, error) {
+	if !namespaceName.MatchString(name) {
+		return nil, invalidf("Invalid namespace name %q, expected lowercase letters, digits, '-' and '_'", name)
+	}
+	if quota.MaxKeys < 0 || quota.MaxBytes < 0 {
+		return nil, invalidf("Quota limits must not be negative")
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.items[name]; ok {
+		return nil, fmt.Errorf("%w: %s", errNamespaceExists, name)
+	}
+	if n.dropping[name] {
+		return nil, fmt.Errorf("%w: %s is being dropped", errNamespaceExists, name)
+	}
+	// Дані чужого або недовидаленого каталогу не мають потрапити в новий простір імен.
+	if _, err := os.Stat(filepath.Join(n.dir, name)); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s has data left in %s", errNamespaceExists, name, n.dir)
+	}
+	ns := &namespace{Name: name, Quota: quota}
+	if err := n.open(ns); err != nil {
+		return nil, err
+	}
+	if err := n.save(); err != nil {
+		delete(n.items, name)
+		ns.db.Close()
+		os.RemoveAll(filepath.Join(n.dir, name))
+		return nil, err
+	}
+	return ns, nil
+}
+
+// drop removes the namespace and deletes its data once the requests that
+// use it are finished. The name can't be created again until the directory
+// is renamed to a unique name starting with droppedPrefix, which is then
+// deleted.
+func (n *namespaceRegistry) drop(name string) error {
+	n.mu.Lock()
+	ns, ok := n.items[name]
+	if !ok {
+		n.mu.Unlock()
+		return fmt.Errorf("%w: %s", errNoNamespace, name)
+	}
+	delete(n.items, name)
+	err := n.save()
+	if err != nil {
+		n.items[name] = ns
+	} else {
+		n.dropping[name] = true
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ns.use.Lock()
+	ns.dropped = true
+	ns.use.Unlock()
+	ns.db.Close()
+
+	n.mu.Lock()
+	tombstone := filepath.Join(n.dir, fmt.Sprintf("%s%s-%d", droppedPrefix, name, time.Now().UnixNano()))
+	err = os.Rename(filepath.Join(n.dir, name), tombstone)
+	if err == nil {
+		delete(n.dropping, name)
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(tombstone)
+}
+
+// list describes the namespaces sorted by name.
+func (n *namespaceRegistry) list() []namespaceInfo {
+	n.mu.Lock()
+	names := make([]string, 0, len(n.items))
+	for name := range n.items {
+		names = append(names, name)
+	}
+	n.mu.Unlock()
+	sort.Strings(names)
+
+	infos := make([]namespaceInfo, 0, len(names))
+	for _, name := range names {
+		if ns, ok := n.acquire(name); ok {
+			infos = append(infos, ns.info())
+			ns.release()
+		}
+	}
+	return infos
+}
+
+// save atomically rewrites the registry file. n.mu must be held.
+func (n *namespaceRegistry) save() error {
+	saved := make([]*namespace, 0, len(n.items))
+	for _, ns := range n.items {
+		saved = append(saved, ns)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(n.dir, registryFile)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (n *namespaceRegistry) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, ns := range n.items {
+		ns.db.Close()
+	}
+}
+
+// handleNamespaces lists the namespaces on GET and creates one given as
+// {"name": ..., "quota": {"maxKeys": ..., "maxBytes": ...}} on POST.
+// /admin/namespaces/<name> describes a namespace on GET and drops it with all
+// the data on DELETE.
+func handleNamespaces(rw http.ResponseWriter, r *http.Request) {
+	if namespaces == nil {
+		httpError(rw, http.StatusNotImplemented, codeNotImplemented, "Namespaces are not replicated and are only available in the leader role", "")
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, adminPrefix+"namespaces"), "/")
+	if name == "" {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, namespaces.list())
+		case http.MethodPost:
+			var req struct {
+				Name  string          `json:"name"`
+				Quota datastore.Quota `json:"quota"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(rw, http.StatusBadRequest, codeInvalid, "Expected a namespace with a name and an optional quota", "")
+				return
+			}
+			ns, err := namespaces.create(req.Name, req.Quota)
+			if err != nil {
+				writeError(rw, "", err)
+				return
+			}
+			writeJSON(rw, http.StatusCreated, ns.info())
+		default:
+			httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ns, ok := namespaces.acquire(name)
+		if !ok {
+			writeError(rw, "", fmt.Errorf("%w: %s", errNoNamespace, name))
+			return
+		}
+		defer ns.release()
+		writeJSON(rw, http.StatusOK, ns.info())
+	case http.MethodDelete:
+		if err := namespaces.drop(name); err != nil {
+			writeError(rw, "", err)
+		}
+	default:
+		httpError(rw, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed", "")
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
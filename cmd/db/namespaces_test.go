@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func admin(method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	rw := httptest.NewRecorder()
+	handleNamespaces(rw, r)
+	return rw
+}
+
+func TestHandleDb_Namespaces(t *testing.T) {
+	var err error
+	db, err = datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dir := t.TempDir()
+	namespaces, err = openNamespaces(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		namespaces.close()
+		namespaces = nil
+	})
+
+	if rw := admin(http.MethodPost, "/admin/namespaces", `{"name": "team-a", "quota": {"maxKeys": 2}}`); rw.Code != http.StatusCreated {
+		t.Fatalf("Create failed with %d: %s", rw.Code, rw.Body)
+	}
+	if rw := admin(http.MethodPost, "/admin/namespaces", `{"name": "team-b"}`); rw.Code != http.StatusCreated {
+		t.Fatalf("Create failed with %d: %s", rw.Code, rw.Body)
+	}
+	if rw := admin(http.MethodPost, "/admin/namespaces", `{"name": "team-a"}`); rw.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing namespace, got %d", rw.Code)
+	}
+	if rw := admin(http.MethodPost, "/admin/namespaces", `{"name": "_export"}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid name, got %d", rw.Code)
+	}
+
+	for target, value := range map[string]string{"/db/key": "default", "/db/_ns/team-a/key": "a", "/db/_ns/team-b/key": "b"} {
+		if rw := serve(http.MethodPost, target, url.Values{"value": {value}}); rw.Code != http.StatusOK {
+			t.Fatalf("Put to %s failed with %d: %s", target, rw.Code, rw.Body)
+		}
+	}
+	for target, value := range map[string]string{"/db/key": "default", "/db/_ns/team-a/key": "a", "/db/_ns/team-b/key": "b"} {
+		rw := serve(http.MethodGet, target, nil)
+		if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"value":"`+value+`"`) {
+			t.Errorf("GET %s returned %d: %s", target, rw.Code, rw.Body)
+		}
+	}
+	// Ключі зі скісними рисками лишаються в основному просторі.
+	if rw := serve(http.MethodPost, "/db/team-a/key", url.Values{"value": {"slashed"}}); rw.Code != http.StatusOK {
+		t.Errorf("Put of a key with a slash failed with %d: %s", rw.Code, rw.Body)
+	}
+	if rw := serve(http.MethodGet, "/db/team-a/key", nil); rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"value":"slashed"`) {
+		t.Errorf("GET of a key with a slash returned %d: %s", rw.Code, rw.Body)
+	}
+	if rw := serve(http.MethodGet, "/db/_ns/team-a", nil); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a key, got %d", rw.Code)
+	}
+	if rw := serve(http.MethodGet, "/db/_ns/missing/key", nil); rw.Code != http.StatusNotFound || !strings.Contains(rw.Body.String(), codeNoNamespace) {
+		t.Errorf("Expected 404 for a missing namespace, got %d: %s", rw.Code, rw.Body)
+	}
+
+	serve(http.MethodPost, "/db/_ns/team-a/other", url.Values{"value": {"a"}})
+	rw := serve(http.MethodPost, "/db/_ns/team-a/third", url.Values{"value": {"a"}})
+	if rw.Code != http.StatusInsufficientStorage || !strings.Contains(rw.Body.String(), codeQuotaExceeded) {
+		t.Errorf("Expected 507 over the quota, got %d: %s", rw.Code, rw.Body)
+	}
+
+	var infos []namespaceInfo
+	if err := json.NewDecoder(admin(http.MethodGet, "/admin/namespaces", "").Body).Decode(&infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Name != "team-a" || infos[0].Keys != 2 || infos[0].Quota.MaxKeys != 2 || infos[1].Keys != 1 {
+		t.Errorf("Unexpected namespaces %+v", infos)
+	}
+
+	if rw := admin(http.MethodDelete, "/admin/namespaces/team-b", ""); rw.Code != http.StatusOK {
+		t.Fatalf("Drop failed with %d: %s", rw.Code, rw.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "team-b")); !os.IsNotExist(err) {
+		t.Errorf("Data of the dropped namespace is not removed: %v", err)
+	}
+	if rw := serve(http.MethodGet, "/db/_ns/team-b/key", nil); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after drop, got %d", rw.Code)
+	}
+	if rw := admin(http.MethodDelete, "/admin/namespaces/team-b", ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 dropping twice, got %d", rw.Code)
+	}
+	if rw := admin(http.MethodPost, "/admin/namespaces", `{"name": "team-b"}`); rw.Code != http.StatusCreated {
+		t.Fatalf("Create after drop failed with %d: %s", rw.Code, rw.Body)
+	}
+	if rw := serve(http.MethodGet, "/db/_ns/team-b/key", nil); rw.Code != http.StatusNotFound {
+		t.Errorf("Recreated namespace has the old data: %d", rw.Code)
+	}
+
+	// Після перезапуску простори імен і їхні квоти відновлюються з реєстру.
+	// Лише каталоги з префіксом видалення прибираються, чужі дані лишаються.
+	namespaces.close()
+	for _, name := range []string{"orphan", droppedPrefix + "team-c-1"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	namespaces, err = openNamespaces(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rw := serve(http.MethodGet, "/db/_ns/team-a/key", nil); rw.Code != http.StatusOK {
+		t.Errorf("Namespace is lost after restart: %d", rw.Code)
+	}
+	if rw := serve(http.MethodPost, "/db/_ns/team-a/third", url.Values{"value": {"a"}}); rw.Code != http.StatusInsufficientStorage {
+		t.Errorf("Quota is lost after restart: %d", rw.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, droppedPrefix+"team-c-1")); !os.IsNotExist(err) {
+		t.Errorf("Directory of a dropped namespace is not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "orphan")); err != nil {
+		t.Errorf("Unregistered directory is removed: %v", err)
+	}
+	if rw := admin(http.MethodPost, "/admin/namespaces", `{"name": "orphan"}`); rw.Code != http.StatusConflict {
+		t.Errorf("Expected 409 creating a namespace over a directory, got %d", rw.Code)
+	}
+}
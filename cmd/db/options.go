@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var defaults = datastore.DefaultOptions()
+
+var (
+	dir               = flag.String("dir", "./out", "directory with the database segments")
+	segmentSize       = flag.Int64("segment-size", defaults.SegmentSize, "size in bytes after which a new segment is started")
+	segmentPrefix     = flag.String("segment-prefix", defaults.SegmentPrefix, "file name prefix of the segments")
+	mergeSegments     = flag.Int("merge-segments", defaults.MergePolicy.MaxSegments, "merge when there are more segments than this (0 disables)")
+	mergeGarbageRatio = flag.Float64("merge-garbage-ratio", defaults.MergePolicy.GarbageRatio, "merge when this share of sealed segments is garbage (0 disables)")
+	mergeInterval     = flag.Duration("merge-interval", defaults.MergePolicy.Interval, "merge sealed segments periodically (0 disables)")
+	syncPolicy        = flag.String("sync", string(defaults.Sync), "when to flush writes to disk: never, always or second")
+	cacheSize         = flag.Int("cache-size", defaults.CacheSize, "number of values cached in memory (0 disables)")
+	maxKeySize        = flag.Int("max-key-size", defaults.MaxKeySize, "maximum key size in bytes")
+	maxValueSize      = flag.Int("max-value-size", defaults.MaxValueSize, "maximum value size in bytes")
+)
+
+func options() datastore.Options {
+	return datastore.Options{
+		SegmentSize:   *segmentSize,
+		SegmentPrefix: *segmentPrefix,
+		MergePolicy: datastore.MergePolicy{
+			MaxSegments:  *mergeSegments,
+			GarbageRatio: *mergeGarbageRatio,
+			Interval:     *mergeInterval,
+		},
+		Sync:         datastore.SyncPolicy(*syncPolicy),
+		CacheSize:    *cacheSize,
+		MaxKeySize:   *maxKeySize,
+		MaxValueSize: *maxValueSize,
+	}
+}
+
+// applyEnv sets flags that were not given on the command line from the
+// environment: --segment-size is read from DB_SEGMENT_SIZE and so on.
+func applyEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := "DB_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if e := f.Value.Set(value); e != nil {
+				err = fmt.Errorf("invalid value %q of %s: %w", value, name, e)
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	size := fs.Int64("segment-size", 10, "")
+	sync := fs.String("sync", "never", "")
+	if err := fs.Parse([]string{"--sync=always"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DB_SEGMENT_SIZE", "200")
+	t.Setenv("DB_SYNC", "second")
+	if err := applyEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *size != 200 {
+		t.Errorf("Expected segment size from the environment, got %d", *size)
+	}
+	if *sync != "always" {
+		t.Errorf("Expected the command line to win over the environment, got %s", *sync)
+	}
+
+	t.Setenv("DB_SEGMENT_SIZE", "big")
+	fs.Parse(nil)
+	if err := applyEnv(fs); err == nil {
+		t.Error("Expected an error for a malformed value")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+var (
+	dir    = flag.String("dir", "./out", "datastore directory")
+	prefix = flag.String("prefix", datastore.DefaultOptions().SegmentPrefix, "segment file name prefix")
+	format = flag.String("format", datastore.FormatJSONL, "export/import format: jsonl or csv")
+)
+
+const usage = `Usage: dbctl [-dir path] <command> [args]
+
+Commands:
+  dump [segment...]  print records with offsets, types and sizes
+  verify             check framing and checksums of all segments
+  stats              report live and dead bytes per segment
+  get <key>          print the current value of a key
+  keys               list live keys
+  compact            merge all segments into one
+  export             write all keys to stdout in -format
+  import             load keys from stdin in -format
+
+compact, export and import lock the directory and fail while a db server
+is running on it.
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "dump":
+		err = dump(args)
+	case "verify":
+		err = verify()
+	case "stats":
+		err = stats()
+	case "get":
+		if len(args) != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = get(args[0])
+	case "keys":
+		err = keys()
+	case "compact":
+		err = compact()
+	case "export":
+		err = export()
+	case "import":
+		err = importKeys()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func dump(segments []string) error {
+	if len(segments) == 0 {
+		var err error
+		segments, err = datastore.Segments(*dir, *prefix)
+		if err != nil {
+			return err
+		}
+	}
+	for _, segment := range segments {
+		fmt.Printf("# %s\n", segment)
+		err := datastore.ScanSegment(segment, func(r datastore.Record) error {
+			fmt.Printf("%10d %8d %-6s %q = %q\n", r.Offset, r.Size, r.Type, r.Key, r.Value)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", segment, err)
+		}
+	}
+	return nil
+}
+
+func verify() error {
+	segments, err := datastore.Segments(*dir, *prefix)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, segment := range segments {
+		records, unchecked := 0, 0
+		err := datastore.ScanSegment(segment, func(r datastore.Record) error {
+			records++
+			if !r.Checksum {
+				unchecked++
+			}
+			return nil
+		})
+		if err != nil {
+			failed++
+			fmt.Printf("%s: FAILED after %d records: %s\n", filepath.Base(segment), records, err)
+			continue
+		}
+		fmt.Printf("%s: OK, %d records (%d without checksum)\n", filepath.Base(segment), records, unchecked)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d segments are corrupted", failed, len(segments))
+	}
+	return nil
+}
+
+type location struct {
+	segment int
+	record  datastore.Record
+}
+
+// scanAll reads every segment and returns the latest record of each key
+// along with the total number of bytes in every segment. The latest record
+// of a deleted key is a tombstone, which counts as live bytes until it is
+// merged away, as in datastore.Stats.
+func scanAll() ([]string, map[string]location, []int64, error) {
+	segments, err := datastore.Segments(*dir, *prefix)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	latest := make(map[string]location)
+	total := make([]int64, len(segments))
+	for i, segment := range segments {
+		i := i
+		err := datastore.ScanSegment(segment, func(r datastore.Record) error {
+			latest[r.Key] = location{i, r}
+			total[i] += int64(r.Size)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", segment, err)
+		}
+	}
+	return segments, latest, total, nil
+}
+
+func stats() error {
+	segments, latest, total, err := scanAll()
+	if err != nil {
+		return err
+	}
+	live := make([]int64, len(segments))
+	keys := make([]int, len(segments))
+	sumKeys := 0
+	for _, l := range latest {
+		live[l.segment] += int64(l.record.Size)
+		if !deleted(l) {
+			keys[l.segment]++
+			sumKeys++
+		}
+	}
+	fmt.Printf("%-16s %8s %12s %12s %12s %7s\n", "SEGMENT", "KEYS", "TOTAL", "LIVE", "DEAD", "GARBAGE")
+	var sumTotal, sumLive int64
+	for i, segment := range segments {
+		fmt.Printf("%-16s %8d %12d %12d %12d %6.1f%%\n", filepath.Base(segment), keys[i],
+			total[i], live[i], total[i]-live[i], garbage(total[i], live[i]))
+		sumTotal += total[i]
+		sumLive += live[i]
+	}
+	fmt.Printf("%-16s %8d %12d %12d %12d %6.1f%%\n", "total", sumKeys,
+		sumTotal, sumLive, sumTotal-sumLive, garbage(sumTotal, sumLive))
+	return nil
+}
+
+// deleted reports whether the latest record of a key is a tombstone.
+func deleted(l location) bool {
+	return l.record.Type == "tombstone"
+}
+
+func garbage(total, live int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(total-live) * 100 / float64(total)
+}
+
+func get(key string) error {
+	_, latest, _, err := scanAll()
+	if err != nil {
+		return err
+	}
+	l, ok := latest[key]
+	if !ok || deleted(l) {
+		return datastore.ErrNotFound
+	}
+	fmt.Printf("%s (%s)\n", l.record.Value, l.record.Type)
+	return nil
+}
+
+func keys() error {
+	_, latest, _, err := scanAll()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(latest))
+	for key, l := range latest {
+		if !deleted(l) {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		fmt.Println(key)
+	}
+	return nil
+}
+
+// withDb opens the database for the commands that change it. The datastore
+// locks its directory, so they fail while cmd/db serves it.
+func withDb(fn func(db *datastore.Db) error) error {
+	if _, err := os.Stat(*dir); err != nil {
+		return err
+	}
+	options := datastore.DefaultOptions()
+	options.SegmentPrefix = *prefix
+	db, err := datastore.NewDbWithOptions(*dir, options)
+	if errors.Is(err, datastore.ErrLocked) {
+		return fmt.Errorf("%w, stop the db server first", err)
+	}
+	if err != nil {
+		return err
+	}
+	err = fn(db)
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func compact() error {
+	return withDb(func(db *datastore.Db) error {
+		return db.Compact()
+	})
+}
+
+func export() error {
+	return withDb(func(db *datastore.Db) error {
+		return db.Export(os.Stdout, *format)
+	})
+}
+
+func importKeys() error {
+	return withDb(func(db *datastore.Db) error {
+		n, err := db.Import(os.Stdin, *format)
+		fmt.Fprintf(os.Stderr, "imported %d records\n", n)
+		return err
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// output returns what the command prints to stdout.
+func output(t *testing.T, command func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	err = command()
+	os.Stdout = stdout
+	w.Close()
+	return <-done, err
+}
+
+func TestCommands(t *testing.T) {
+	*dir = t.TempDir()
+	db, err := datastore.NewDb(*dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"b", "a", "deleted"} {
+		if err := db.Put(key, "value-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	expected := db.Stats()
+
+	// Поки базу відкрито, команди, що її змінюють, відмовляються працювати.
+	if err := compact(); !errors.Is(err, datastore.ErrLocked) {
+		t.Errorf("Expected compact to fail on an open db, got %v", err)
+	}
+	db.Close()
+
+	out, err := output(t, func() error { return dump(nil) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []string{`string "a" = "value-a"`, `tombstone "deleted" = ""`} {
+		if !strings.Contains(out, record) {
+			t.Errorf("Dump has no %s:\n%s", record, out)
+		}
+	}
+
+	if out, err := output(t, verify); err != nil || !strings.Contains(out, "OK, 4 records") {
+		t.Errorf("Verify failed: %v\n%s", err, out)
+	}
+	if out, err := output(t, keys); err != nil || out != "a\nb\n" {
+		t.Errorf("Unexpected keys %q: %v", out, err)
+	}
+	if out, err := output(t, func() error { return get("a") }); err != nil || out != "value-a (string)\n" {
+		t.Errorf("Unexpected value %q: %v", out, err)
+	}
+	if _, err := output(t, func() error { return get("deleted") }); err != datastore.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a deleted key, got %v", err)
+	}
+
+	// stats рахує так само, як datastore.Stats.
+	out, err = output(t, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	total := strings.Fields(lines[len(lines)-1])
+	if len(total) < 5 || total[1] != "2" || total[3] != strconv.FormatInt(expected.LiveBytes, 10) || total[4] != strconv.FormatInt(expected.DeadBytes, 10) {
+		t.Errorf("Stats %v differ from the datastore: %+v", total, expected)
+	}
+
+	if err := compact(); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := output(t, keys); err != nil || out != "a\nb\n" {
+		t.Errorf("Unexpected keys after compact %q: %v", out, err)
+	}
+}
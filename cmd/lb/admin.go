@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// envAdminToken is read when --admin-token is not given, so the token does
+// not have to appear in the command line.
+const envAdminToken = "LB_ADMIN_TOKEN"
+
+var (
+	adminPort  = flag.Int("admin-port", 8091, "port of the admin API (0 disables)")
+	adminToken = flag.String("admin-token", "", "bearer token required by the admin API, which is disabled without one (env "+envAdminToken+")")
+)
+
+// backendInfo describes a backend in the responses of the admin API.
+type backendInfo struct {
+	pool.Backend
+	Healthy  bool `json:"healthy"`
+	Draining bool `json:"draining"`
+	// Ejected backends fail too many requests and are kept out of the
+	// balancing by the outlier detection for a while.
+	Ejected bool `json:"ejected"`
+	// Breaker is the state of the circuit breaker: closed, open or
+	// half-open.
+	Breaker  string `json:"breaker"`
+	InFlight int64  `json:"inFlight"`
+	// Dynamic backends were added with the admin API.
+	Dynamic bool `json:"dynamic"`
+}
+
+func (s *server) info() backendInfo {
+	return backendInfo{
+		Backend:  s.Backend,
+		Healthy:  s.healthy.Load(),
+		Draining: s.draining.Load(),
+		Ejected:  s.ejected(time.Now()),
+		Breaker:  s.breaker.current().String(),
+		InFlight: s.inFlight.Load(),
+		Dynamic:  s.dynamic,
+	}
+}
+
+// adminHandler serves the admin API:
+//
+//	GET    /backends                  lists the backends with their state
+//	POST   /backends                  adds a backend given as {"address": ..., "weight": ..., "scheme": ..., "tags": [...]}
+//	DELETE /backends/{address}        removes a backend
+//	POST   /backends/{address}/drain  stops sending new clients to a backend
+//	DELETE /backends/{address}/drain  takes a backend out of the drain mode
+//
+// Every request must have the token in an "Authorization: Bearer" header.
+func adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /backends", func(rw http.ResponseWriter, r *http.Request) {
+		servers := current.Load().servers
+		infos := make([]backendInfo, 0, len(servers))
+		for _, s := range servers {
+			infos = append(infos, s.info())
+		}
+		writeJSON(rw, http.StatusOK, infos)
+	})
+	mux.HandleFunc("POST /backends", func(rw http.ResponseWriter, r *http.Request) {
+		var b pool.Backend
+		d := json.NewDecoder(r.Body)
+		d.DisallowUnknownFields()
+		if err := d.Decode(&b); err != nil {
+			http.Error(rw, "Expected a backend with an address, weight, scheme and tags", http.StatusBadRequest)
+			return
+		}
+		s, err := addBackend(b)
+		if err != nil {
+			adminError(rw, err)
+			return
+		}
+		writeJSON(rw, http.StatusCreated, s.info())
+	})
+	mux.HandleFunc("DELETE /backends/{address}", func(rw http.ResponseWriter, r *http.Request) {
+		if err := removeBackend(r.PathValue("address")); err != nil {
+			adminError(rw, err)
+		}
+	})
+	drain := func(draining bool) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			s, err := setDraining(r.PathValue("address"), draining)
+			if err != nil {
+				adminError(rw, err)
+				return
+			}
+			writeJSON(rw, http.StatusOK, s.info())
+		}
+	}
+	mux.HandleFunc("POST /backends/{address}/drain", drain(true))
+	mux.HandleFunc("DELETE /backends/{address}/drain", drain(false))
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			rw.Header().Set("www-authenticate", `Bearer realm="lb admin"`)
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(rw, r)
+	})
+}
+
+func adminError(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNoBackend):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errBackendExists):
+		http.Error(rw, err.Error(), http.StatusConflict)
+	default:
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+func adminRequest(c *C, token, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("authorization", "Bearer "+token)
+	}
+	rw := httptest.NewRecorder()
+	adminHandler("secret").ServeHTTP(rw, r)
+	return rw
+}
+
+func listBackends(c *C) []backendInfo {
+	rw := adminRequest(c, "secret", http.MethodGet, "/backends", "")
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	var infos []backendInfo
+	c.Assert(json.NewDecoder(rw.Body).Decode(&infos), IsNil)
+	return infos
+}
+
+func (s *MySuite) TestAdmin(c *C) {
+	applyBackends(c, defaultBackends)
+
+	c.Assert(adminRequest(c, "", http.MethodGet, "/backends", "").Code, Equals, http.StatusUnauthorized)
+	c.Assert(adminRequest(c, "wrong", http.MethodGet, "/backends", "").Code, Equals, http.StatusUnauthorized)
+	c.Assert(listBackends(c), HasLen, 3)
+
+	rw := adminRequest(c, "secret", http.MethodPost, "/backends", `{"address": "server4:8080", "weight": 2, "tags": ["canary"]}`)
+	c.Assert(rw.Code, Equals, http.StatusCreated, Commentf("%s", rw.Body))
+	c.Assert(adminRequest(c, "secret", http.MethodPost, "/backends", `{"address": "server4:8080"}`).Code, Equals, http.StatusConflict)
+	c.Assert(adminRequest(c, "secret", http.MethodPost, "/backends", `{"address": "server5"}`).Code, Equals, http.StatusBadRequest)
+	c.Assert(adminRequest(c, "secret", http.MethodPost, "/backends", `{"host": "server5:8080"}`).Code, Equals, http.StatusBadRequest)
+	infos := listBackends(c)
+	c.Assert(infos, HasLen, 4)
+	c.Assert(infos[3].Address, Equals, "server4:8080")
+	c.Assert(infos[3].Scheme, Equals, pool.SchemeHTTP)
+	c.Assert(infos[3].Dynamic, Equals, true)
+
+	for _, s := range current.Load().servers {
+		s.healthy.Store(true)
+	}
+	rw = adminRequest(c, "secret", http.MethodPost, "/backends/server1:8080/drain", "")
+	c.Assert(rw.Code, Equals, http.StatusOK)
+	drained := current.Load().find("server1:8080")
+	drained.inFlight.Add(1)
+	for i := 0; i < 100; i++ {
+		c.Assert(pickServer(requestFrom(fmt.Sprintf("10.0.0.%d:5000", i))).Address, Not(Equals), "server1:8080")
+	}
+	infos = listBackends(c)
+	c.Assert(infos[0].Draining, Equals, true)
+	c.Assert(infos[0].InFlight, Equals, int64(1))
+	drained.inFlight.Add(-1)
+
+	// Зміни через API переживають перезавантаження конфігурації.
+	applyBackends(c, defaultBackends)
+	infos = listBackends(c)
+	c.Assert(infos, HasLen, 4)
+	c.Assert(infos[0].Draining, Equals, true)
+	c.Assert(infos[0].Healthy, Equals, true)
+	c.Assert(adminRequest(c, "secret", http.MethodDelete, "/backends/server1:8080/drain", "").Code, Equals, http.StatusOK)
+	c.Assert(listBackends(c)[0].Draining, Equals, false)
+
+	c.Assert(adminRequest(c, "secret", http.MethodDelete, "/backends/server4:8080", "").Code, Equals, http.StatusOK)
+	c.Assert(adminRequest(c, "secret", http.MethodDelete, "/backends/server4:8080", "").Code, Equals, http.StatusNotFound)
+	c.Assert(adminRequest(c, "secret", http.MethodPost, "/backends/server4:8080/drain", "").Code, Equals, http.StatusNotFound)
+	c.Assert(listBackends(c), HasLen, 3)
+}
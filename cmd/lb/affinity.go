@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// maxPins limits the memory taken by the pins of the ip and header modes.
+// The clients that come when the table is full are balanced without
+// affinity until the expired pins are removed.
+const maxPins = 100000
+
+type pin struct {
+	address string
+	expires time.Time
+}
+
+// pinTable remembers the backends of the clients of the ip and header
+// modes. It refers to the backends by address, so the pins are kept across
+// the changes of the pool.
+type pinTable struct {
+	mu   sync.Mutex
+	pins map[string]pin
+}
+
+var pins = &pinTable{pins: make(map[string]pin)}
+
+// get returns the address of the backend pinned to the client.
+func (t *pinTable) get(key string, now time.Time) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pins[key]
+	if !ok || now.After(p.expires) {
+		return "", false
+	}
+	return p.address, true
+}
+
+// set pins the client to the backend until ttl passes without requests.
+func (t *pinTable) set(key, address string, now time.Time, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pins[key]; !ok && len(t.pins) >= maxPins {
+		for k, p := range t.pins {
+			if now.After(p.expires) {
+				delete(t.pins, k)
+			}
+		}
+		if len(t.pins) >= maxPins {
+			return
+		}
+	}
+	t.pins[key] = pin{address, now.Add(ttl)}
+}
+
+// backendID identifies a backend in the affinity cookie without showing
+// its address to the clients.
+func backendID(address string) string {
+	sum := sha256.Sum256([]byte(address))
+	return hex.EncodeToString(sum[:8])
+}
+
+// pinned returns the backend the client is pinned to if it is available.
+// key identifies the client in the ip and header modes and is empty when
+// the request has nothing to pin it by.
+func (b *snapshot) pinned(r *http.Request) (s *server, key string) {
+	a := b.config.Affinity
+	switch a.Mode {
+	case pool.AffinityCookie:
+		c, err := r.Cookie(a.Cookie)
+		if err != nil {
+			return nil, ""
+		}
+		for _, s := range b.servers {
+			if s.id == c.Value && s.available() {
+				return s, ""
+			}
+		}
+		return nil, ""
+	case pool.AffinityIP:
+		key = a.Mode + ":" + clientIP(r)
+	case pool.AffinityHeader:
+		if v := r.Header.Get(a.Header); v != "" {
+			key = a.Mode + ":" + v
+		}
+	}
+	if key == "" {
+		return nil, ""
+	}
+	if address, ok := pins.get(key, time.Now()); ok {
+		if s := b.find(address); s != nil && s.available() {
+			pins.set(key, address, time.Now(), time.Duration(a.TTL))
+			return s, key
+		}
+	}
+	return nil, key
+}
+
+// pin sets the affinity cookie of the backend in the response unless the
+// client already has it.
+func (b *snapshot) pin(rw http.ResponseWriter, r *http.Request, s *server) {
+	a := b.config.Affinity
+	if a.Mode != pool.AffinityCookie {
+		return
+	}
+	if c, err := r.Cookie(a.Cookie); err == nil && c.Value == s.id {
+		return
+	}
+	http.SetCookie(rw, &http.Cookie{
+		Name:     a.Cookie,
+		Value:    s.id,
+		Path:     "/",
+		MaxAge:   int(time.Duration(a.TTL).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// applyAffinity serves healthy backends balanced in turn, so that only the
+// affinity can send a client to the same one twice.
+func applyAffinity(c *C, affinity pool.Affinity) *snapshot {
+	config := &pool.Config{Policy: pool.PolicyRoundRobin, Affinity: affinity}
+	var err error
+	config.Backends, err = pool.ParseBackends(defaultBackends)
+	c.Assert(err, IsNil)
+	c.Assert(config.Validate(), IsNil)
+	apply(config)
+	b := current.Load()
+	for _, s := range b.servers {
+		s.healthy.Store(true)
+	}
+	return b
+}
+
+func (s *MySuite) TestAffinity_IP(c *C) {
+	b := applyAffinity(c, pool.Affinity{Mode: pool.AffinityIP})
+	first := b.choose(requestFrom("10.0.0.1:5000"), nil)
+	for port := 5001; port < 5010; port++ {
+		c.Assert(b.choose(requestFrom(fmt.Sprintf("10.0.0.1:%d", port)), nil).Address, Equals, first.Address)
+	}
+	c.Assert(b.choose(requestFrom("10.0.0.2:5000"), nil).Address, Not(Equals), first.Address)
+
+	// Клієнт перекріплюється до іншого сервера і лишається там, коли перший одужує.
+	first.healthy.Store(false)
+	failover := b.choose(requestFrom("10.0.0.1:5000"), nil)
+	c.Assert(failover, NotNil)
+	c.Assert(failover.Address, Not(Equals), first.Address)
+	first.healthy.Store(true)
+	for i := 0; i < 5; i++ {
+		c.Assert(b.choose(requestFrom("10.0.0.1:5000"), nil).Address, Equals, failover.Address)
+	}
+
+	// Прив'язки переживають перезавантаження конфігурації.
+	b = applyAffinity(c, pool.Affinity{Mode: pool.AffinityIP})
+	c.Assert(b.choose(requestFrom("10.0.0.1:5000"), nil).Address, Equals, failover.Address)
+}
+
+func (s *MySuite) TestAffinity_Header(c *C) {
+	b := applyAffinity(c, pool.Affinity{Mode: pool.AffinityHeader, Header: "x-user-id"})
+	request := func(address, user string) *http.Request {
+		r := requestFrom(address)
+		if user != "" {
+			r.Header.Set("X-User-ID", user)
+		}
+		return r
+	}
+	first := b.choose(request("10.0.0.1:5000", "alice"), nil)
+	for i := 0; i < 10; i++ {
+		c.Assert(b.choose(request(fmt.Sprintf("10.0.1.%d:5000", i), "alice"), nil).Address, Equals, first.Address)
+	}
+	c.Assert(b.choose(request("10.0.0.1:5000", "bob"), nil).Address, Not(Equals), first.Address)
+
+	chosen := make(map[*server]bool)
+	for i := 0; i < 3; i++ {
+		chosen[b.choose(request("10.0.0.1:5000", ""), nil)] = true
+	}
+	c.Assert(chosen, HasLen, 3)
+}
+
+func (s *MySuite) TestAffinity_Cookie(c *C) {
+	b := applyAffinity(c, pool.Affinity{Mode: pool.AffinityCookie, TTL: pool.Duration(30 * time.Minute)})
+	r := requestFrom("10.0.0.1:5000")
+	first := b.choose(r, nil)
+	rw := httptest.NewRecorder()
+	b.pin(rw, r, first)
+	cookies := rw.Result().Cookies()
+	c.Assert(cookies, HasLen, 1)
+	c.Assert(cookies[0].Name, Equals, pool.DefaultAffinityCookie)
+	c.Assert(cookies[0].MaxAge, Equals, 1800)
+	c.Assert(cookies[0].Value, Not(Matches), ".*server.*")
+
+	withCookie := func(cookie *http.Cookie) *http.Request {
+		r := requestFrom("10.0.1.1:5000")
+		r.AddCookie(cookie)
+		return r
+	}
+	for i := 0; i < 5; i++ {
+		r := withCookie(cookies[0])
+		c.Assert(b.choose(r, nil).Address, Equals, first.Address)
+		rw := httptest.NewRecorder()
+		b.pin(rw, r, first)
+		c.Assert(rw.Result().Cookies(), HasLen, 0)
+	}
+
+	first.healthy.Store(false)
+	r = withCookie(cookies[0])
+	failover := b.choose(r, nil)
+	c.Assert(failover.Address, Not(Equals), first.Address)
+	rw = httptest.NewRecorder()
+	b.pin(rw, r, failover)
+	c.Assert(rw.Result().Cookies(), HasLen, 1)
+	c.Assert(rw.Result().Cookies()[0].Value, Equals, failover.id)
+
+	c.Assert(b.choose(withCookie(&http.Cookie{Name: pool.DefaultAffinityCookie, Value: "server1:8080"}), nil), NotNil)
+}
+
+func (s *MySuite) TestPinTable(c *C) {
+	t := &pinTable{pins: make(map[string]pin)}
+	now := time.Now()
+	t.set("ip:10.0.0.1", "server1:8080", now, time.Minute)
+	address, ok := t.get("ip:10.0.0.1", now.Add(30*time.Second))
+	c.Assert(ok, Equals, true)
+	c.Assert(address, Equals, "server1:8080")
+	_, ok = t.get("ip:10.0.0.1", now.Add(2*time.Minute))
+	c.Assert(ok, Equals, false)
+
+	for i := 0; i < maxPins; i++ {
+		t.pins[fmt.Sprint(i)] = pin{"server1:8080", now.Add(time.Minute)}
+	}
+	t.set("ip:10.0.0.2", "server2:8080", now, time.Minute)
+	_, ok = t.get("ip:10.0.0.2", now)
+	c.Assert(ok, Equals, false)
+	t.set("ip:10.0.0.2", "server2:8080", now.Add(2*time.Minute), time.Minute)
+	_, ok = t.get("ip:10.0.0.2", now.Add(2*time.Minute))
+	c.Assert(ok, Equals, true)
+	c.Assert(len(t.pins) < maxPins, Equals, true)
+}
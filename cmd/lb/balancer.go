@@ -1,150 +1,177 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
-	"sync"
-	"crypto/sha256"
-	"encoding/binary"
 
 	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
 	"github.com/roman-mazur/architecture-practice-4-template/signal"
 )
 
+// defaultBackends are the servers of docker-compose.yaml.
+const defaultBackends = "server1:8080,server2:8080,server3:8080"
+
 var (
-	port = flag.Int("port", 8090, "load balancer port")
+	port       = flag.Int("port", 8090, "load balancer port")
 	timeoutSec = flag.Int("timeout-sec", 3, "request timeout time in seconds")
-	https = flag.Bool("https", false, "whether backends support HTTPs")
+	poolFlags  = pool.RegisterFlags(flag.CommandLine, defaultBackends).WithPolicy()
+
+	watchInterval = flag.Duration("watch-interval", 2*time.Second, "how often to check the config file for changes (0 disables)")
 
 	traceEnabled = flag.Bool("trace", false, "whether to include tracing information into responses")
 )
 
-var (
-	timeout = time.Duration(*timeoutSec) * time.Second
-	serversPool = []string{
-		"server1:8080",
-		"server2:8080",
-		"server3:8080",
-	}
-	healthyServers = make([]bool, len(serversPool))
-	mu             sync.Mutex
-)
+var timeout = 3 * time.Second
 
-func scheme() string {
-	if *https {
-		return "https"
-	}
-	return "http"
+// result is the outcome of a forwarded request.
+type result struct {
+	status int
+	// latency is the time until the response headers.
+	latency time.Duration
+	err     error
 }
 
-func health(dst string) bool {
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
-	req, _ := http.NewRequestWithContext(ctx, "GET",
-		fmt.Sprintf("%s://%s/health", scheme(), dst), nil)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false
-	}
-	if resp.StatusCode != http.StatusOK {
-		return false
-	}
-	return true
+// failed reports whether the backend failed the request.
+func (res result) failed() bool {
+	return res.err != nil || res.status >= http.StatusInternalServerError
 }
 
-func forward(dst string, rw http.ResponseWriter, r *http.Request) error {
-	ctx, _ := context.WithTimeout(r.Context(), timeout)
+// send forwards the request to the backend. body replaces the request body
+// when it is buffered to be replayed. The response, if any, must be closed
+// and cancel called once it is read.
+func send(dst pool.Backend, r *http.Request, body []byte) (*http.Response, context.CancelFunc, result) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
 	fwdRequest := r.Clone(ctx)
 	fwdRequest.RequestURI = ""
-	fwdRequest.URL.Host = dst
-	fwdRequest.URL.Scheme = scheme()
-	fwdRequest.Host = dst
+	fwdRequest.URL.Host = dst.Address
+	fwdRequest.URL.Scheme = dst.Scheme
+	fwdRequest.Host = dst.Address
+	if body != nil {
+		fwdRequest.Body = io.NopCloser(bytes.NewReader(body))
+	}
 
+	start := time.Now()
 	resp, err := http.DefaultClient.Do(fwdRequest)
-	if err == nil {
-		for k, values := range resp.Header {
-			for _, value := range values {
-				rw.Header().Add(k, value)
-			}
-		}
-		if *traceEnabled {
-			rw.Header().Set("lb-from", dst)
-		}
-		log.Println("fwd", resp.StatusCode, resp.Request.URL)
-		rw.WriteHeader(resp.StatusCode)
-		defer resp.Body.Close()
-		_, err := io.Copy(rw, resp.Body)
-		if err != nil {
-			log.Printf("Failed to write response: %s", err)
-		}
-		return nil
-	} else {
-		log.Printf("Failed to get response from %s: %s", dst, err)
-		rw.WriteHeader(http.StatusServiceUnavailable)
-		return err
+	res := result{latency: time.Since(start), err: err}
+	if err != nil {
+		log.Printf("Failed to get response from %s: %s", dst.Address, err)
+		return nil, cancel, res
 	}
+	res.status = resp.StatusCode
+	return resp, cancel, res
 }
 
-func hash(s string) uint32 {
-	h := sha256.New()
-	h.Write([]byte(s))
-	hashBytes := h.Sum(nil)
-	hashValue := binary.BigEndian.Uint32(hashBytes)
-	return hashValue
+// respond copies the response of the backend to the client, or responds
+// with 503 if there is none. attempts is the number of backends tried.
+func respond(rw http.ResponseWriter, dst pool.Backend, resp *http.Response, attempts int) {
+	if *traceEnabled {
+		rw.Header().Set("lb-from", dst.Address)
+		rw.Header().Set("lb-attempts", strconv.Itoa(attempts))
+	}
+	if resp == nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	for k, values := range resp.Header {
+		for _, value := range values {
+			rw.Header().Add(k, value)
+		}
+	}
+	log.Println("fwd", resp.StatusCode, resp.Request.URL)
+	rw.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(rw, resp.Body); err != nil {
+		log.Printf("Failed to write response: %s", err)
+	}
 }
 
-func updateHealthyServers() {
-	for i, server := range serversPool {
-		server := server
-		i := i
-		go func() {
-			for range time.Tick(10 * time.Second) {
-				mu.Lock()
-				healthyServers[i] = health(server)
-				mu.Unlock()
-			}
-		}()
+// serve forwards the request to a backend admitted by its circuit breaker,
+// retrying the failures on other backends while the retry configuration and
+// budget allow it.
+func serve(rw http.ResponseWriter, r *http.Request) {
+	snapshot := current.Load()
+	config := snapshot.config.Retry
+	budget.request(time.Now())
+	body, replayable, err := replayBody(r, config.MaxBody)
+	if err != nil {
+		http.Error(rw, "Failed to read request body", http.StatusBadRequest)
+		return
 	}
-}
 
-func chooseServer(address string) string {
-	mu.Lock()
-	defer mu.Unlock()
-
-	serverIndex := hash(address) % uint32(len(serversPool))
-	
-	originalIndex := serverIndex
-	for !healthyServers[serverIndex] {
-		serverIndex = (serverIndex + 1) % uint32(len(serversPool))
-		if serverIndex == originalIndex {
-			return ""
+	tried := make(map[*server]bool)
+	s, probe := snapshot.pick(r, tried)
+	if s == nil {
+		http.Error(rw, "All servers are not healthy", http.StatusServiceUnavailable)
+		return
+	}
+	for {
+		tried[s] = true
+		s.inFlight.Add(1)
+		resp, cancel, res := send(s.Backend, r, body)
+		s.inFlight.Add(-1)
+		snapshot.record(s, res)
+		snapshot.settle(s, res, probe, time.Now())
+
+		var next *server
+		if len(tried) < config.Attempts && replayable && res.failed() && retryable(r, res, config) {
+			if budget.allow(time.Now(), config) {
+				next, probe = snapshot.pick(r, tried)
+			} else {
+				log.Printf("Retry budget is exhausted, not retrying %s %s", r.Method, r.URL)
+			}
+		}
+		if next == nil {
+			snapshot.pin(rw, r, s)
+			respond(rw, s.Backend, resp, len(tried))
+			if resp != nil {
+				resp.Body.Close()
+			}
+			cancel()
+			if res.err != nil {
+				log.Printf("Failed to forward request: %s", res.err)
+			}
+			return
+		}
+		if resp != nil {
+			resp.Body.Close()
 		}
+		cancel()
+		log.Printf("Retrying %s %s on %s after %s failed it (attempt %d of %d)", r.Method, r.URL, next.Address, s.Address, len(tried)+1, config.Attempts)
+		s = next
 	}
-
-	return serversPool[serverIndex]
 }
 
 func main() {
 	flag.Parse()
+	timeout = time.Duration(*timeoutSec) * time.Second
 
-	
-	updateHealthyServers()
+	config, err := poolFlags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	apply(config)
+	go watchConfig(poolFlags, *watchInterval)
+	go detectOutliers()
 
-	frontend := httptools.CreateServer(*port, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		server := chooseServer(r.RemoteAddr)
-		if server == "" {
-			http.Error(rw, "All servers are not healthy", http.StatusServiceUnavailable)
-			return
-		}
-		err := forward(server, rw, r)
-		if err != nil {
-			log.Printf("Failed to forward request: %s", err)
-		}
-	}))
+	frontend := httptools.CreateServer(*port, http.HandlerFunc(serve))
+
+	token := *adminToken
+	if token == "" {
+		token = os.Getenv(envAdminToken)
+	}
+	if *adminPort != 0 && token != "" {
+		httptools.CreateServer(*adminPort, adminHandler(token)).Start()
+		log.Printf("Admin API is listening on port %d", *adminPort)
+	} else if *adminPort != 0 {
+		log.Printf("Admin API is disabled without --admin-token or %s", envAdminToken)
+	}
 
 	log.Println("Starting load balancer...")
 	log.Printf("Tracing support enabled: %t", *traceEnabled)
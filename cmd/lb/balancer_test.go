@@ -1,8 +1,15 @@
 package main
 
 import (
-	. "gopkg.in/check.v1"
+	"flag"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/hashring"
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
 )
 
 func Test(t *testing.T) { TestingT(t) }
@@ -11,45 +18,102 @@ type MySuite struct{}
 
 var _ = Suite(&MySuite{})
 
+// SetUpSuite keeps the health checks from probing the backends over the
+// network: a probe reports the health the test has set.
+func (s *MySuite) SetUpSuite(c *C) {
+	probe = func(s *server, _ pool.HealthCheck) bool {
+		return s.healthy.Load()
+	}
+}
+
+// SetUpTest starts every test with an empty pool.
+func (s *MySuite) SetUpTest(c *C) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	pins = &pinTable{pins: make(map[string]pin)}
+	budget = new(retryBudget)
+	if old := current.Swap(nil); old != nil {
+		for _, s := range old.servers {
+			close(s.stop)
+		}
+	}
+}
+
 func (s *MySuite) TestScheme(c *C) {
-	*https = true
-	c.Assert(scheme(), Equals, "https")
+	fs := flag.NewFlagSet("lb", flag.ContinueOnError)
+	flags := pool.RegisterFlags(fs, defaultBackends)
+	c.Assert(fs.Parse([]string{"--https"}), IsNil)
+	config, err := flags.Load()
+	c.Assert(err, IsNil)
+	c.Assert(config.Backends[0].URL("/health"), Equals, "https://server1:8080/health")
+
+	fs = flag.NewFlagSet("lb", flag.ContinueOnError)
+	flags = pool.RegisterFlags(fs, defaultBackends)
+	c.Assert(fs.Parse(nil), IsNil)
+	config, err = flags.Load()
+	c.Assert(err, IsNil)
+	c.Assert(config.Backends[0].URL("/health"), Equals, "http://server1:8080/health")
+}
+
+// requestFrom creates a request of the client with the address.
+func requestFrom(address string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = address
+	return r
+}
 
-	*https = false
-	c.Assert(scheme(), Equals, "http")
+// pickServer chooses the backend of the request the way the frontend does,
+// through the circuit breakers, and returns nil if none can take it.
+func pickServer(r *http.Request) *server {
+	s, _ := current.Load().pick(r, nil)
+	return s
+}
+
+// applyBackends starts serving the backends with the default settings.
+func applyBackends(c *C, backends string) {
+	config := &pool.Config{}
+	var err error
+	config.Backends, err = pool.ParseBackends(backends)
+	c.Assert(err, IsNil)
+	c.Assert(config.Validate(), IsNil)
+	apply(config)
 }
 
 func (s *MySuite) TestHash(c *C) {
 	str := "testString"
-	c.Assert(hash(str), FitsTypeOf, uint32(0))
+	c.Assert(hashring.Hash(str), FitsTypeOf, uint32(0))
 }
 
 func (s *MySuite) TestLoadBalancer(c *C) {
+	applyBackends(c, defaultBackends)
+
 	address1 := "192.168.110.10:54321"
 	address2 := "192.168.110.20:54321"
 	address3 := "172.151.110.40:54324"
 
-	for i := range healthyServers {
-		healthyServers[i] = true
+	c.Assert(pickServer(requestFrom(address1)), IsNil)
+
+	for _, s := range current.Load().servers {
+		s.healthy.Store(true)
 	}
 
-	firstServeraddress1 := chooseServer(address1)
-	c.Assert(firstServeraddress1, Not(Equals), "")
+	firstServeraddress1 := pickServer(requestFrom(address1))
+	c.Assert(firstServeraddress1, NotNil)
 
-	firstServeraddress2 := chooseServer(address2)
-	c.Assert(firstServeraddress2, Not(Equals), "")
+	firstServeraddress2 := pickServer(requestFrom(address2))
+	c.Assert(firstServeraddress2, NotNil)
 
-	firstServeraddress3 := chooseServer(address3)
-	c.Assert(firstServeraddress3, Not(Equals), "")
+	firstServeraddress3 := pickServer(requestFrom(address3))
+	c.Assert(firstServeraddress3, NotNil)
 
 	for i := 0; i < 10; i++ {
-		serveraddress1 := chooseServer(address1)
-		c.Assert(serveraddress1, Equals, firstServeraddress1)
+		serveraddress1 := pickServer(requestFrom(address1))
+		c.Assert(serveraddress1.Address, Equals, firstServeraddress1.Address)
 
-		serveraddress2 := chooseServer(address2)
-		c.Assert(serveraddress2, Equals, firstServeraddress2)
+		serveraddress2 := pickServer(requestFrom(address2))
+		c.Assert(serveraddress2.Address, Equals, firstServeraddress2.Address)
 
-		serveraddress3 := chooseServer(address3)
-		c.Assert(serveraddress3, Equals, firstServeraddress3)
+		serveraddress3 := pickServer(requestFrom(address3))
+		c.Assert(serveraddress3.Address, Equals, firstServeraddress3.Address)
 	}
 }
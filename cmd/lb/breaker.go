@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (st breakerState) String() string {
+	switch st {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// breakerBuckets is the number of parts of the rolling window of the error
+// rate, the oldest one is dropped as the window moves.
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	// slot is the number of the period of the bucket since the epoch.
+	slot     int64
+	requests int
+	failures int
+}
+
+// circuitBreaker stops the requests to a backend failing them, see
+// pool.CircuitBreaker.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       breakerState
+	openUntil   time.Time
+	consecutive int
+	buckets     [breakerBuckets]breakerBucket
+	// probes is the number of the probe requests in flight, up to probeMax,
+	// and succeeded is the number of the ones that succeeded.
+	probes    int
+	probeMax  int
+	succeeded int
+}
+
+// admits reports whether the breaker would let a request through.
+func (cb *circuitBreaker) admits(now time.Time) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		return !now.Before(cb.openUntil)
+	case breakerHalfOpen:
+		return cb.probes < cb.probeMax
+	}
+	return true
+}
+
+// current returns the state of the breaker.
+func (cb *circuitBreaker) current() breakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// reset closes the breaker.
+func (cb *circuitBreaker) reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.close()
+}
+
+// close lets the requests through and forgets the earlier ones. mu must be
+// held.
+func (cb *circuitBreaker) close() {
+	cb.state = breakerClosed
+	cb.consecutive = 0
+	cb.buckets = [breakerBuckets]breakerBucket{}
+	cb.probes, cb.succeeded = 0, 0
+}
+
+// open trips the breaker. mu must be held.
+func (cb *circuitBreaker) open(now time.Time, d time.Duration) {
+	cb.close()
+	cb.state = breakerOpen
+	cb.openUntil = now.Add(d)
+}
+
+// count adds the request to the rolling window and returns the totals of
+// the window. mu must be held.
+func (cb *circuitBreaker) count(now time.Time, window time.Duration, failed bool) (requests, failures int) {
+	period := max(window/breakerBuckets, time.Millisecond)
+	slot := now.UnixNano() / int64(period)
+	b := &cb.buckets[slot%breakerBuckets]
+	if b.slot != slot {
+		*b = breakerBucket{slot: slot}
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	for _, b := range cb.buckets {
+		if slot-b.slot < breakerBuckets {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+// admit lets a request to the server through its breaker. An open breaker
+// turns half-open once its time is over, and a half-open one admits a
+// limited number of probe requests. probe reports that the request is one.
+func (b *snapshot) admit(s *server, now time.Time) (admitted, probe bool) {
+	config := b.config.CircuitBreaker
+	if config.Disabled {
+		return true, false
+	}
+	cb := &s.breaker
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case breakerClosed:
+		return true, false
+	case breakerOpen:
+		if now.Before(cb.openUntil) {
+			return false, false
+		}
+		cb.state, cb.probes, cb.probeMax, cb.succeeded = breakerHalfOpen, 0, config.Probes, 0
+		log.Printf("Circuit breaker of backend %s is half-open, probing it with %d requests", s.Address, config.Probes)
+	}
+	if cb.probes >= cb.probeMax {
+		return false, false
+	}
+	cb.probes++
+	return true, true
+}
+
+// settle feeds the result of an admitted request to the breaker of the
+// server. The results of the requests admitted before the breaker changed
+// its state are ignored.
+func (b *snapshot) settle(s *server, res result, probe bool, now time.Time) {
+	config := b.config.CircuitBreaker
+	if config.Disabled {
+		return
+	}
+	failed := res.failed() || config.SlowRequest > 0 && res.latency >= time.Duration(config.SlowRequest)
+	cb := &s.breaker
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch {
+	case probe && cb.state == breakerHalfOpen:
+		cb.probes--
+		if failed {
+			cb.open(now, time.Duration(config.OpenTime))
+			log.Printf("Circuit breaker of backend %s is open for %s: a probe request failed", s.Address, time.Duration(config.OpenTime))
+			return
+		}
+		if cb.succeeded++; cb.succeeded >= cb.probeMax {
+			cb.close()
+			log.Printf("Circuit breaker of backend %s is closed: %d probe requests succeeded", s.Address, cb.probeMax)
+		}
+	case !probe && cb.state == breakerClosed:
+		if failed {
+			cb.consecutive++
+		} else {
+			cb.consecutive = 0
+		}
+		requests, failures := cb.count(now, time.Duration(config.Window), failed)
+		var reason string
+		if config.Failures > 0 && cb.consecutive >= config.Failures {
+			reason = fmt.Sprintf("%d failed requests in a row", cb.consecutive)
+		} else if failed && config.ErrorRate > 0 && requests >= config.MinRequests && float64(failures)/float64(requests) >= config.ErrorRate {
+			reason = fmt.Sprintf("%d of %d requests failed in %s", failures, requests, time.Duration(config.Window))
+		}
+		if reason != "" {
+			cb.open(now, time.Duration(config.OpenTime))
+			log.Printf("Circuit breaker of backend %s is open for %s: %s", s.Address, time.Duration(config.OpenTime), reason)
+		}
+	}
+}
+
+// pick chooses a backend for an attempt of the request among the ones not
+// tried yet and admits the request through its breaker.
+func (b *snapshot) pick(r *http.Request, tried map[*server]bool) (s *server, probe bool) {
+	rejected := maps.Clone(tried)
+	if rejected == nil {
+		rejected = make(map[*server]bool)
+	}
+	for {
+		s = b.choose(r, rejected)
+		if s == nil {
+			return nil, false
+		}
+		if admitted, probe := b.admit(s, time.Now()); admitted {
+			return s, probe
+		}
+		rejected[s] = true
+	}
+}
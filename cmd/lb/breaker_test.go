@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// applyBreakers serves four healthy backends with the circuit breakers and
+// without the outlier detection.
+func applyBreakers(c *C, cb pool.CircuitBreaker) *snapshot {
+	config := &pool.Config{Policy: pool.PolicyRoundRobin, CircuitBreaker: cb, OutlierDetection: pool.OutlierDetection{Disabled: true}}
+	var err error
+	config.Backends, err = pool.ParseBackends("server1:8080,server2:8080,server3:8080,server4:8080")
+	c.Assert(err, IsNil)
+	c.Assert(config.Validate(), IsNil)
+	apply(config)
+	b := current.Load()
+	for _, s := range b.servers {
+		s.healthy.Store(true)
+	}
+	return b
+}
+
+func (s *MySuite) TestBreaker_States(c *C) {
+	b := applyBreakers(c, pool.CircuitBreaker{Failures: 3, Probes: 2})
+	bad := b.servers[0]
+	now := time.Now()
+	b.settle(bad, failure, false, now)
+	b.settle(bad, failure, false, now)
+	b.settle(bad, success, false, now)
+	b.settle(bad, failure, false, now)
+	b.settle(bad, failure, false, now)
+	c.Assert(bad.breaker.current(), Equals, breakerClosed)
+	b.settle(bad, failure, false, now)
+	c.Assert(bad.breaker.current(), Equals, breakerOpen)
+	c.Assert(bad.available(), Equals, false)
+	c.Assert(bad.info().Breaker, Equals, "open")
+	for i := 0; i < 100; i++ {
+		server, _ := b.pick(requestFrom(fmt.Sprintf("10.0.0.%d:5000", i)), nil)
+		c.Assert(server.Address, Not(Equals), bad.Address)
+	}
+
+	// Після OpenTime пропускається лише Probes пробних запитів.
+	admitted, _ := b.admit(bad, now.Add(pool.DefaultBreakerOpenTime-time.Second))
+	c.Assert(admitted, Equals, false)
+	later := now.Add(pool.DefaultBreakerOpenTime)
+	for i := 0; i < 2; i++ {
+		admitted, probe := b.admit(bad, later)
+		c.Assert(admitted, Equals, true)
+		c.Assert(probe, Equals, true)
+	}
+	admitted, _ = b.admit(bad, later)
+	c.Assert(admitted, Equals, false)
+	c.Assert(bad.breaker.current(), Equals, breakerHalfOpen)
+
+	// Результати запитів, пропущених до відкриття, не враховуються.
+	b.settle(bad, failure, false, later)
+	b.settle(bad, success, true, later)
+	c.Assert(bad.breaker.current(), Equals, breakerHalfOpen)
+	b.settle(bad, failure, true, later)
+	c.Assert(bad.breaker.current(), Equals, breakerOpen)
+
+	later = later.Add(pool.DefaultBreakerOpenTime)
+	for i := 0; i < 2; i++ {
+		_, probe := b.admit(bad, later)
+		b.settle(bad, success, probe, later)
+	}
+	c.Assert(bad.breaker.current(), Equals, breakerClosed)
+	c.Assert(bad.available(), Equals, true)
+}
+
+func (s *MySuite) TestBreaker_ErrorRate(c *C) {
+	b := applyBreakers(c, pool.CircuitBreaker{Failures: -1, MinRequests: 10, Window: pool.Duration(10 * time.Second)})
+	bad := b.servers[0]
+	now := time.Unix(1000, 0)
+
+	// Старі запити випадають з ковзного вікна.
+	for i := 0; i < 8; i++ {
+		b.settle(bad, failure, false, now)
+	}
+	now = now.Add(10 * time.Second)
+	b.settle(bad, success, false, now)
+	b.settle(bad, failure, false, now)
+	b.settle(bad, failure, false, now)
+	c.Assert(bad.breaker.current(), Equals, breakerClosed)
+	for i := 0; i < 7; i++ {
+		b.settle(bad, success, false, now)
+	}
+
+	now = now.Add(5 * time.Second)
+	for i := 0; i < 5; i++ {
+		b.settle(bad, failure, false, now)
+	}
+	c.Assert(bad.breaker.current(), Equals, breakerClosed)
+	b.settle(bad, failure, false, now)
+	c.Assert(bad.breaker.current(), Equals, breakerOpen)
+}
+
+func (s *MySuite) TestBreaker_SlowRequests(c *C) {
+	b := applyBreakers(c, pool.CircuitBreaker{Failures: 2, SlowRequest: pool.Duration(time.Second)})
+	slow := b.servers[0]
+	b.settle(slow, result{status: http.StatusOK, latency: 2 * time.Second}, false, time.Now())
+	b.settle(slow, result{status: http.StatusOK, latency: time.Second}, false, time.Now())
+	c.Assert(slow.breaker.current(), Equals, breakerOpen)
+
+	b = applyBreakers(c, pool.CircuitBreaker{Disabled: true})
+	c.Assert(slow.breaker.current(), Equals, breakerClosed)
+	for i := 0; i < 10; i++ {
+		b.settle(slow, failure, false, time.Now())
+	}
+	c.Assert(slow.available(), Equals, true)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// probe checks the health of a server, tests replace it to avoid the
+// network.
+var probe = func(s *server, check pool.HealthCheck) bool {
+	return health(s.Backend, check)
+}
+
+// health sends a probe to the backend and reports whether it passed.
+func health(dst pool.Backend, check pool.HealthCheck) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(check.Timeout))
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", dst.URL(check.Path), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	// Дочитуємо тіло, щоб з'єднання повернулося в пул.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode == check.Status
+}
+
+// checkHealth probes the server every interval with jitter until it is
+// removed from the pool. A new backend is probed right away, while one that
+// was checked before, e.g. before a reload, waits for the next interval.
+func (s *server) checkHealth(check pool.HealthCheck, probe func(*server, pool.HealthCheck) bool) {
+	var first time.Duration
+	if s.wasChecked() {
+		first = jittered(time.Duration(check.Interval), *check.Jitter)
+	}
+	timer := time.NewTimer(first)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C:
+		}
+		passed := probe(s, check)
+		select {
+		case <-s.stop:
+			// Результат перевірки видаленого сервера вже нікому не потрібен.
+			return
+		default:
+		}
+		if s.observe(passed, check) {
+			log.Printf("Backend %s is healthy: %t", s.Address, passed)
+		}
+		timer.Reset(jittered(time.Duration(check.Interval), *check.Jitter))
+	}
+}
+
+// observe records the result of a probe and reports whether it changed the
+// health of the backend. The first probe decides the health, then it takes
+// check.Rise or check.Fall probes in a row to change it.
+func (st *backendState) observe(passed bool, check pool.HealthCheck) bool {
+	st.probeMu.Lock()
+	defer st.probeMu.Unlock()
+	healthy := st.healthy.Load()
+	if !st.checked {
+		st.checked = true
+		st.healthy.Store(passed)
+		return passed != healthy
+	}
+	if passed == healthy {
+		st.streak = 0
+		return false
+	}
+	st.streak++
+	threshold := check.Fall
+	if passed {
+		threshold = check.Rise
+	}
+	if st.streak < threshold {
+		return false
+	}
+	st.streak = 0
+	st.healthy.Store(passed)
+	return true
+}
+
+// wasChecked reports whether the backend was probed at least once.
+func (st *backendState) wasChecked() bool {
+	st.probeMu.Lock()
+	defer st.probeMu.Unlock()
+	return st.checked
+}
+
+// jittered randomly changes d by up to the fraction jitter.
+func jittered(d time.Duration, jitter float64) time.Duration {
+	return time.Duration(float64(d) * (1 + jitter*(2*rand.Float64()-1)))
+}
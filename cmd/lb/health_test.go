@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// testHealthCheck fills in the defaults of the health check.
+func testHealthCheck(c *C, check pool.HealthCheck) pool.HealthCheck {
+	config := pool.Config{HealthCheck: check, Backends: []pool.Backend{{Address: "server1:8080"}}}
+	c.Assert(config.Validate(), IsNil)
+	return config.HealthCheck
+}
+
+func (s *MySuite) TestHealth(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			_, _ = rw.Write([]byte(strings.Repeat("ok", 1000)))
+		case "/ready":
+			rw.WriteHeader(http.StatusNoContent)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	b := pool.Backend{Address: strings.TrimPrefix(backend.URL, "http://"), Scheme: pool.SchemeHTTP}
+
+	c.Assert(health(b, testHealthCheck(c, pool.HealthCheck{})), Equals, true)
+	c.Assert(health(b, testHealthCheck(c, pool.HealthCheck{Path: "/ready"})), Equals, false)
+	c.Assert(health(b, testHealthCheck(c, pool.HealthCheck{Path: "/ready", Status: http.StatusNoContent})), Equals, true)
+	c.Assert(health(b, testHealthCheck(c, pool.HealthCheck{Path: "/down"})), Equals, false)
+	c.Assert(health(b, testHealthCheck(c, pool.HealthCheck{Path: "/slow", Timeout: pool.Duration(50 * time.Millisecond)})), Equals, false)
+}
+
+func (s *MySuite) TestHealth_Thresholds(c *C) {
+	check := testHealthCheck(c, pool.HealthCheck{Rise: 2, Fall: 3})
+	st := new(backendState)
+
+	// Перша перевірка одразу визначає стан.
+	c.Assert(st.observe(true, check), Equals, true)
+	c.Assert(st.healthy.Load(), Equals, true)
+
+	c.Assert(st.observe(false, check), Equals, false)
+	c.Assert(st.observe(false, check), Equals, false)
+	c.Assert(st.observe(true, check), Equals, false)
+	c.Assert(st.observe(false, check), Equals, false)
+	c.Assert(st.observe(false, check), Equals, false)
+	c.Assert(st.healthy.Load(), Equals, true)
+	c.Assert(st.observe(false, check), Equals, true)
+	c.Assert(st.healthy.Load(), Equals, false)
+
+	c.Assert(st.observe(true, check), Equals, false)
+	c.Assert(st.observe(true, check), Equals, true)
+	c.Assert(st.healthy.Load(), Equals, true)
+}
+
+func (s *MySuite) TestHealth_ImmediateProbe(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	stub := probe
+	probe = func(s *server, check pool.HealthCheck) bool {
+		return health(s.Backend, check)
+	}
+	defer func() { probe = stub }()
+
+	config := &pool.Config{
+		HealthCheck: pool.HealthCheck{Interval: pool.Duration(time.Hour)},
+		Backends:    []pool.Backend{{Address: strings.TrimPrefix(backend.URL, "http://")}},
+	}
+	c.Assert(config.Validate(), IsNil)
+	apply(config)
+	server := current.Load().servers[0]
+	for i := 0; i < 100 && !server.healthy.Load(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(server.healthy.Load(), Equals, true)
+}
+
+func (s *MySuite) TestJittered(c *C) {
+	for i := 0; i < 100; i++ {
+		d := jittered(10*time.Second, 0.1)
+		c.Assert(d >= 9*time.Second && d <= 11*time.Second, Equals, true, Commentf("%s", d))
+	}
+	c.Assert(jittered(time.Second, 0), Equals, time.Second)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ejectMu serializes the ejections, so that the limit of the ejected share
+// of the pool holds.
+var ejectMu sync.Mutex
+
+// maxEjectionShift keeps the exponential ejection time from overflowing.
+const maxEjectionShift = 20
+
+// outlierStats are the results of the requests proxied to a backend.
+type outlierStats struct {
+	mu          sync.Mutex
+	consecutive int
+	// requests, errors and latency are counted in the current window.
+	requests int
+	errors   int
+	latency  time.Duration
+	// ejections is the number of the recent ejections of the backend, each
+	// one lasts twice as long as the previous. It is guarded by ejectMu.
+	ejections int
+}
+
+// ejected reports whether the backend is ejected by the outlier detection.
+func (st *backendState) ejected(now time.Time) bool {
+	return now.UnixNano() < st.ejectedUntil.Load()
+}
+
+// record feeds the result of a proxied request to the outlier detection. A
+// backend failing the configured number of requests in a row is ejected
+// right away.
+func (b *snapshot) record(s *server, res result) {
+	o := b.config.OutlierDetection
+	if o.Disabled {
+		return
+	}
+	s.outlier.mu.Lock()
+	s.outlier.requests++
+	s.outlier.latency += res.latency
+	if res.failed() {
+		s.outlier.errors++
+		s.outlier.consecutive++
+	} else {
+		s.outlier.consecutive = 0
+	}
+	trip := o.ConsecutiveErrors > 0 && s.outlier.consecutive >= o.ConsecutiveErrors
+	if trip {
+		s.outlier.consecutive = 0
+	}
+	s.outlier.mu.Unlock()
+	if trip {
+		b.eject(s, time.Now(), fmt.Sprintf("%d failed requests in a row", o.ConsecutiveErrors))
+	}
+}
+
+// eject stops sending requests to the server for the ejection time unless
+// the limit of the ejected share of the pool is reached.
+func (b *snapshot) eject(s *server, now time.Time, reason string) bool {
+	o := b.config.OutlierDetection
+	ejectMu.Lock()
+	defer ejectMu.Unlock()
+	if s.ejected(now) {
+		return false
+	}
+	ejected := 0
+	for _, other := range b.servers {
+		if other.ejected(now) {
+			ejected++
+		}
+	}
+	if float64(ejected+1) > o.MaxEjectedFraction*float64(len(b.servers)) {
+		log.Printf("Backend %s is an outlier (%s), but %d of %d backends are ejected already", s.Address, reason, ejected, len(b.servers))
+		return false
+	}
+	s.outlier.ejections++
+	d := time.Duration(o.MaxEjection)
+	if shift := s.outlier.ejections - 1; shift < maxEjectionShift {
+		d = min(time.Duration(o.BaseEjection)<<shift, d)
+	}
+	s.ejectedUntil.Store(now.Add(d).UnixNano())
+	log.Printf("Backend %s is ejected for %s: %s", s.Address, d, reason)
+	return true
+}
+
+// analyze judges the backends by the requests of the window that ended and
+// starts a new one. The backends with the error rate or the mean latency
+// over the limits are ejected, the ones that have recovered have their
+// ejection time decreased.
+func (b *snapshot) analyze(now time.Time) {
+	o := b.config.OutlierDetection
+	if o.Disabled {
+		return
+	}
+	type window struct {
+		s        *server
+		requests int
+		errors   int
+		mean     time.Duration
+	}
+	var judged []window
+	for _, s := range b.servers {
+		s.outlier.mu.Lock()
+		w := window{s: s, requests: s.outlier.requests, errors: s.outlier.errors}
+		if w.requests > 0 {
+			w.mean = s.outlier.latency / time.Duration(w.requests)
+		}
+		s.outlier.requests, s.outlier.errors, s.outlier.latency = 0, 0, 0
+		s.outlier.mu.Unlock()
+
+		ejectMu.Lock()
+		if !s.ejected(now) && w.errors == 0 && s.outlier.ejections > 0 {
+			s.outlier.ejections--
+		}
+		ejectMu.Unlock()
+		if w.requests >= o.MinRequests && w.requests > 0 {
+			judged = append(judged, w)
+		}
+	}
+
+	if o.ErrorRate > 0 {
+		for _, w := range judged {
+			if float64(w.errors)/float64(w.requests) >= o.ErrorRate {
+				b.eject(w.s, now, fmt.Sprintf("%d of %d requests failed", w.errors, w.requests))
+			}
+		}
+	}
+	if o.LatencyFactor > 0 && len(judged) >= 2 {
+		means := make([]time.Duration, len(judged))
+		for i, w := range judged {
+			means[i] = w.mean
+		}
+		sort.Slice(means, func(i, j int) bool { return means[i] < means[j] })
+		median := means[len(means)/2]
+		if len(means)%2 == 0 {
+			median = (means[len(means)/2-1] + median) / 2
+		}
+		for _, w := range judged {
+			if float64(w.mean) > o.LatencyFactor*float64(median) {
+				b.eject(w.s, now, fmt.Sprintf("mean latency %s is over %g times the median %s", w.mean, o.LatencyFactor, median))
+			}
+		}
+	}
+}
+
+// detectOutliers analyzes the requests of the pool every interval.
+func detectOutliers() {
+	for {
+		time.Sleep(time.Duration(current.Load().config.OutlierDetection.Interval))
+		current.Load().analyze(time.Now())
+	}
+}
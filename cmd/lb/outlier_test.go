@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// applyOutliers serves four healthy backends with the outlier detection.
+func applyOutliers(c *C, o pool.OutlierDetection) *snapshot {
+	config := &pool.Config{Policy: pool.PolicyRoundRobin, OutlierDetection: o}
+	var err error
+	config.Backends, err = pool.ParseBackends("server1:8080,server2:8080,server3:8080,server4:8080")
+	c.Assert(err, IsNil)
+	c.Assert(config.Validate(), IsNil)
+	apply(config)
+	b := current.Load()
+	for _, s := range b.servers {
+		s.healthy.Store(true)
+	}
+	return b
+}
+
+var (
+	failure = result{err: errors.New("connection refused"), status: http.StatusServiceUnavailable}
+	success = result{status: http.StatusOK, latency: time.Millisecond}
+)
+
+func ejectedUntil(s *server) time.Duration {
+	return time.Until(time.Unix(0, s.ejectedUntil.Load())).Round(time.Second)
+}
+
+func (s *MySuite) TestOutliers_ConsecutiveErrors(c *C) {
+	b := applyOutliers(c, pool.OutlierDetection{ConsecutiveErrors: 3})
+	bad := b.servers[0]
+	b.record(bad, failure)
+	b.record(bad, failure)
+	b.record(bad, success)
+	b.record(bad, failure)
+	b.record(bad, result{status: http.StatusInternalServerError})
+	c.Assert(bad.available(), Equals, true)
+	b.record(bad, failure)
+	c.Assert(bad.available(), Equals, false)
+	c.Assert(ejectedUntil(bad), Equals, pool.DefaultBaseEjection)
+	for i := 0; i < 100; i++ {
+		c.Assert(pickServer(requestFrom(fmt.Sprintf("10.0.0.%d:5000", i))).Address, Not(Equals), bad.Address)
+	}
+
+	// Кожне наступне вилучення вдвічі довше.
+	bad.ejectedUntil.Store(0)
+	for i := 0; i < 3; i++ {
+		b.record(bad, failure)
+	}
+	c.Assert(ejectedUntil(bad), Equals, 2*pool.DefaultBaseEjection)
+	bad.ejectedUntil.Store(0)
+	bad.outlier.ejections = 10
+	for i := 0; i < 3; i++ {
+		b.record(bad, failure)
+	}
+	c.Assert(ejectedUntil(bad), Equals, pool.DefaultMaxEjection)
+
+	// Відновлений сервер поступово повертає коротке вилучення.
+	bad.ejectedUntil.Store(0)
+	b.analyze(time.Now())
+	c.Assert(bad.outlier.ejections, Equals, 11)
+	b.record(bad, success)
+	b.analyze(time.Now())
+	c.Assert(bad.outlier.ejections, Equals, 10)
+	b.analyze(time.Now())
+	c.Assert(bad.outlier.ejections, Equals, 9)
+}
+
+func (s *MySuite) TestOutliers_MaxEjectedFraction(c *C) {
+	b := applyOutliers(c, pool.OutlierDetection{ConsecutiveErrors: 1, MaxEjectedFraction: 0.5})
+	for _, s := range b.servers[:3] {
+		b.record(s, failure)
+	}
+	c.Assert(b.servers[0].available(), Equals, false)
+	c.Assert(b.servers[1].available(), Equals, false)
+	c.Assert(b.servers[2].available(), Equals, true)
+
+	// Вилучення переживають перезавантаження конфігурації.
+	b = applyOutliers(c, pool.OutlierDetection{ConsecutiveErrors: 1, MaxEjectedFraction: 0.5})
+	c.Assert(b.servers[0].available(), Equals, false)
+}
+
+func (s *MySuite) TestOutliers_ErrorRate(c *C) {
+	b := applyOutliers(c, pool.OutlierDetection{ConsecutiveErrors: -1, ErrorRate: 0.3, MinRequests: 10})
+	for i := 0; i < 10; i++ {
+		for j, s := range b.servers {
+			if (j == 0 && i%2 == 0) || (j == 1 && i < 2) {
+				b.record(s, failure)
+			} else {
+				b.record(s, success)
+			}
+		}
+	}
+	b.record(b.servers[2], failure)
+	b.analyze(time.Now())
+	c.Assert(b.servers[0].available(), Equals, false)
+	c.Assert(b.servers[1].available(), Equals, true)
+	c.Assert(b.servers[2].available(), Equals, true)
+
+	// Без достатньої кількості запитів сервер не оцінюється.
+	for i := 0; i < 5; i++ {
+		b.record(b.servers[3], failure)
+	}
+	b.analyze(time.Now())
+	c.Assert(b.servers[3].available(), Equals, true)
+}
+
+func (s *MySuite) TestOutliers_Latency(c *C) {
+	b := applyOutliers(c, pool.OutlierDetection{LatencyFactor: 3, MinRequests: 5})
+	for i := 0; i < 5; i++ {
+		for j, s := range b.servers {
+			latency := time.Duration(10+j) * time.Millisecond
+			if j == 3 {
+				latency = 100 * time.Millisecond
+			}
+			b.record(s, result{status: http.StatusOK, latency: latency})
+		}
+	}
+	b.analyze(time.Now())
+	for i, s := range b.servers {
+		c.Assert(s.available(), Equals, i != 3, Commentf("%s", s.Address))
+	}
+}
+
+func (s *MySuite) TestOutliers_Disabled(c *C) {
+	b := applyOutliers(c, pool.OutlierDetection{Disabled: true, ConsecutiveErrors: 1})
+	b.record(b.servers[0], failure)
+	b.analyze(time.Now())
+	c.Assert(b.servers[0].available(), Equals, true)
+}
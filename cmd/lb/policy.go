@@ -0,0 +1,233 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/roman-mazur/architecture-practice-4-template/hashring"
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// Balancer chooses the backend of a request among its servers. A Balancer
+// is built for every change of the pool, so it sees a fixed list of servers,
+// but their health and load change while it is used.
+type Balancer interface {
+	// Choose returns an available server for the request that is not
+	// excluded, or nil if there is none. The servers already tried for the
+	// request are excluded when it is retried.
+	Choose(r *http.Request, exclude map[*server]bool) *server
+}
+
+// newBalancer creates the Balancer of the policy over the servers.
+func newBalancer(policy pool.Policy, servers []*server, vnodes int) Balancer {
+	switch policy.Name {
+	case pool.PolicyRoundRobin:
+		return &roundRobin{servers: servers}
+	case pool.PolicyWeightedRoundRobin:
+		return &weightedRoundRobin{servers: servers, current: make([]int, len(servers))}
+	case pool.PolicyLeastOutstanding:
+		return &leastOutstanding{servers: servers}
+	case pool.PolicyP2C:
+		return &powerOfTwo{servers: servers}
+	default:
+		return &hashBalancer{policy: policy, servers: servers, ring: newRing(servers, vnodes)}
+	}
+}
+
+type roundRobin struct {
+	servers []*server
+	next    atomic.Uint64
+}
+
+// Choose takes turns among the available servers only, so the turn of an
+// unavailable one is not given to its neighbour every time.
+func (b *roundRobin) Choose(_ *http.Request, exclude map[*server]bool) *server {
+	available := availableServers(b.servers, exclude)
+	if len(available) == 0 {
+		return nil
+	}
+	return available[(b.next.Add(1)-1)%uint64(len(available))]
+}
+
+// weightedRoundRobin is the smooth weighted round-robin of nginx: a server
+// of weight 3 among ones of weight 1 gets every other request rather than
+// three in a row.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	servers []*server
+	current []int
+}
+
+func (b *weightedRoundRobin) Choose(_ *http.Request, exclude map[*server]bool) *server {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total, best := 0, -1
+	for i, s := range b.servers {
+		if !s.available() || exclude[s] {
+			continue
+		}
+		b.current[i] += s.Weight
+		total += s.Weight
+		if best < 0 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	b.current[best] -= total
+	return b.servers[best]
+}
+
+type leastOutstanding struct {
+	servers []*server
+	// next rotates the first server compared, so the ties are spread.
+	next atomic.Uint64
+}
+
+func (b *leastOutstanding) Choose(_ *http.Request, exclude map[*server]bool) *server {
+	available := availableServers(b.servers, exclude)
+	n := uint64(len(available))
+	start := b.next.Add(1) - 1
+	var best *server
+	for i := uint64(0); i < n; i++ {
+		if s := available[(start+i)%n]; best == nil || lessLoaded(s, best) {
+			best = s
+		}
+	}
+	return best
+}
+
+// powerOfTwo picks two random servers and takes the less loaded one, which
+// is nearly as good as finding the least loaded server without scanning
+// all of them and without sending every request to the same one.
+type powerOfTwo struct {
+	servers []*server
+}
+
+func (b *powerOfTwo) Choose(_ *http.Request, exclude map[*server]bool) *server {
+	available := availableServers(b.servers, exclude)
+	switch len(available) {
+	case 0:
+		return nil
+	case 1:
+		return available[0]
+	}
+	i := rand.IntN(len(available))
+	j := rand.IntN(len(available) - 1)
+	if j >= i {
+		j++
+	}
+	if lessLoaded(available[j], available[i]) {
+		return available[j]
+	}
+	return available[i]
+}
+
+func availableServers(servers []*server, exclude map[*server]bool) []*server {
+	available := make([]*server, 0, len(servers))
+	for _, s := range servers {
+		if s.available() && !exclude[s] {
+			available = append(available, s)
+		}
+	}
+	return available
+}
+
+// lessLoaded compares the requests in flight relative to the weights.
+func lessLoaded(a, b *server) bool {
+	return a.inFlight.Load()*int64(b.Weight) < b.inFlight.Load()*int64(a.Weight)
+}
+
+// newRing places the servers on a consistent hash ring with vnodes points
+// per unit of weight. A client is served by the first available server
+// clockwise from the hash of its key, so a membership change only moves the
+// clients of the ranges next to the points of the server added or removed,
+// and the clients of a failed server are spread over all the others.
+func newRing(servers []*server, vnodes int) *hashring.Ring {
+	addresses := make([]string, len(servers))
+	weights := make([]int, len(servers))
+	for i, s := range servers {
+		addresses[i], weights[i] = s.Address, s.Weight
+	}
+	return hashring.New(addresses, weights, vnodes)
+}
+
+type hashBalancer struct {
+	policy  pool.Policy
+	servers []*server
+	ring    *hashring.Ring
+}
+
+func (b *hashBalancer) Choose(r *http.Request, exclude map[*server]bool) *server {
+	i := b.ring.Lookup(b.key(r), func(i int) bool {
+		return b.servers[i].available() && !exclude[b.servers[i]]
+	})
+	if i < 0 {
+		return nil
+	}
+	return b.servers[i]
+}
+
+// key returns the value hashed for the request. The requests without the
+// header or the cookie fall back to the client IP.
+func (b *hashBalancer) key(r *http.Request) string {
+	switch b.policy.Key {
+	case pool.HashPath:
+		return r.URL.Path
+	case pool.HashHeader:
+		if v := r.Header.Get(b.policy.Param); v != "" {
+			return v
+		}
+	case pool.HashCookie:
+		if c, err := r.Cookie(b.policy.Param); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return clientIP(r)
+}
+
+// clientIP returns the address of the client without the port, which
+// changes with every connection.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// route is a Balancer of the requests with a path prefix.
+type route struct {
+	prefix   string
+	balancer Balancer
+}
+
+// newRoutes creates the balancers of the routes of the configuration,
+// sorted so that the longest prefix is matched first, and the one of the
+// other requests.
+func newRoutes(config *pool.Config, servers []*server) ([]route, Balancer) {
+	policy, _ := pool.ParsePolicy(config.Policy)
+	fallback := newBalancer(policy, servers, config.VirtualNodes)
+	var routes []route
+	for _, r := range config.Routes {
+		policy, _ := pool.ParsePolicy(r.Policy)
+		routes = append(routes, route{r.Prefix, newBalancer(policy, servers, config.VirtualNodes)})
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+	return routes, fallback
+}
+
+// balancerOf returns the Balancer of the longest route matching the path.
+func balancerOf(routes []route, fallback Balancer, path string) Balancer {
+	for _, r := range routes {
+		if strings.HasPrefix(path, r.prefix) {
+			return r.balancer
+		}
+	}
+	return fallback
+}
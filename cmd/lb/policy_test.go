@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// healthyServers creates available servers of the weights.
+func healthyServers(weights ...int) []*server {
+	var servers []*server
+	for i, weight := range weights {
+		s := newServer(pool.Backend{Address: fmt.Sprintf("server%d:8080", i+1), Weight: weight}, nil, false)
+		s.healthy.Store(true)
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+func testBalancer(c *C, policy string, servers []*server) Balancer {
+	p, err := pool.ParsePolicy(policy)
+	c.Assert(err, IsNil)
+	return newBalancer(p, servers, pool.DefaultVirtualNodes)
+}
+
+// counts chooses a server n times and counts the choices by address.
+func counts(b Balancer, n int) map[string]int {
+	result := make(map[string]int)
+	for i := 0; i < n; i++ {
+		if s := b.Choose(requestFrom(fmt.Sprintf("10.0.%d.%d:5000", i/256, i%256)), nil); s != nil {
+			result[s.Address]++
+		}
+	}
+	return result
+}
+
+func (s *MySuite) TestRoundRobin(c *C) {
+	servers := healthyServers(1, 1, 1)
+	b := testBalancer(c, pool.PolicyRoundRobin, servers)
+	var order []string
+	for i := 0; i < 6; i++ {
+		order = append(order, b.Choose(requestFrom("10.0.0.1:5000"), nil).Address)
+	}
+	c.Assert(order, DeepEquals, []string{"server1:8080", "server2:8080", "server3:8080", "server1:8080", "server2:8080", "server3:8080"})
+
+	servers[1].healthy.Store(false)
+	c.Assert(counts(b, 100), DeepEquals, map[string]int{"server1:8080": 50, "server3:8080": 50})
+}
+
+func (s *MySuite) TestWeightedRoundRobin(c *C) {
+	servers := healthyServers(3, 1)
+	b := testBalancer(c, pool.PolicyWeightedRoundRobin, servers)
+	c.Assert(counts(b, 400), DeepEquals, map[string]int{"server1:8080": 300, "server2:8080": 100})
+
+	servers[0].draining.Store(true)
+	c.Assert(counts(b, 10), DeepEquals, map[string]int{"server2:8080": 10})
+}
+
+func (s *MySuite) TestLeastOutstanding(c *C) {
+	servers := healthyServers(1, 1, 2)
+	servers[0].inFlight.Store(3)
+	servers[1].inFlight.Store(1)
+	servers[2].inFlight.Store(4)
+	b := testBalancer(c, pool.PolicyLeastOutstanding, servers)
+	c.Assert(b.Choose(requestFrom("10.0.0.1:5000"), nil).Address, Equals, "server2:8080")
+
+	// Сервер з удвічі більшою вагою витримує удвічі більше запитів.
+	servers[1].inFlight.Store(3)
+	c.Assert(b.Choose(requestFrom("10.0.0.1:5000"), nil).Address, Equals, "server3:8080")
+
+	servers[0].inFlight.Store(0)
+	servers[0].healthy.Store(false)
+	c.Assert(b.Choose(requestFrom("10.0.0.1:5000"), nil).Address, Equals, "server3:8080")
+
+	for _, s := range servers {
+		s.inFlight.Store(0)
+	}
+	c.Assert(counts(b, 300), DeepEquals, map[string]int{"server2:8080": 150, "server3:8080": 150})
+}
+
+func (s *MySuite) TestPowerOfTwo(c *C) {
+	servers := healthyServers(1, 1, 1)
+	servers[0].inFlight.Store(10)
+	servers[1].inFlight.Store(1)
+	b := testBalancer(c, pool.PolicyP2C, servers)
+	chosen := counts(b, 300)
+	c.Assert(chosen["server1:8080"], Equals, 0)
+	c.Assert(chosen["server3:8080"] > chosen["server2:8080"], Equals, true, Commentf("%v", chosen))
+
+	servers[2].healthy.Store(false)
+	servers[1].healthy.Store(false)
+	c.Assert(counts(b, 10), DeepEquals, map[string]int{"server1:8080": 10})
+	servers[0].healthy.Store(false)
+	c.Assert(b.Choose(requestFrom("10.0.0.1:5000"), nil), IsNil)
+}
+
+func (s *MySuite) TestHashKeys(c *C) {
+	servers := healthyServers(1, 1, 1, 1)
+
+	b := testBalancer(c, pool.PolicyHash, servers)
+	first := b.Choose(requestFrom("10.0.0.1:5000"), nil)
+	for port := 5001; port < 5100; port++ {
+		c.Assert(b.Choose(requestFrom(fmt.Sprintf("10.0.0.1:%d", port)), nil).Address, Equals, first.Address)
+	}
+	c.Assert(counts(b, 1000), HasLen, 4)
+
+	b = testBalancer(c, "hash:header:x-user-id", servers)
+	request := func(address, user string) *http.Request {
+		r := requestFrom(address)
+		r.Header.Set("X-User-ID", user)
+		return r
+	}
+	first = b.Choose(request("10.0.0.1:5000", "alice"), nil)
+	for i := 0; i < 100; i++ {
+		c.Assert(b.Choose(request(fmt.Sprintf("10.0.1.%d:5000", i), "alice"), nil).Address, Equals, first.Address)
+	}
+	c.Assert(b.Choose(requestFrom("10.0.0.1:5000"), nil).Address, Equals, testBalancer(c, pool.PolicyHash, servers).Choose(requestFrom("10.0.0.1:5000"), nil).Address)
+
+	b = testBalancer(c, "hash:cookie:session", servers)
+	r := requestFrom("10.0.0.1:5000")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	first = b.Choose(r, nil)
+	for i := 0; i < 100; i++ {
+		r := requestFrom(fmt.Sprintf("10.0.1.%d:5000", i))
+		r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+		c.Assert(b.Choose(r, nil).Address, Equals, first.Address)
+	}
+
+	b = testBalancer(c, "hash:path", servers)
+	first = b.Choose(httptest.NewRequest(http.MethodGet, "/static/app.js", nil), nil)
+	for i := 0; i < 100; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/static/app.js?v="+fmt.Sprint(i), nil)
+		r.RemoteAddr = fmt.Sprintf("10.0.1.%d:5000", i)
+		c.Assert(b.Choose(r, nil).Address, Equals, first.Address)
+	}
+}
+
+func (s *MySuite) TestRoutes(c *C) {
+	config := &pool.Config{
+		Policy: pool.PolicyRoundRobin,
+		Routes: []pool.Route{
+			{Prefix: "/api/", Policy: pool.PolicyLeastOutstanding},
+			{Prefix: "/api/v1/static/", Policy: "hash:path"},
+		},
+		Backends: []pool.Backend{{Address: "server1:8080"}, {Address: "server2:8080"}},
+	}
+	c.Assert(config.Validate(), IsNil)
+	routes, fallback := newRoutes(config, healthyServers(1, 1))
+	_, ok := balancerOf(routes, fallback, "/api/v1/static/app.js").(*hashBalancer)
+	c.Assert(ok, Equals, true)
+	_, ok = balancerOf(routes, fallback, "/api/v1/some-data").(*leastOutstanding)
+	c.Assert(ok, Equals, true)
+	_, ok = balancerOf(routes, fallback, "/health").(*roundRobin)
+	c.Assert(ok, Equals, true)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
+)
+
+// reload loads the configuration again and applies it. An invalid one is
+// logged and rejected, the current configuration is kept.
+func reload(flags *pool.Flags) error {
+	config, err := flags.Load()
+	if err != nil {
+		log.Printf("Keeping the current configuration: %s", err)
+		return err
+	}
+	apply(config)
+	return nil
+}
+
+// watchConfig reloads the configuration on SIGHUP and whenever the config
+// file is modified.
+func watchConfig(flags *pool.Flags, interval time.Duration) {
+	hup := signal.ReloadSignals()
+	file := flags.ConfigFile()
+	var tick <-chan time.Time
+	if file != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	version := fileVersion(file)
+	for {
+		select {
+		case <-hup:
+			log.Println("Reloading the configuration on SIGHUP")
+			version = fileVersion(file)
+			_ = reload(flags)
+		case <-tick:
+			if v := fileVersion(file); v != version {
+				version = v
+				log.Printf("Reloading the modified configuration %s", file)
+				_ = reload(flags)
+			}
+		}
+	}
+}
+
+// fileVersion changes when the file is modified or replaced.
+func fileVersion(path string) [2]int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return [2]int64{}
+	}
+	return [2]int64{info.ModTime().UnixNano(), info.Size()}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+func (s *MySuite) TestReload(c *C) {
+	path := filepath.Join(c.MkDir(), "lb.yaml")
+	write := func(content string) {
+		c.Assert(os.WriteFile(path, []byte(content), 0o600), IsNil)
+	}
+	fs := flag.NewFlagSet("lb", flag.ContinueOnError)
+	flags := pool.RegisterFlags(fs, defaultBackends)
+	c.Assert(fs.Parse([]string{"--config", path}), IsNil)
+
+	write("backends:\n  - address: server1:8080\n  - address: server2:8080\n")
+	c.Assert(reload(flags), IsNil)
+	before := current.Load()
+	c.Assert(before.servers, HasLen, 2)
+	before.servers[0].healthy.Store(true)
+	inFlight := pickServer(requestFrom("192.168.110.10:54321"))
+	c.Assert(inFlight, NotNil)
+
+	write("healthCheck:\n  path: /ready\n  interval: 1m\nbackends:\n  - address: server1:8080\n  - address: server3:8080\n    weight: 2\n")
+	c.Assert(reload(flags), IsNil)
+	after := current.Load()
+	c.Assert(after.config.HealthCheck.Path, Equals, "/ready")
+	c.Assert(after.servers, HasLen, 2)
+	c.Assert(after.servers[0].healthy.Load(), Equals, true)
+	c.Assert(after.servers[1].Address, Equals, "server3:8080")
+	c.Assert(after.servers[1].healthy.Load(), Equals, false)
+	// Запит, що вже пересилається, лишається зі своїм сервером.
+	c.Assert(inFlight.Address, Equals, "server1:8080")
+	for _, s := range before.servers {
+		select {
+		case <-s.stop:
+		default:
+			c.Errorf("Health check of %s is not stopped", s.Address)
+		}
+	}
+
+	write("backends:\n  - address: server1\n")
+	c.Assert(reload(flags), NotNil)
+	c.Assert(current.Load(), Equals, after)
+	write("policy: random\nbackends:\n  - address: server1:8080\n")
+	c.Assert(reload(flags), NotNil)
+	c.Assert(current.Load(), Equals, after)
+}
+
+func (s *MySuite) TestReload_KeepsServers(c *C) {
+	applyBackends(c, "server1:8080,server2:8080")
+	before := current.Load()
+	before.servers[0].healthy.Store(true)
+
+	// Незмінений сервер лишається зі своєю перевіркою здоров'я.
+	applyBackends(c, "server1:8080,server2:8080?weight=2,server3:8080")
+	after := current.Load()
+	c.Assert(after.servers, HasLen, 3)
+	c.Assert(after.servers[0] == before.servers[0], Equals, true)
+	c.Assert(after.servers[1] == before.servers[1], Equals, false)
+	c.Assert(after.servers[1].backendState == before.servers[1].backendState, Equals, true)
+	select {
+	case <-before.servers[0].stop:
+		c.Error("Health check of an unchanged backend is restarted")
+	default:
+	}
+	select {
+	case <-before.servers[1].stop:
+	default:
+		c.Error("Health check of a changed backend is not restarted")
+	}
+
+	config := &pool.Config{HealthCheck: pool.HealthCheck{Path: "/ready"}, Backends: after.config.Backends}
+	c.Assert(config.Validate(), IsNil)
+	apply(config)
+	c.Assert(current.Load().servers[0] == before.servers[0], Equals, false)
+	c.Assert(current.Load().servers[0].healthy.Load(), Equals, true)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// budgetWindow is the period over which the retry budget is counted.
+const budgetWindow = 10 * time.Second
+
+// retryBudget limits the retries to a share of the requests, so that a
+// failing pool does not get several times its usual load.
+type retryBudget struct {
+	mu       sync.Mutex
+	start    time.Time
+	requests int
+	retries  int
+}
+
+var budget = new(retryBudget)
+
+// roll starts a new window if the current one is over. mu must be held.
+func (b *retryBudget) roll(now time.Time) {
+	if now.Sub(b.start) >= budgetWindow {
+		b.start, b.requests, b.retries = now, 0, 0
+	}
+}
+
+// request counts a request received by the frontend.
+func (b *retryBudget) request(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(now)
+	b.requests++
+}
+
+// allow takes a retry from the budget if there is one left.
+func (b *retryBudget) allow(now time.Time, r pool.Retry) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(now)
+	limit := float64(r.MinRetriesPerSecond)*budgetWindow.Seconds() + r.Budget*float64(b.requests)
+	if float64(b.retries) >= limit {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// idempotent reports whether the request can be sent twice without
+// changing the result. A client marks other requests as safe to repeat
+// with the Idempotency-Key header.
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+// dialFailed reports whether the backend could not be connected, so the
+// request never reached it.
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryable reports whether the request failed with the result can be sent
+// to another backend.
+func retryable(r *http.Request, res result, config pool.Retry) bool {
+	if r.Context().Err() != nil {
+		return false
+	}
+	if res.err != nil {
+		return dialFailed(res.err) || idempotent(r)
+	}
+	return idempotent(r) && slices.Contains(config.Statuses, res.status)
+}
+
+// replayBody reads the request body into memory so that it can be sent
+// again. A body larger than max is left to be streamed once and replayable
+// is false.
+func replayBody(r *http.Request, max int64) (body []byte, replayable bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	body, err = io.ReadAll(io.LimitReader(r.Body, max+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > max {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false, nil
+	}
+	return body, true, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+// backendStub counts the requests of a test backend responding with status
+// and the body of the request.
+type backendStub struct {
+	*httptest.Server
+	hits atomic.Int64
+}
+
+func newBackendStub(status int) *backendStub {
+	b := new(backendStub)
+	b.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b.hits.Add(1)
+		rw.WriteHeader(status)
+		io.Copy(rw, r.Body)
+	}))
+	return b
+}
+
+func (b *backendStub) address() string {
+	return strings.TrimPrefix(b.URL, "http://")
+}
+
+// applyRetries serves healthy backends at the addresses with the retries.
+// The outlier detection and the circuit breakers are disabled to keep the
+// failing backends in the pool.
+func applyRetries(c *C, retry pool.Retry, addresses ...string) {
+	config := &pool.Config{
+		Policy:           pool.PolicyRoundRobin,
+		Retry:            retry,
+		OutlierDetection: pool.OutlierDetection{Disabled: true},
+		CircuitBreaker:   pool.CircuitBreaker{Disabled: true},
+	}
+	var err error
+	config.Backends, err = pool.ParseBackends(strings.Join(addresses, ","))
+	c.Assert(err, IsNil)
+	c.Assert(config.Validate(), IsNil)
+	apply(config)
+	for _, s := range current.Load().servers {
+		s.healthy.Store(true)
+	}
+}
+
+// serveRequest serves a request with the body and returns the response
+// status and the number of backends tried.
+func serveRequest(c *C, method, body string, header http.Header) (int, int) {
+	r := httptest.NewRequest(method, "/api/v1/some-data", strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	rw := httptest.NewRecorder()
+	serve(rw, r)
+	attempts, err := strconv.Atoi(rw.Header().Get("lb-attempts"))
+	c.Assert(err, IsNil)
+	if rw.Code == http.StatusOK {
+		c.Assert(rw.Body.String(), Equals, body)
+	}
+	return rw.Code, attempts
+}
+
+func (s *MySuite) TestRetry(c *C) {
+	*traceEnabled = true
+	defer func() { *traceEnabled = false }()
+
+	bad, good := newBackendStub(http.StatusServiceUnavailable), newBackendStub(http.StatusOK)
+	defer bad.Close()
+	defer good.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	applyRetries(c, pool.Retry{}, bad.address(), good.address(), strings.TrimPrefix(closed.URL, "http://"))
+
+	// Невдалі спроби повторюються на інших серверах.
+	total := 0
+	for i := 0; i < 6; i++ {
+		status, attempts := serveRequest(c, http.MethodPut, "value", nil)
+		c.Assert(status, Equals, http.StatusOK)
+		total += attempts
+	}
+	c.Assert(good.hits.Load(), Equals, int64(6))
+	c.Assert(total > 6, Equals, true)
+	c.Assert(bad.hits.Load() <= int64(total-6), Equals, true)
+
+	// POST повторюється лише якщо сервер недоступний або є Idempotency-Key.
+	applyRetries(c, pool.Retry{}, bad.address(), good.address())
+	failed := 0
+	for i := 0; i < 6; i++ {
+		status, attempts := serveRequest(c, http.MethodPost, "value", nil)
+		c.Assert(attempts, Equals, 1)
+		if status != http.StatusOK {
+			failed++
+		}
+	}
+	c.Assert(failed, Equals, 3)
+	for i := 0; i < 6; i++ {
+		status, _ := serveRequest(c, http.MethodPost, "value", http.Header{"Idempotency-Key": {strconv.Itoa(i)}})
+		c.Assert(status, Equals, http.StatusOK)
+	}
+	applyRetries(c, pool.Retry{}, strings.TrimPrefix(closed.URL, "http://"), good.address())
+	for i := 0; i < 6; i++ {
+		status, _ := serveRequest(c, http.MethodPost, "value", nil)
+		c.Assert(status, Equals, http.StatusOK)
+	}
+}
+
+func (s *MySuite) TestRetry_Attempts(c *C) {
+	*traceEnabled = true
+	defer func() { *traceEnabled = false }()
+
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		b := newBackendStub(http.StatusBadGateway)
+		defer b.Close()
+		addresses = append(addresses, b.address())
+	}
+	applyRetries(c, pool.Retry{Attempts: 2}, addresses...)
+	status, attempts := serveRequest(c, http.MethodGet, "", nil)
+	c.Assert(status, Equals, http.StatusBadGateway)
+	c.Assert(attempts, Equals, 2)
+
+	applyRetries(c, pool.Retry{Statuses: []int{}}, addresses...)
+	status, attempts = serveRequest(c, http.MethodGet, "", nil)
+	c.Assert(status, Equals, http.StatusBadGateway)
+	c.Assert(attempts, Equals, 1)
+
+	// Тіло, більше за MaxBody, не можна відправити повторно.
+	applyRetries(c, pool.Retry{MaxBody: 4}, addresses...)
+	_, attempts = serveRequest(c, http.MethodPut, "too long", nil)
+	c.Assert(attempts, Equals, 1)
+	_, attempts = serveRequest(c, http.MethodPut, "ok", nil)
+	c.Assert(attempts, Equals, 3)
+}
+
+func (s *MySuite) TestRetry_Budget(c *C) {
+	config := pool.Retry{Budget: 0.5, MinRetriesPerSecond: 1}
+	b := new(retryBudget)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		c.Assert(b.allow(now, config), Equals, true)
+	}
+	c.Assert(b.allow(now, config), Equals, false)
+	for i := 0; i < 4; i++ {
+		b.request(now)
+	}
+	c.Assert(b.allow(now, config), Equals, true)
+	c.Assert(b.allow(now, config), Equals, true)
+	c.Assert(b.allow(now, config), Equals, false)
+	c.Assert(b.allow(now.Add(budgetWindow), config), Equals, true)
+}
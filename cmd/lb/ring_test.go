@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+func testServers(weights map[string]int) []*server {
+	var servers []*server
+	for address, weight := range weights {
+		servers = append(servers, newServer(pool.Backend{Address: address, Weight: weight}, nil, false))
+	}
+	return servers
+}
+
+// owners maps the keys to the addresses of the servers accepted by ok.
+func owners(servers []*server, keys int, ok func(*server) bool) map[string]string {
+	r := newRing(servers, pool.DefaultVirtualNodes)
+	result := make(map[string]string)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		owner := r.Lookup(key, func(i int) bool { return ok(servers[i]) })
+		result[key] = servers[owner].Address
+	}
+	return result
+}
+
+func all(*server) bool { return true }
+
+func (s *MySuite) TestRing_Remapping(c *C) {
+	const keys = 20000
+	before := owners(testServers(map[string]int{"server1:8080": 1, "server2:8080": 1, "server3:8080": 1, "server4:8080": 1}), keys, all)
+	after := owners(testServers(map[string]int{"server1:8080": 1, "server2:8080": 1, "server3:8080": 1, "server4:8080": 1, "server5:8080": 1}), keys, all)
+	moved := 0
+	for key, owner := range before {
+		if after[key] != owner {
+			moved++
+			c.Assert(after[key], Equals, "server5:8080", Commentf("%s moved between the old servers", key))
+		}
+	}
+	share := float64(moved) / keys
+	c.Assert(share > 0.1 && share < 0.3, Equals, true, Commentf("adding a fifth server moved %.2f of the clients", share))
+
+	removed := owners(testServers(map[string]int{"server1:8080": 1, "server2:8080": 1, "server3:8080": 1}), keys, all)
+	for key, owner := range before {
+		if owner != "server4:8080" {
+			c.Assert(removed[key], Equals, owner, Commentf("%s moved from a remaining server", key))
+		}
+	}
+}
+
+func (s *MySuite) TestRing_Failover(c *C) {
+	const keys = 20000
+	servers := testServers(map[string]int{"server1:8080": 1, "server2:8080": 1, "server3:8080": 1, "server4:8080": 1})
+	before := owners(servers, keys, all)
+	after := owners(servers, keys, func(s *server) bool { return s.Address != "server4:8080" })
+	taken := make(map[string]int)
+	failed := 0
+	for key, owner := range before {
+		if owner == "server4:8080" {
+			failed++
+			taken[after[key]]++
+		} else {
+			c.Assert(after[key], Equals, owner)
+		}
+	}
+	// Клієнти сервера, що впав, розходяться між усіма іншими, а не дістаються сусіду.
+	c.Assert(taken, HasLen, 3)
+	for address, n := range taken {
+		share := float64(n) / float64(failed)
+		c.Assert(share > 0.2 && share < 0.47, Equals, true, Commentf("%s took %.2f of the clients of the failed server", address, share))
+	}
+
+	c.Assert(newRing(servers, 10).Lookup("key", func(int) bool { return false }), Equals, -1)
+}
+
+func (s *MySuite) TestRing_Weights(c *C) {
+	const keys = 30000
+	counts := make(map[string]int)
+	for _, owner := range owners(testServers(map[string]int{"small:8080": 1, "big:8080": 3}), keys, all) {
+		counts[owner]++
+	}
+	share := float64(counts["big:8080"]) / keys
+	c.Assert(share > 0.65 && share < 0.85, Equals, true, Commentf("a server of weight 3 out of 4 got %.2f of the clients", share))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
+)
+
+var (
+	errBackendExists = errors.New("backend already exists")
+	errNoBackend     = errors.New("backend does not exist")
+)
+
+// backendState is kept while the address of a backend stays in the pool,
+// across the reloads of the configuration.
+type backendState struct {
+	healthy  atomic.Bool
+	draining atomic.Bool
+	// inFlight is the number of requests being forwarded to the backend.
+	inFlight atomic.Int64
+
+	probeMu sync.Mutex
+	// checked reports that the backend was probed at least once.
+	checked bool
+	// streak counts the probes in a row that disagree with healthy.
+	streak int
+
+	outlier outlierStats
+	// ejectedUntil is the time in nanoseconds until which the outlier
+	// detection keeps the backend out of the balancing.
+	ejectedUntil atomic.Int64
+	breaker      circuitBreaker
+}
+
+// server is a backend of the pool together with its state.
+type server struct {
+	pool.Backend
+	*backendState
+	// dynamic reports that the backend was added with the admin API
+	// rather than configured.
+	dynamic bool
+	// id is the value of the affinity cookie of the backend.
+	id   string
+	stop chan struct{}
+}
+
+func newServer(b pool.Backend, state *backendState, dynamic bool) *server {
+	if state == nil {
+		state = new(backendState)
+	}
+	return &server{Backend: b, backendState: state, dynamic: dynamic, id: backendID(b.Address), stop: make(chan struct{})}
+}
+
+// available reports whether new clients can be sent to the server.
+func (s *server) available() bool {
+	now := time.Now()
+	return s.healthy.Load() && !s.draining.Load() && !s.ejected(now) && s.breaker.admits(now)
+}
+
+// snapshot is the configuration served by the frontend. It is never
+// modified: a reload or a change made with the admin API builds a new one
+// and swaps it, so the requests being forwarded keep the servers they were
+// given.
+type snapshot struct {
+	config   *pool.Config
+	servers  []*server
+	routes   []route
+	fallback Balancer
+}
+
+var (
+	current atomic.Pointer[snapshot]
+	// registryMu serializes the changes of the pool.
+	registryMu sync.Mutex
+)
+
+// apply starts serving the configuration. The backends that stay in the
+// pool keep their state and the ones added with the admin API are kept
+// unless the configuration has the same address. An unchanged backend keeps
+// its server too, so its health check goes on as before, unless the health
+// check settings have changed.
+func apply(config *pool.Config) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	previous := make(map[string]*server)
+	var dynamic []*server
+	old := current.Load()
+	sameCheck := old != nil && old.config.HealthCheck.Equal(config.HealthCheck)
+	if old != nil {
+		for _, s := range old.servers {
+			previous[s.Address] = s
+		}
+	}
+	var servers []*server
+	for _, b := range config.Backends {
+		p, ok := previous[b.Address]
+		switch {
+		case ok && sameCheck && !p.dynamic && sameBackend(p.Backend, b):
+			servers = append(servers, p)
+		case ok:
+			servers = append(servers, newServer(b, p.backendState, false))
+		default:
+			log.Printf("Backend %s added, weight %d, tags %v", b.URL(""), b.Weight, b.Tags)
+			servers = append(servers, newServer(b, nil, false))
+		}
+		delete(previous, b.Address)
+	}
+	if old != nil {
+		for _, s := range old.servers {
+			if _, ok := previous[s.Address]; ok && s.dynamic {
+				if !sameCheck {
+					s = newServer(s.Backend, s.backendState, true)
+				}
+				dynamic = append(dynamic, s)
+				delete(previous, s.Address)
+			}
+		}
+	}
+	for address := range previous {
+		log.Printf("Backend %s removed", address)
+	}
+
+	publish(&snapshot{config: config, servers: append(servers, dynamic...)})
+	log.Printf("Serving %d backends with the %s policy, checking %s every %s",
+		len(servers)+len(dynamic), config.Policy, config.HealthCheck.Path, time.Duration(config.HealthCheck.Interval))
+	for _, r := range config.Routes {
+		log.Printf("Requests of %s use the %s policy", r.Prefix, r.Policy)
+	}
+}
+
+// addBackend adds a backend to the pool until it is removed or the
+// configuration file gets the same address.
+func addBackend(b pool.Backend) (*server, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	old := current.Load()
+	if err := b.Validate(old.config.Scheme); err != nil {
+		return nil, err
+	}
+	if old.find(b.Address) != nil {
+		return nil, fmt.Errorf("%w: %s", errBackendExists, b.Address)
+	}
+	s := newServer(b, nil, true)
+	publish(&snapshot{config: old.config, servers: append(old.servers[:len(old.servers):len(old.servers)], s)})
+	log.Printf("Backend %s added with the admin API, weight %d, tags %v", b.URL(""), b.Weight, b.Tags)
+	return s, nil
+}
+
+// removeBackend removes a backend from the pool. The requests already sent
+// to it are completed. A configured backend comes back on the next reload
+// unless it is removed from the configuration file too.
+func removeBackend(address string) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	old := current.Load()
+	var servers []*server
+	for _, s := range old.servers {
+		if s.Address != address {
+			servers = append(servers, s)
+		}
+	}
+	if len(servers) == len(old.servers) {
+		return fmt.Errorf("%w: %s", errNoBackend, address)
+	}
+	publish(&snapshot{config: old.config, servers: servers})
+	log.Printf("Backend %s removed with the admin API", address)
+	return nil
+}
+
+// setDraining puts the backend into the drain mode, where it gets no new
+// clients but completes the requests already sent to it, or takes it out.
+func setDraining(address string, draining bool) (*server, error) {
+	s := current.Load().find(address)
+	if s == nil {
+		return nil, fmt.Errorf("%w: %s", errNoBackend, address)
+	}
+	if s.draining.Swap(draining) != draining {
+		log.Printf("Backend %s draining: %t", address, draining)
+	}
+	return s, nil
+}
+
+// publish swaps the snapshot and starts or stops the health checks of the
+// servers that appeared or disappeared. registryMu must be held.
+func publish(next *snapshot) {
+	next.routes, next.fallback = newRoutes(next.config, next.servers)
+	old := current.Swap(next)
+	kept := make(map[*server]bool)
+	for _, s := range next.servers {
+		kept[s] = true
+	}
+	started := make(map[*server]bool)
+	if old != nil {
+		for _, s := range old.servers {
+			if kept[s] {
+				started[s] = true
+			} else {
+				close(s.stop)
+			}
+		}
+	}
+	for _, s := range next.servers {
+		if next.config.CircuitBreaker.Disabled {
+			s.breaker.reset()
+		}
+		if !started[s] {
+			go s.checkHealth(next.config.HealthCheck, probe)
+		}
+	}
+}
+
+// sameBackend reports whether the backends have the same settings.
+func sameBackend(a, b pool.Backend) bool {
+	return a.Address == b.Address && a.Weight == b.Weight && a.Scheme == b.Scheme && slices.Equal(a.Tags, b.Tags)
+}
+
+func (b *snapshot) find(address string) *server {
+	for _, s := range b.servers {
+		if s.Address == address {
+			return s
+		}
+	}
+	return nil
+}
+
+// choose returns the backend serving the request or nil if no backend can
+// take it. A client pinned to an available backend is sent to it, others
+// are balanced by the policy of the route and pinned to the result. The
+// excluded backends are not chosen even for the pinned clients.
+func (b *snapshot) choose(r *http.Request, exclude map[*server]bool) *server {
+	s, key := b.pinned(r)
+	if s != nil && !exclude[s] {
+		return s
+	}
+	s = balancerOf(b.routes, b.fallback, r.URL.Path).Choose(r, exclude)
+	if s != nil && key != "" {
+		pins.set(key, s.Address, time.Now(), time.Duration(b.config.Affinity.TTL))
+	}
+	return s
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/sharding"
+)
+
+var (
+	from    = flag.String("from", "", "comma separated db nodes before the membership change, e.g. db1:8100,db2:8100")
+	to      = flag.String("to", "", "comma separated db nodes after the membership change")
+	timeout = flag.Duration("timeout", time.Hour, "give up after this time")
+)
+
+const usage = `Usage: rebalance -from nodes -to nodes
+
+Copies the keys whose owner changes when the sharded db cluster goes from
+the -from list of nodes to the -to list. Start the servers with
+--db-nodes set to the -to list and --db-previous-nodes to the -from list
+first, run the tool, then restart them without --db-previous-nodes.
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	previous, nodes := sharding.ParseNodes(*from), sharding.ParseNodes(*to)
+	if len(previous) == 0 || len(nodes) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	stats, err := sharding.NewClient(nodes, previous).Rebalance(ctx)
+	_ = json.NewEncoder(os.Stdout).Encode(stats)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rebalance: %s\n", err)
+		os.Exit(1)
+	}
+}
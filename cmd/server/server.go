@@ -2,16 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
-	"io"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 	"sync"
 	"time"
 
-	"github.com/roman-mazur/design-practice-2-template/httptools"
-	"github.com/roman-mazur/design-practice-2-template/signal"
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+	"github.com/roman-mazur/architecture-practice-4-template/httptools"
+	"github.com/roman-mazur/architecture-practice-4-template/sharding"
+	"github.com/roman-mazur/architecture-practice-4-template/signal"
 )
 
 var port = flag.Int("port", 8080, "server port")
@@ -19,6 +20,8 @@ var delay = flag.Int("delay", 0, "response delay in millseconds")
 var healthInit = flag.Bool("health", true, "initial server health")
 var debug = flag.Bool("debug", false, "whether we can change server's health status")
 var dbUrl = flag.String("db-url", "db:8100", "hostname of database service")
+var dbNodes = flag.String("db-nodes", "", "comma separated db nodes the keys are sharded across (defaults to --db-url)")
+var dbPreviousNodes = flag.String("db-previous-nodes", "", "db nodes before the membership change while the keys are rebalanced")
 
 const scheme = "http"
 const team = "codebryksy"
@@ -42,11 +45,13 @@ func (c *boolMutex) Get() bool {
 }
 
 var report Report
+var dbClient *dbclient.Client
 
 func main() {
 	flag.Parse()
 	h := new(http.ServeMux)
 	health := boolMutex{v: *healthInit}
+	dbClient = newDbClient()
 	writeTeam()
 
 	if *debug {
@@ -81,13 +86,25 @@ func main() {
 	signal.WaitForTerminationSignal()
 }
 
-func writeTeam() {
-	path := scheme + "://" + *dbUrl + "/db/" + team
-	formData := url.Values{}
-	formData.Set("value", time.Now().Format("2006-01-02"))
+func newDbClient() *dbclient.Client {
+	nodes := sharding.ParseNodes(*dbNodes)
+	if len(nodes) == 0 {
+		nodes = []string{*dbUrl}
+	}
+	var previous []string
+	if *dbPreviousNodes != "" {
+		previous = sharding.ParseNodes(*dbPreviousNodes)
+	}
+	client := dbclient.New(sharding.NewClient(nodes, previous), dbclient.DefaultOptions())
+	client.Scheme = scheme
+	return client
+}
 
-	resp, err := http.PostForm(path, formData)
-	if err != nil || resp.StatusCode != http.StatusOK {
+func writeTeam() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := dbClient.Put(ctx, team, time.Now().Format("2006-01-02"))
+	if err != nil {
 		panic("Can't initiate DB")
 	}
 }
@@ -97,34 +114,26 @@ func handleDefaultGet(rw http.ResponseWriter, r *http.Request) {
 
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(10)*time.Second)
 	defer cancel()
-	fwdRequest := r.Clone(ctx)
-	fwdRequest.RequestURI = ""
-	fwdRequest.URL.Host = *dbUrl
-	fwdRequest.Host = *dbUrl
-	fwdRequest.URL.Scheme = scheme
-	fwdRequest.URL.Path = "/db/" + key
-
-	resp, err := http.DefaultClient.Do(fwdRequest)
+
+	value, err := dbClient.Get(ctx, key)
 	if *delay > 0 && *delay < 300 {
 		time.Sleep(time.Duration(*delay) * time.Millisecond)
 	}
-	if err != nil {
+	var status *dbclient.StatusError
+	switch {
+	case errors.Is(err, dbclient.ErrNotFound):
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	case errors.As(err, &status):
+		http.Error(rw, status.Message, status.Code)
+		return
+	case err != nil:
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if resp.StatusCode == http.StatusBadRequest {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err == nil && string(body) == "record does not exist\n" {
-			rw.WriteHeader(http.StatusNotFound)
-			return
-		}
-	}
 
 	report.Process(r)
 
-	rw.WriteHeader(resp.StatusCode)
-	rw.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	rw.Header().Set("Content-Length", resp.Header.Get("Content-Length"))
-	io.Copy(rw, resp.Body)
-	resp.Body.Close()
-}
\ No newline at end of file
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(map[string]string{"key": key, "value": value})
+}
@@ -3,38 +3,36 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/pool"
 )
 
-var https = flag.Bool("https", false, "whether backends support HTTPs")
+// defaultBackends are the servers of docker-compose.yaml as published on
+// the host.
+const defaultBackends = "localhost:8080,localhost:8081,localhost:8082"
 
-var serversPool = []string{
-	"localhost:8080",
-	"localhost:8081",
-	"localhost:8082",
-}
+var poolFlags = pool.RegisterFlags(flag.CommandLine, defaultBackends)
 
 type report map[string][]string
 
-func scheme() string {
-	if *https {
-		return "https"
-	}
-	return "http"
-}
-
-func main()  {
+func main() {
 	flag.Parse()
 
+	config, err := poolFlags.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	serversPool := config.Backends
+
 	client := new(http.Client)
 	client.Timeout = 10 * time.Second
 
 	res := make([]report, len(serversPool))
 	for i, s := range serversPool {
-		resp, err := client.Get(fmt.Sprintf("%s://%s/report", scheme(), s))
+		resp, err := client.Get(s.URL("/report"))
 		if err == nil {
 			var data report
 			if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
@@ -49,12 +47,13 @@ func main()  {
 				}
 				res[i] = data
 			}
+			resp.Body.Close()
 		} else {
-			log.Printf("error %s %s", s, err)
+			log.Printf("error %s %s", s.Address, err)
 		}
 
 		log.Println("=========================")
-		log.Println("SERVER", i, serversPool[i])
+		log.Println("SERVER", i, serversPool[i].Address)
 		log.Println("=========================")
 		data, _ := json.MarshalIndent(res[i], "", "  ")
 		log.Println(string(data))
@@ -1,9 +1,7 @@
 package datastore
 
 import (
-	"bufio"
 	"context"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -13,15 +11,34 @@ import (
 
 var ErrNotFound = fmt.Errorf("record does not exist")
 
-type hashIndex map[string]int64
+// ErrWrongType is returned when a value is read as another type than it was stored with.
+var ErrWrongType = fmt.Errorf("wrong type of value")
+
+// position вказує на актуальний запис ключа в сегменті.
+type position struct {
+	offset int64
+	size   int64
+	// запис є позначкою видалення ключа
+	deleted bool
+	version uint64
+}
+
+type hashIndex map[string]position
 
 type block struct {
 	index   hashIndex
 	segment *os.File
 
 	outPath   string
+	number    int
 	outOffset int64
-	mu        sync.RWMutex
+	// кількість байтів в актуальних записах; решта сегмента - сміття
+	live int64
+	// кількість позначок видалення в індексі
+	deleted int
+	mu      sync.RWMutex
+	// чи скидати кожен запис на диск одразу
+	syncWrites bool
 
 	writeCh chan writeArgument
 
@@ -53,6 +70,9 @@ func newBlock(dir string, outFileName string) (*block, error) {
 
 const bufSize = 8192
 
+// Суфікс тимчасового файлу, в який записується результат злиття сегментів.
+const tempSuffix = "-temp"
+
 func (b *block) recover() error {
 	input, err := os.Open(b.outPath)
 	if err != nil {
@@ -60,47 +80,22 @@ func (b *block) recover() error {
 	}
 	defer input.Close()
 
-	var buf [bufSize]byte
-	in := bufio.NewReaderSize(input, bufSize)
-	for err == nil {
-		var (
-			header, data []byte
-			n            int
-		)
-		header, err = in.Peek(bufSize)
-		if err == io.EOF {
-			if len(header) == 0 {
-				return err
-			}
-		} else if err != nil {
+	reader, err := newSegmentReader(input)
+	if err != nil {
+		return err
+	}
+	for {
+		e, data, err := reader.next()
+		if err != nil {
 			return err
 		}
-		size := binary.LittleEndian.Uint32(header)
-
-		if size < bufSize {
-			data = buf[:size]
-		} else {
-			data = make([]byte, size)
-		}
-		n, err = in.Read(data)
-
-		if err == nil {
-			if n != int(size) {
-				return fmt.Errorf("corrupted file")
-			}
-
-			var e entry
-			e.Decode(data)
-			b.index[e.key] = b.outOffset
-			b.outOffset += int64(n)
-		}
+		b.setLive(e.key, position{b.outOffset, int64(len(data)), e.vType == TOMBSTONE_TYPE, e.version})
+		b.outOffset = reader.offset
 	}
-	return err
 }
 
 func (b *block) close() error {
 	b.cancel()
-	close(b.writeCh)
 	return b.segment.Close()
 }
 
@@ -118,27 +113,21 @@ func (b *block) get(key string) (string, string, error) {
 	}
 	defer file.Close()
 
-	_, err = file.Seek(position, 0)
+	data := make([]byte, position.size)
+	_, err = file.ReadAt(data, position.offset)
 	if err != nil {
 		return "", "", err
 	}
 
-	reader := bufio.NewReader(file)
-	pair, err := readValue(reader)
+	var e entry
+	err = decodeRecord(data, &e)
 	if err != nil {
-		return "", "", err
+		return "", "", fmt.Errorf("%s at offset %d: %w", b.outPath, position.offset, err)
 	}
-
-	return pair.value, pair.vType, nil
+	return e.value, ToType(e.vType), nil
 }
 
-func (b *block) put(key, vType, value string) error {
-	e := entry{
-		key:   key,
-		vType: ToByte(vType),
-		value: value,
-	}
-
+func (b *block) put(e entry) error {
 	resultCh := make(chan writeResult)
 	b.writeCh <- writeArgument{resultCh, e.Encode()}
 	result := <-resultCh
@@ -146,7 +135,7 @@ func (b *block) put(key, vType, value string) error {
 
 	if result.err == nil {
 		b.mu.Lock()
-		b.index[key] = b.outOffset
+		b.setLive(e.key, position{b.outOffset, int64(result.n), e.vType == TOMBSTONE_TYPE, e.version})
 		b.outOffset += int64(result.n)
 		b.mu.Unlock()
 	}
@@ -154,6 +143,92 @@ func (b *block) put(key, vType, value string) error {
 	return result.err
 }
 
+// putBatch appends the entries with a single write, bypassing the write
+// goroutine. The caller must ensure there are no concurrent puts.
+func (b *block) putBatch(entries []entry) error {
+	var data []byte
+	offsets := make([]int64, len(entries))
+	for i := range entries {
+		offsets[i] = int64(len(data))
+		data = append(data, entries[i].Encode()...)
+	}
+	n, err := b.segment.Write(data)
+	if err == nil && b.syncWrites {
+		err = b.segment.Sync()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		for i, e := range entries {
+			size := int64(len(data)) - offsets[i]
+			if i+1 < len(entries) {
+				size = offsets[i+1] - offsets[i]
+			}
+			b.setLive(e.key, position{b.outOffset + offsets[i], size, e.vType == TOMBSTONE_TYPE, e.version})
+		}
+	}
+	b.outOffset += int64(n)
+	return err
+}
+
+// setLive makes pos the actual record of the key, turning the previous one
+// into garbage. b.mu must be held by the caller.
+func (b *block) setLive(key string, pos position) {
+	if old, ok := b.index[key]; ok {
+		b.forget(old)
+	}
+	b.index[key] = pos
+	b.live += pos.size
+	if pos.deleted {
+		b.deleted++
+	}
+}
+
+// forget updates the counters when a record stops being live.
+func (b *block) forget(pos position) {
+	b.live -= pos.size
+	if pos.deleted {
+		b.deleted--
+	}
+}
+
+// retire forgets the record of a key that was overwritten in a newer block.
+func (b *block) retire(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if old, ok := b.index[key]; ok {
+		b.forget(old)
+		delete(b.index, key)
+	}
+}
+
+// contains reports whether the block has the live record of the key, which
+// may be a deletion marker.
+func (b *block) contains(key string) bool {
+	_, ok := b.lookup(key)
+	return ok
+}
+
+func (b *block) lookup(key string) (position, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	pos, ok := b.index[key]
+	return pos, ok
+}
+
+func (b *block) stats() SegmentStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return SegmentStats{
+		Name:       filepath.Base(b.outPath),
+		Keys:       len(b.index) - b.deleted,
+		TotalBytes: b.outOffset,
+		LiveBytes:  b.live,
+		DeadBytes:  b.outOffset - b.live,
+	}
+}
+
 type writeArgument struct {
 	resultCh chan writeResult
 	data     []byte
@@ -171,6 +246,9 @@ func (b *block) write(ctx context.Context) {
 			return
 		case arg := <-b.writeCh:
 			n, err := b.segment.Write(arg.data)
+			if err == nil && b.syncWrites {
+				err = b.segment.Sync()
+			}
 			arg.resultCh <- writeResult{n, err}
 		}
 	}
@@ -189,7 +267,11 @@ func mergeAll(blocks []*block) (*block, error) {
 	if len(blocks) == 0 {
 		return nil, fmt.Errorf("empty array of blocks")
 	}
-	newBlock, err := newBlock(blocks[0].outPath+"-temp", "")
+	tempPath := blocks[0].outPath + tempSuffix
+	if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	newBlock, err := newBlock(tempPath, "")
 	if err != nil {
 		return nil, err
 	}
@@ -199,27 +281,54 @@ func mergeAll(blocks []*block) (*block, error) {
 			return nil, err
 		}
 	}
+	// Позначка видалення з найбільшою версією лишається, інакше після
+	// перезапуску лічильник версій піде назад і видасть уже видані версії.
+	var (
+		topKey string
+		top    position
+	)
+	for _, b := range blocks {
+		for key, pos := range b.index {
+			if pos.version > top.version {
+				topKey, top = key, pos
+			}
+		}
+	}
+	if top.deleted {
+		err = newBlock.put(entry{key: topKey, vType: TOMBSTONE_TYPE, version: top.version})
+		if err != nil {
+			return nil, err
+		}
+	}
 	return newBlock, nil
 }
 
 func mergePair(destBlock, srcBlock *block) error {
-	for key := range srcBlock.index {
+	for key, pos := range srcBlock.index {
+		// Злитий сегмент найстаріший, тож позначки видалення в ньому вже нічого не приховують.
+		if pos.deleted {
+			continue
+		}
 		_, ok := destBlock.index[key]
 		if !ok {
 			val, vType, err := srcBlock.get(key)
 			if err != nil {
 				return err
 			}
-			destBlock.put(key, vType, val)
+			// Версія переходить у злитий сегмент разом зі значенням.
+			err = destBlock.put(entry{key: key, vType: ToByte(vType), value: val, version: pos.version})
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
 func (b *block) delete() error {
-	err := os.Remove(b.segment.Name())
+	err := os.Remove(b.outPath)
 	if err != nil {
 		return err
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// valueCache keeps the most recently used values in memory, so hot keys
+// don't require a disk read.
+type valueCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type cacheItem struct {
+	key   string
+	vType string
+	value string
+}
+
+func newValueCache(size int) *valueCache {
+	return &valueCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *valueCache) get(key string) (string, string, bool) {
+	if c == nil {
+		return "", "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return "", "", false
+	}
+	c.ll.MoveToFront(el)
+	item := el.Value.(*cacheItem)
+	return item.value, item.vType, true
+}
+
+func (c *valueCache) put(key, vType, value string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		item := el.Value.(*cacheItem)
+		item.vType, item.value = vType, value
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheItem{key, vType, value})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).key)
+	}
+}
+
+func (c *valueCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
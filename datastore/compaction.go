@@ -0,0 +1,153 @@
+package datastore
+
+import "time"
+
+// MergePolicy describes when sealed segments are merged together. A zero
+// value of any field disables the corresponding trigger; Compact can always
+// be called manually. The merges triggered by writes run in the background.
+type MergePolicy struct {
+	// MaxSegments triggers a merge when there are more segments than this.
+	MaxSegments int
+	// GarbageRatio triggers a merge when the share of dead bytes in the
+	// sealed segments reaches this value (from 0 to 1).
+	GarbageRatio float64
+	// Interval triggers a merge of the sealed segments periodically, if
+	// there is anything to reclaim.
+	Interval time.Duration
+}
+
+// SegmentStats describes the space usage of a single segment.
+type SegmentStats struct {
+	Name       string `json:"name"`
+	Keys       int    `json:"keys"`
+	TotalBytes int64  `json:"totalBytes"`
+	LiveBytes  int64  `json:"liveBytes"`
+	DeadBytes  int64  `json:"deadBytes"`
+}
+
+// Stats describes the space amplification of the whole database.
+type Stats struct {
+	Segments       []SegmentStats `json:"segments"`
+	Keys           int            `json:"keys"`
+	TotalBytes     int64          `json:"totalBytes"`
+	LiveBytes      int64          `json:"liveBytes"`
+	DeadBytes      int64          `json:"deadBytes"`
+	GarbageRatio   float64        `json:"garbageRatio"`
+	Compactions    int            `json:"compactions"`
+	LastCompaction time.Time      `json:"lastCompaction"`
+}
+
+// Stats returns the current number of live and dead bytes per segment.
+func (db *Db) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	res := Stats{
+		Segments:       make([]SegmentStats, len(db.blocks)),
+		Compactions:    db.compactions,
+		LastCompaction: db.lastCompaction,
+	}
+	for i, b := range db.blocks {
+		s := b.stats()
+		res.Segments[i] = s
+		res.Keys += s.Keys
+		res.TotalBytes += s.TotalBytes
+		res.LiveBytes += s.LiveBytes
+		res.DeadBytes += s.DeadBytes
+	}
+	if res.TotalBytes > 0 {
+		res.GarbageRatio = float64(res.DeadBytes) / float64(res.TotalBytes)
+	}
+	return res
+}
+
+// SetMergePolicy replaces the policy that triggers automatic merges.
+func (db *Db) SetMergePolicy(policy MergePolicy) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.policy = policy
+	db.stopTicker()
+	if policy.Interval > 0 {
+		stop := make(chan struct{})
+		db.tickerStop = stop
+		go db.mergePeriodically(policy.Interval, stop)
+	}
+}
+
+func (db *Db) stopTicker() {
+	if db.tickerStop != nil {
+		close(db.tickerStop)
+		db.tickerStop = nil
+	}
+}
+
+func (db *Db) mergePeriodically(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Помилка злиття не фатальна: старі сегменти лишаються на місці.
+			_ = db.mergeIf(func() bool {
+				return len(db.blocks) > 2 || (len(db.blocks) == 2 && db.blocks[0].stats().DeadBytes > 0)
+			})
+		}
+	}
+}
+
+// shouldMerge checks the segment count and garbage ratio triggers.
+func (db *Db) shouldMerge() bool {
+	if len(db.blocks) < 2 {
+		return false
+	}
+	if db.policy.MaxSegments > 0 && len(db.blocks) > db.policy.MaxSegments {
+		return true
+	}
+	if db.policy.GarbageRatio > 0 {
+		var total, dead int64
+		for _, b := range db.blocks[:len(db.blocks)-1] {
+			s := b.stats()
+			total += s.TotalBytes
+			dead += s.DeadBytes
+		}
+		if total > 0 && float64(dead)/float64(total) >= db.policy.GarbageRatio {
+			return true
+		}
+	}
+	return false
+}
+
+// owner returns the sealed block holding the live record of the key, so it
+// can be retired once the key is rewritten into the active block.
+func (db *Db) owner(key string) *block {
+	if db.blocks[len(db.blocks)-1].contains(key) {
+		return nil
+	}
+	for j := len(db.blocks) - 2; j >= 0; j-- {
+		if db.blocks[j].contains(key) {
+			return db.blocks[j]
+		}
+	}
+	return nil
+}
+
+// retireOverwritten drops records of older blocks that were overwritten in
+// newer ones, so every key is live in exactly one block.
+func (db *Db) retireOverwritten() {
+	seen := make(map[string]struct{})
+	for j := len(db.blocks) - 1; j >= 0; j-- {
+		b := db.blocks[j]
+		b.mu.Lock()
+		for key, pos := range b.index {
+			if _, ok := seen[key]; ok {
+				b.forget(pos)
+				delete(b.index, key)
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		b.mu.Unlock()
+	}
+}
@@ -0,0 +1,178 @@
+package datastore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDb_Stats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"a", "b", "c"} {
+		if err := db.Put("key", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("other", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stats Stats) {
+		t.Helper()
+		recordSize := stats.TotalBytes - stats.LiveBytes
+		if stats.Keys != 2 {
+			t.Errorf("Expected 2 keys, got %d", stats.Keys)
+		}
+		if stats.DeadBytes != recordSize || stats.DeadBytes == 0 {
+			t.Errorf("Unexpected dead bytes: %+v", stats)
+		}
+		if stats.GarbageRatio <= 0 || stats.GarbageRatio >= 1 {
+			t.Errorf("Unexpected garbage ratio: %+v", stats)
+		}
+	}
+	before := db.Stats()
+	check(before)
+	db.Close()
+
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	after := db.Stats()
+	check(after)
+	if after.DeadBytes != before.DeadBytes || after.LiveBytes != before.LiveBytes {
+		t.Errorf("Stats differ after recovery: %+v vs %+v", before, after)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	compacted := db.Stats()
+	if compacted.DeadBytes != 0 || compacted.LiveBytes != before.LiveBytes || compacted.Compactions != 1 {
+		t.Errorf("Unexpected stats after compaction: %+v", compacted)
+	}
+}
+
+func TestDb_MergePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.SegmentSize = 100
+	db, err := NewDbWithOptions(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	t.Run("garbage ratio", func(t *testing.T) {
+		db.SetMergePolicy(MergePolicy{GarbageRatio: 0.5})
+		for i := 0; i < 20; i++ {
+			if err := db.Put("key", "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		waitForMerges(t, db)
+		stats := db.Stats()
+		if stats.Compactions == 0 {
+			t.Errorf("Expected the garbage ratio to trigger a merge: %+v", stats)
+		}
+		sealed := stats.Segments[:len(stats.Segments)-1]
+		for _, s := range sealed {
+			if s.TotalBytes > 0 && float64(s.DeadBytes)/float64(s.TotalBytes) >= 0.5 {
+				t.Errorf("Sealed segment has too much garbage: %+v", s)
+			}
+		}
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		db.SetMergePolicy(MergePolicy{})
+		for i := 0; i < 20; i++ {
+			if err := db.Put("key", "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		compactions := db.Stats().Compactions
+		db.SetMergePolicy(MergePolicy{Interval: 10 * time.Millisecond})
+		deadline := time.Now().Add(time.Second)
+		for db.Stats().Compactions == compactions && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if db.Stats().Compactions == compactions {
+			t.Error("Expected a periodic merge")
+		}
+		if value, err := db.Get("key"); err != nil || value != "value" {
+			t.Errorf("Bad value after merge: %s, %v", value, err)
+		}
+	})
+}
+
+// waitForMerges waits until the merges requested by the writes are done.
+func waitForMerges(t *testing.T, db *Db) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		db.mergeMu.Lock()
+		db.mu.RLock()
+		pending := db.shouldMerge() || len(db.mergeRequests) > 0
+		db.mu.RUnlock()
+		db.mergeMu.Unlock()
+		if !pending {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Merges are not finished")
+}
+
+func TestDb_MergeInBackground(t *testing.T) {
+	options := DefaultOptions()
+	options.SegmentSize = 100
+	db, err := NewDbWithOptions(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Поки злиття чекає на блокування, записи й читання не зупиняються.
+	db.mergeMu.Lock()
+	for i := 0; i < 50; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i%10), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(db.Stats().Segments); n <= 3 {
+		t.Errorf("Expected the merge to wait, got %d segments", n)
+	}
+	db.mergeMu.Unlock()
+
+	waitForMerges(t, db)
+	stats := db.Stats()
+	if stats.Compactions == 0 || len(stats.Segments) > 3 {
+		t.Errorf("Expected a merge in the background: %+v", stats)
+	}
+	for i := 40; i < 50; i++ {
+		if value, err := db.Get(fmt.Sprintf("key%d", i%10)); err != nil || value != strconv.Itoa(i) {
+			t.Errorf("Bad value of key%d after merge: %q, %v", i%10, value, err)
+		}
+	}
+	if stats.Keys != 10 {
+		t.Errorf("Expected 10 keys, got %+v", stats)
+	}
+}
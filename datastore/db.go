@@ -2,11 +2,16 @@ package datastore
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const outFileName = "segment-"
@@ -16,32 +21,88 @@ const outFileName = "segment-"
 const outFileSize int64 = 10000000
 
 type Db struct {
+	mu     sync.RWMutex
 	blocks []*block
 	//директорія, де зберігатимуться всі сегменти
 	dir           string
 	segmentName   string
 	segmentNumber int
 	segmentSize   int64
+	sync          SyncPolicy
+	cache         *valueCache
+	maxKeySize    int
+	maxValueSize  int
+	done          chan struct{}
+	// каталог, заблокований від інших процесів
+	lock *os.File
+
+	policy     MergePolicy
+	tickerStop chan struct{}
+	// злиття виконуються по одному, у фоні або через Compact
+	mergeMu        sync.Mutex
+	mergeRequests  chan struct{}
+	compactions    int
+	lastCompaction time.Time
+
+	// changed закривається після кожного запису, щоб розбудити тих, хто чекає на нові дані
+	changed chan struct{}
+	// розміри запечатаних сегментів, потрібні, щоб продовжити реплікацію після їх злиття
+	sealedEnds map[int]int64
+	// остання видана версія запису
+	lastVersion uint64
+	quota       Quota
+
+	// знімок ключів, упорядкованих для Scan, спільний для всіх обходів
+	scanMu   sync.Mutex
+	scanKeys []hashedKey
 }
 
+// NewDb opens the database stored in dir with the default options.
 func NewDb(dir string) (*Db, error) {
+	return NewDbWithOptions(dir, DefaultOptions())
+}
+
+// NewDbWithOptions opens the database stored in dir, creating the directory
+// if needed. The directory is locked until Close, so that another process,
+// such as dbctl, can't open it at the same time; ErrLocked is returned then.
+func NewDbWithOptions(dir string, options Options) (*Db, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
 	db := &Db{
-		dir:         dir,
-		segmentName: outFileName,
-		segmentSize: outFileSize,
+		dir:           dir,
+		segmentName:   options.SegmentPrefix,
+		segmentSize:   options.SegmentSize,
+		sync:          options.Sync,
+		maxKeySize:    options.MaxKeySize,
+		maxValueSize:  options.MaxValueSize,
+		quota:         options.Quota,
+		done:          make(chan struct{}),
+		mergeRequests: make(chan struct{}, 1),
+		changed:       make(chan struct{}),
+		sealedEnds:    make(map[int]int64),
+	}
+	if options.CacheSize > 0 {
+		db.cache = newValueCache(options.CacheSize)
 	}
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		os.MkdirAll(dir, os.ModePerm)
 	}
+	// Відкритий каталог тримає блокування, доки базу не закрито.
 	f, err := os.Open(dir)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	if err := lockDir(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	db.lock = f
 
 	filesNames, err := f.Readdirnames(0)
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 
@@ -49,124 +110,263 @@ func NewDb(dir string) (*Db, error) {
 	if len(filesNames) != 0 {
 		err := db.recover(filesNames)
 		if err != nil {
+			f.Close()
 			return nil, err
 		}
 	} else {
 		// директорія порожня -> створюємо перший блок
 		err = db.addNewBlockToDb()
 		if err != nil {
+			f.Close()
 			return nil, err
 		}
 	}
 
+	db.SetMergePolicy(options.MergePolicy)
+	go db.mergeInBackground()
+	if db.sync == SyncSecond {
+		go db.syncPeriodically(time.Second)
+	}
 	return db, nil
 }
 
 func (db *Db) addNewBlockToDb() error {
+	if len(db.blocks) > 0 {
+		sealed := db.blocks[len(db.blocks)-1]
+		db.sealedEnds[sealed.number] = sealed.stats().TotalBytes
+	}
 	db.segmentNumber++
 	b, err := newBlock(db.dir,
 		db.segmentName+strconv.Itoa((db.segmentNumber)))
 	if err != nil {
 		return err
 	}
+	b.number = db.segmentNumber
+	b.syncWrites = db.sync == SyncAlways
 	db.blocks = append(db.blocks, b)
 	return nil
 }
 
 func (db *Db) recover(filesNames []string) error {
-	//сортуємо за зростанням
-	sort.Strings(filesNames)
-	//регексп для перевірки назв фалів
-	r, _ := regexp.Compile(db.segmentName + "[0-9]+")
-	for _, fileName := range filesNames {
-		match := r.MatchString(fileName)
-
-		if match {
-			b, err := newBlock(db.dir, fileName)
-			if err != nil {
-				return err
-			}
-			db.blocks = append(db.blocks, b)
-			reg, _ := regexp.Compile("[0-9]+")
-			db.segmentNumber, err = strconv.Atoi(reg.FindString(fileName))
-			if err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("wrongly named file in the working directory: %v. Current file neme pattern: %v + int number", fileName, db.segmentName)
+	names, err := sortSegments(filesNames, db.segmentName)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range names {
+		b, err := newBlock(db.dir, fileName)
+		if err != nil {
+			return err
+		}
+		b.syncWrites = db.sync == SyncAlways
+		db.blocks = append(db.blocks, b)
+		db.segmentNumber, err = strconv.Atoi(strings.TrimPrefix(fileName, db.segmentName))
+		if err != nil {
+			return err
+		}
+		b.number = db.segmentNumber
+	}
+	//нульовий сегмент - результат злиття, тому писати в нього не можна
+	if len(db.blocks) == 0 || db.segmentNumber == 0 {
+		err := db.addNewBlockToDb()
+		if err != nil {
+			return err
 		}
 	}
+	db.retireOverwritten()
+	db.recoverVersions()
 	return nil
 }
 
+// sortSegments впорядковує назви сегментів за їхнім номером.
+// Залишки незавершеного злиття видаляються, бо старі сегменти ще на місці.
+func sortSegments(filesNames []string, segmentName string) ([]string, error) {
+	r := regexp.MustCompile("^" + regexp.QuoteMeta(segmentName) + "([0-9]+)$")
+	numbers := make(map[string]int)
+	var names []string
+	for _, fileName := range filesNames {
+		if strings.HasSuffix(fileName, tempSuffix) {
+			continue
+		}
+		match := r.FindStringSubmatch(fileName)
+		if match == nil {
+			return nil, fmt.Errorf("wrongly named file in the working directory: %v. Current file neme pattern: %v + int number", fileName, segmentName)
+		}
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		numbers[fileName] = n
+		names = append(names, fileName)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return numbers[names[i]] < numbers[names[j]]
+	})
+	return names, nil
+}
+
 func (db *Db) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.stopTicker()
+	select {
+	case <-db.done:
+	default:
+		close(db.done)
+	}
 	for _, block := range db.blocks {
 		block.close()
 	}
+	db.lock.Close()
 	return nil
 }
 
+// closed reports whether Close was called.
+func (db *Db) closed() bool {
+	select {
+	case <-db.done:
+		return true
+	default:
+		return false
+	}
+}
+
 func (db *Db) getType(key string) (string, string, error) {
+	if val, vType, ok := db.cache.get(key); ok {
+		return val, vType, nil
+	}
 	var val, vType string
 	var err error
 	for j := len(db.blocks) - 1; j >= 0; j = j - 1 {
 		val, vType, err = db.blocks[j].get(key)
-		if err != nil && err != ErrNotFound {
-			return "", "", err
+		if err == nil && vType == "tombstone" {
+			return "", "", ErrNotFound
+		}
+		if err == nil {
+			db.cache.put(key, vType, val)
 		}
-		if val != "" {
-			return val, vType, nil
+		if err != ErrNotFound {
+			return val, vType, err
 		}
 	}
 	return "", "", err
 }
 
+// syncPeriodically flushes the active segment to disk until the database is closed.
+func (db *Db) syncPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+			db.mu.RLock()
+			_ = db.blocks[len(db.blocks)-1].segment.Sync()
+			db.mu.RUnlock()
+		}
+	}
+}
+
+// keys returns all the keys stored in the database in sorted order.
+func (db *Db) keys() []string {
+	set := make(map[string]struct{})
+	for _, b := range db.blocks {
+		b.mu.RLock()
+		for key, pos := range b.index {
+			if !pos.deleted {
+				set[key] = struct{}{}
+			}
+		}
+		b.mu.RUnlock()
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contains reports whether any block has a record of the key, including a
+// deletion marker. db.mu must be held.
+func (db *Db) contains(key string) bool {
+	for _, b := range db.blocks {
+		if b.contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
 func (db *Db) putType(key, vType, value string) error {
+	_, err := db.putVersion(key, vType, value, 0)
+	return err
+}
+
+// putVersion appends a record of the key with the version picked by
+// nextVersion and returns the version. db.mu must be held.
+func (db *Db) putVersion(key, vType, value string, version uint64) (uint64, error) {
 	actBlock := db.blocks[len(db.blocks)-1]
 	curSize, err := actBlock.size()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if curSize <= db.segmentSize {
-		err := actBlock.put(key, vType, value)
+	if curSize > db.segmentSize {
+		//якщо нема вже куди писати, то створюємо новий блок
+		err = db.addNewBlockToDb()
 		if err != nil {
-			return err
+			return 0, err
 		}
-		return err
+		actBlock = db.blocks[len(db.blocks)-1]
 	}
 
-	//якщо нема вже куди писати, то створюємо новий блок
-	err = db.addNewBlockToDb()
-	if err != nil {
-		return err
+	older := db.owner(key)
+	e := entry{key: key, vType: ToByte(vType), value: value, version: db.nextVersion(key, version)}
+	if vType != "tombstone" {
+		if err := db.checkQuota([]entry{e}); err != nil {
+			return 0, err
+		}
 	}
-	err = db.blocks[len(db.blocks)-1].put(key, vType, value)
+	err = actBlock.put(e)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if older != nil {
+		older.retire(key)
+	}
+	if vType == "tombstone" {
+		db.cache.remove(key)
+	} else {
+		db.cache.put(key, vType, value)
 	}
+	db.notify()
 
-	//запускаємо мердж, якщо достатньо файлів
-	if len(db.blocks) > 2 {
-		err = db.merge()
-		if err != nil {
-			return err
-		}
+	//запускаємо мердж у фоні, якщо спрацювала політика злиття
+	if db.shouldMerge() {
+		db.requestMerge()
 	}
-	return nil
+	return e.version, nil
 }
 
 func (db *Db) Get(key string) (string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	val, vType, err := db.getType(key)
 	if err != nil {
 		return "", err
 	}
 	if vType != "string" {
-		return "", fmt.Errorf("wrong type of value")
+		return "", ErrWrongType
 	}
 	return val, nil
 }
 
 func (db *Db) Put(key, value string) error {
+	if err := db.checkSize(key, value); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	err := db.putType(key, "string", value)
 	if err != nil {
 		return err
@@ -175,12 +375,14 @@ func (db *Db) Put(key, value string) error {
 }
 
 func (db *Db) GetInt64(key string) (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	val, vType, err := db.getType(key)
 	if err != nil {
 		return 0, err
 	}
 	if vType != "int64" {
-		return 0, fmt.Errorf("wrong type of value")
+		return 0, ErrWrongType
 	}
 	n, err := strconv.ParseInt(val, 10, 64)
 	if err != nil {
@@ -190,6 +392,11 @@ func (db *Db) GetInt64(key string) (int64, error) {
 }
 
 func (db *Db) PutInt64(key string, value int64) error {
+	if err := db.checkSize(key, ""); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	err := db.putType(key, "int64", strconv.FormatInt(value, 10))
 	if err != nil {
 		return err
@@ -197,30 +404,206 @@ func (db *Db) PutInt64(key string, value int64) error {
 	return nil
 }
 
-func (db *Db) merge() error {
-	tempBlock, err := mergeAll(db.blocks[:len(db.blocks)-1])
+// Delete removes the key by appending a deletion marker, which hides the
+// older records until they are merged away. It returns ErrNotFound if
+// there is no such key.
+func (db *Db) Delete(key string) error {
+	if err := db.checkSize(key, ""); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.exists(key) {
+		return ErrNotFound
+	}
+	return db.putType(key, "tombstone", "")
+}
+
+// GetValue returns the value of the key as a string along with its type
+// name, whatever the type is.
+func (db *Db) GetValue(key string) (string, string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getType(key)
+}
+
+// Exists reports whether the key has a value.
+func (db *Db) Exists(key string) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.exists(key)
+}
+
+// ErrOverflow is returned by IncrBy when the result doesn't fit into int64.
+var ErrOverflow = fmt.Errorf("increment or decrement would overflow")
+
+// IncrBy atomically adds delta to the int64 value of the key and returns
+// the result. A missing key is treated as 0 and a string value holding a
+// decimal number is converted to int64; other strings are ErrWrongType.
+func (db *Db) IncrBy(key string, delta int64) (int64, error) {
+	if err := db.checkSize(key, ""); err != nil {
+		return 0, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var n int64
+	val, _, err := db.getType(key)
+	if err == nil {
+		if n, err = strconv.ParseInt(val, 10, 64); err != nil {
+			return 0, ErrWrongType
+		}
+	} else if err != ErrNotFound {
+		return 0, err
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+	return n, db.putType(key, "int64", strconv.FormatInt(n, 10))
+}
+
+// exists reports whether the key has a value. db.mu must be held.
+func (db *Db) exists(key string) bool {
+	pos, ok := db.latest(key)
+	return ok && !pos.deleted
+}
+
+// PutValue stores a value given as a string along with its type name, as
+// produced by Export or returned in a Record. A "tombstone" deletes the
+// key; deleting a missing key is not an error, so records can be replayed.
+func (db *Db) PutValue(key, vType, value string) error {
+	switch vType {
+	case "tombstone":
+		err := db.Delete(key)
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	case "string":
+		return db.Put(key, value)
+	case "int64":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		return db.PutInt64(key, n)
+	default:
+		return fmt.Errorf("unknown data type %q", vType)
+	}
+}
+
+// Compact seals the active segment and merges all the data into a single one.
+func (db *Db) Compact() error {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	db.mu.Lock()
+	err := db.addNewBlockToDb()
+	db.mu.Unlock()
 	if err != nil {
 		return err
 	}
+	return db.merge()
+}
 
-	//додаємо блок до масиву блоків
-	db.blocks = append(db.blocks[:1], db.blocks[:]...)
-	db.blocks[0] = tempBlock
+// requestMerge wakes up mergeInBackground, unless a merge is already
+// requested. db.mu must be held.
+func (db *Db) requestMerge() {
+	select {
+	case db.mergeRequests <- struct{}{}:
+	default:
+	}
+}
 
-	//видаляємо вже непотрібні блоки
-	for _, block := range db.blocks[1 : len(db.blocks)-1] {
-		err := block.delete()
+// mergeInBackground runs the merges requested by the writes that trigger
+// the merge policy, so that the write does not wait for the merge.
+func (db *Db) mergeInBackground() {
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-db.mergeRequests:
+			// Помилка злиття не фатальна: старі сегменти лишаються на місці.
+			_ = db.mergeIf(db.shouldMerge)
+		}
+	}
+}
+
+// mergeIf merges the sealed segments if should, called under the read
+// lock, reports that there is a reason to.
+func (db *Db) mergeIf(should func() bool) error {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	db.mu.RLock()
+	ok := should()
+	db.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return db.merge()
+}
+
+// merge rewrites the live records of the sealed segments into segment 0.
+// The records are copied under the read lock, so the reads go on, and the
+// segments are swapped under the write lock. The keys written in between
+// are dropped from the merged segment, since newer segments have them now.
+// db.mergeMu must be held and db.mu must not be.
+func (db *Db) merge() error {
+	db.mu.RLock()
+	sealed := slices.Clone(db.blocks[:len(db.blocks)-1])
+	if len(sealed) == 0 {
+		db.mu.RUnlock()
+		return nil
+	}
+	tempBlock, err := mergeAll(sealed)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tempBlock.number = 0
+	tempBlock.syncWrites = db.sync == SyncAlways
+	if db.sync != SyncNever {
+		err = tempBlock.segment.Sync()
 		if err != nil {
 			return err
 		}
 	}
 
-	//видалимо рештки з масиву
-	db.blocks = append(db.blocks[:1], db.blocks[len(db.blocks)-1])
-	err = os.Rename(tempBlock.segment.Name(), filepath.Join(db.dir, db.segmentName+"0"))
-	tempBlock.outPath = tempBlock.segment.Name()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed() || db.blocks[0] != sealed[0] {
+		// Базу закрили або скинули під час злиття.
+		tempBlock.close()
+		return os.Remove(tempBlock.outPath)
+	}
+	for key := range tempBlock.index {
+		if !slices.ContainsFunc(sealed, func(b *block) bool { return b.contains(key) }) {
+			tempBlock.retire(key)
+		}
+	}
+
+	//атомарно підміняємо нульовий сегмент, тож старі блоки лишаються валідними до цього моменту
+	target := filepath.Join(db.dir, db.segmentName+"0")
+	err = os.Rename(tempBlock.outPath, target)
 	if err != nil {
 		return err
 	}
+	tempBlock.outPath = target
+
+	//видаляємо вже непотрібні блоки
+	for _, block := range sealed {
+		block.close()
+		if block.outPath == target {
+			continue
+		}
+		err := block.delete()
+		if err != nil {
+			return err
+		}
+	}
+
+	db.blocks = append([]*block{tempBlock}, db.blocks[len(sealed):]...)
+	db.compactions++
+	db.lastCompaction = time.Now()
 	return nil
-}
\ No newline at end of file
+}
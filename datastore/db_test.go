@@ -1,7 +1,10 @@
 package datastore
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -72,7 +75,10 @@ func TestDb_Put(t *testing.T) {
 	})
 
 	t.Run("new db process", func(t *testing.T) {
-		db, err = NewDb(dir)
+		db.Close()
+		options := DefaultOptions()
+		options.SegmentSize = outFileSize
+		db, err = NewDbWithOptions(dir, options)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -97,7 +103,6 @@ func TestDb_Put(t *testing.T) {
 		{"keyB", "newB"},
 	}
 	t.Run("create new out file, when previous file approximately reached expected size", func(t *testing.T) {
-		db.segmentSize = outFileSize
 		for _, pair := range pairs2 {
 			err := db.Put(pair[0], pair[1])
 			if err != nil {
@@ -145,6 +150,7 @@ func TestDb_Put(t *testing.T) {
 				t.Errorf("Cannot put %s: %s", pairs[0], err)
 			}
 		}
+		waitForMerges(t, db)
 
 		f, err := os.Open(dir)
 		if err != nil {
@@ -171,7 +177,9 @@ func TestDb_PutInt64(t *testing.T) {
 
 	const outFileSize int64 = 300
 
-	db, err := NewDb(dir)
+	options := DefaultOptions()
+	options.SegmentSize = outFileSize
+	db, err := NewDbWithOptions(dir, options)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -205,4 +213,166 @@ func TestDb_PutInt64(t *testing.T) {
 		}
 	})
 
-}
\ No newline at end of file
+}
+
+func TestDb_Delete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutInt64("other", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("key"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if err := db.Delete("key"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a deleted key, got %v", err)
+	}
+	if keys := db.Stats().Keys; keys != 1 {
+		t.Errorf("Expected 1 key, got %d", keys)
+	}
+	db.Close()
+
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Get("key"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after reopening, got %v", err)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("key"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after compaction, got %v", err)
+	}
+	if value, err := db.GetInt64("other"); err != nil || value != 1 {
+		t.Errorf("Bad value after compaction: %d, %v", value, err)
+	}
+	if err := db.Put("key", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("key"); err != nil || value != "again" {
+		t.Errorf("Bad value of a recreated key: %s, %v", value, err)
+	}
+}
+
+func TestDb_IncrBy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n, err := db.IncrBy("counter", 5); err != nil || n != 5 {
+		t.Errorf("Expected 5 for a new counter, got %d, %v", n, err)
+	}
+	if n, err := db.IncrBy("counter", -7); err != nil || n != -2 {
+		t.Errorf("Expected -2, got %d, %v", n, err)
+	}
+	if n, err := db.GetInt64("counter"); err != nil || n != -2 {
+		t.Errorf("Counter is stored as %d, %v", n, err)
+	}
+	if err := db.Put("number", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.IncrBy("number", 1); err != nil || n != 11 {
+		t.Errorf("Expected a numeric string to be incremented to 11, got %d, %v", n, err)
+	}
+	if err := db.Put("text", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.IncrBy("text", 1); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if err := db.PutInt64("max", math.MaxInt64); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.IncrBy("max", 1); err != ErrOverflow {
+		t.Errorf("Expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestDb_Scan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]int)
+	var cursor uint64
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("Scan does not finish")
+		}
+		var keys []string
+		keys, cursor = db.Scan(cursor, 7)
+		for _, key := range keys {
+			seen[key]++
+		}
+		// Ключі, додані й видалені під час обходу, не заважають решті.
+		if pages == 3 {
+			_ = db.Delete("key1")
+			_ = db.Put("new-key", "value")
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if key := fmt.Sprintf("key%d", i); key != "key1" && seen[key] != 1 {
+			t.Errorf("Key %s was returned %d times", key, seen[key])
+		}
+	}
+}
+
+func TestDb_Lock(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDb(dir); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked opening an open database, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatalf("Database is still locked after Close: %v", err)
+	}
+	db.Close()
+}
@@ -3,7 +3,10 @@ package datastore
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"strconv"
 )
 
@@ -11,12 +14,16 @@ type entry struct {
 	key   string
 	vType byte
 	value string
+	// version is 0 in the records written before versions were introduced
+	version uint64
 }
 
 type typeOperator interface {
 	Encode(*entry) []byte
 	Decode([]byte, *entry)
 	Read(*bufio.Reader) (string, error)
+	// Len returns the number of bytes the value occupies after the type byte.
+	Len(input []byte, kl int) int
 }
 
 type stringOperator struct{}
@@ -48,6 +55,13 @@ func (s stringOperator) Decode(input []byte, e *entry) {
 	e.value = string(valBuf)
 }
 
+func (s stringOperator) Len(input []byte, kl int) int {
+	if len(input) < kl+TYPE_SIZE+12 {
+		return -1
+	}
+	return 4 + int(binary.LittleEndian.Uint32(input[kl+TYPE_SIZE+8:]))
+}
+
 func (s stringOperator) Read(in *bufio.Reader) (string, error) {
 	header, err := in.Peek(4)
 	if err != nil {
@@ -60,17 +74,29 @@ func (s stringOperator) Read(in *bufio.Reader) (string, error) {
 	}
 
 	data := make([]byte, valSize)
-	n, err := in.Read(data)
+	n, err := io.ReadFull(in, data)
+	if err == io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("can't read value bytes (read %d, expected %d)", n, valSize)
+	}
 	if err != nil {
 		return "", err
 	}
-	if n != valSize {
-		return "", fmt.Errorf("can't read value bytes (read %d, expected %d)", n, valSize)
-	}
 
 	return string(data), nil
 }
 
+// tombstoneOperator encodes deletion markers as empty strings, so they are
+// read by the same code as the other records.
+type tombstoneOperator struct {
+	stringOperator
+}
+
+func (t tombstoneOperator) Encode(e *entry) []byte {
+	res := t.stringOperator.Encode(&entry{key: e.key})
+	res[len(e.key)+8] = TOMBSTONE_TYPE
+	return res
+}
+
 type int64Operator struct{}
 
 func (s int64Operator) Encode(e *entry) []byte {
@@ -90,6 +116,10 @@ func (s int64Operator) Decode(input []byte, e *entry) {
 	e.value = fmt.Sprintf("%d", int64(value))
 }
 
+func (s int64Operator) Len(input []byte, kl int) int {
+	return 8
+}
+
 func (s int64Operator) Read(in *bufio.Reader) (string, error) {
 	data, err := in.Peek(8)
 	if err != nil {
@@ -100,8 +130,9 @@ func (s int64Operator) Read(in *bufio.Reader) (string, error) {
 }
 
 var typeToByte map[string]byte = map[string]byte{
-	"string": STRING_TYPE,
-	"int64":  INT64_TYPE,
+	"string":    STRING_TYPE,
+	"int64":     INT64_TYPE,
+	"tombstone": TOMBSTONE_TYPE,
 }
 
 func ToByte(vType string) byte {
@@ -118,19 +149,46 @@ func ToType(value byte) string {
 }
 
 var operators map[byte]typeOperator = map[byte]typeOperator{
-	STRING_TYPE: stringOperator{},
-	INT64_TYPE:  int64Operator{},
+	STRING_TYPE:    stringOperator{},
+	INT64_TYPE:     int64Operator{},
+	TOMBSTONE_TYPE: tombstoneOperator{},
 }
 
 const (
-	TYPE_SIZE        = 1
-	STRING_TYPE byte = 0
-	INT64_TYPE  byte = 1
+	TYPE_SIZE           = 1
+	STRING_TYPE    byte = 0
+	INT64_TYPE     byte = 1
+	TOMBSTONE_TYPE byte = 2
+)
+
+// Старші біти байта типу зарезервовані під прапорці запису.
+const (
+	TYPE_MASK     byte = 0x3f
+	CHECKSUM_FLAG byte = 0x80
+	CHECKSUM_SIZE      = 4
+	// Версія запису займає 8 байтів між значенням і контрольною сумою.
+	VERSION_FLAG byte = 0x40
+	VERSION_SIZE      = 8
 )
 
+var ErrCorrupted = errors.New("corrupted record")
+
+// Encode serializes the entry, followed by its version, if it has one, and
+// a CRC32 checksum of the whole record.
 func (e *entry) Encode() []byte {
 	operator := operators[e.vType]
-	return operator.Encode(e)
+	res := operator.Encode(e)
+	kl := len(e.key)
+	if e.version != 0 {
+		res = binary.LittleEndian.AppendUint64(res, e.version)
+		res[kl+8] |= VERSION_FLAG
+	}
+	res = append(res, make([]byte, CHECKSUM_SIZE)...)
+	binary.LittleEndian.PutUint32(res, uint32(len(res)))
+	res[kl+8] |= CHECKSUM_FLAG
+	sum := crc32.ChecksumIEEE(res[:len(res)-CHECKSUM_SIZE])
+	binary.LittleEndian.PutUint32(res[len(res)-CHECKSUM_SIZE:], sum)
+	return res
 }
 
 func (e *entry) Decode(input []byte) {
@@ -139,12 +197,63 @@ func (e *entry) Decode(input []byte) {
 	copy(keyBuf, input[8:kl+8])
 	e.key = string(keyBuf)
 
-	typeValue := input[kl+8]
+	typeValue := input[kl+8] & TYPE_MASK
+	e.vType = typeValue
 	operator := operators[typeValue]
 
 	operator.Decode(input, e)
 }
 
+// decodeRecord checks the framing (and the checksum, if the record has one)
+// of a complete record and decodes it into e.
+func decodeRecord(input []byte, e *entry) error {
+	if len(input) < 8+TYPE_SIZE {
+		return fmt.Errorf("%w: record is too short (%d bytes)", ErrCorrupted, len(input))
+	}
+	size := int(binary.LittleEndian.Uint32(input))
+	if size != len(input) {
+		return fmt.Errorf("%w: size header %d does not match record length %d", ErrCorrupted, size, len(input))
+	}
+	kl := int(binary.LittleEndian.Uint32(input[4:]))
+	if kl < 0 || kl+8+TYPE_SIZE > size {
+		return fmt.Errorf("%w: key length %d exceeds record size %d", ErrCorrupted, kl, size)
+	}
+	typeValue := input[kl+8]
+	operator, ok := operators[typeValue&TYPE_MASK]
+	if !ok {
+		return fmt.Errorf("%w: unknown value type %d", ErrCorrupted, typeValue&TYPE_MASK)
+	}
+	end := size
+	if typeValue&CHECKSUM_FLAG != 0 {
+		end -= CHECKSUM_SIZE
+	}
+	if typeValue&VERSION_FLAG != 0 {
+		end -= VERSION_SIZE
+	}
+	vl := operator.Len(input, kl)
+	if vl < 0 || kl+8+TYPE_SIZE+vl > end {
+		return fmt.Errorf("%w: value of %d bytes does not fit into record", ErrCorrupted, vl)
+	}
+	if typeValue&CHECKSUM_FLAG != 0 {
+		signed := size - CHECKSUM_SIZE
+		expected := binary.LittleEndian.Uint32(input[signed:])
+		if actual := crc32.ChecksumIEEE(input[:signed]); actual != expected {
+			return fmt.Errorf("%w: checksum mismatch (stored %08x, computed %08x)", ErrCorrupted, expected, actual)
+		}
+	}
+	e.Decode(input)
+	e.version = 0
+	if typeValue&VERSION_FLAG != 0 {
+		e.version = binary.LittleEndian.Uint64(input[end:])
+	}
+	return nil
+}
+
+func hasChecksum(input []byte) bool {
+	kl := binary.LittleEndian.Uint32(input[4:])
+	return input[kl+8]&CHECKSUM_FLAG != 0
+}
+
 type output struct {
 	vType string
 	value string
@@ -170,10 +279,14 @@ func readValue(in *bufio.Reader) (output, error) {
 		return output{}, err
 	}
 
-	operator := operators[vType[0]]
+	typeValue := vType[0] & TYPE_MASK
+	operator, ok := operators[typeValue]
+	if !ok {
+		return output{}, fmt.Errorf("%w: unknown value type %d", ErrCorrupted, typeValue)
+	}
 	data, err := operator.Read(in)
 	if err != nil {
 		return output{}, err
 	}
-	return output{ToType(vType[0]), data}, nil
-}
\ No newline at end of file
+	return output{ToType(typeValue), data}, nil
+}
@@ -7,7 +7,7 @@ import (
 )
 
 func TestEntry_Encode(t *testing.T) {
-	e := entry{"key", ToByte("string"), "value"}
+	e := entry{key: "key", vType: ToByte("string"), value: "value"}
 	e.Decode(e.Encode())
 	if e.key != "key" {
 		t.Error("incorrect key")
@@ -17,8 +17,30 @@ func TestEntry_Encode(t *testing.T) {
 	}
 }
 
+func TestDecodeRecord_Version(t *testing.T) {
+	for _, e := range []entry{
+		{key: "key", vType: STRING_TYPE, value: "value", version: 42},
+		{key: "key", vType: INT64_TYPE, value: "-12", version: 1 << 40},
+		{key: "key", vType: TOMBSTONE_TYPE, version: 7},
+		{key: "legacy", vType: STRING_TYPE, value: "value"},
+	} {
+		data := e.Encode()
+		var decoded entry
+		if err := decodeRecord(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded != e {
+			t.Errorf("Decoded %+v, expected %+v", decoded, e)
+		}
+		data[len(data)-CHECKSUM_SIZE-1] ^= 0xff
+		if err := decodeRecord(data, &decoded); err == nil {
+			t.Errorf("Corrupted record %+v is accepted", e)
+		}
+	}
+}
+
 func TestReadValue(t *testing.T) {
-	e := entry{"key", ToByte("string"), "test-value"}
+	e := entry{key: "key", vType: ToByte("string"), value: "test-value"}
 	data := e.Encode()
 	v, err := readValue(bufio.NewReader(bytes.NewReader(data)))
 	if err != nil {
@@ -33,7 +55,7 @@ func TestReadValue(t *testing.T) {
 }
 
 func TestReadValueInt64(t *testing.T) {
-	e := entry{"key", ToByte("int64"), "-12"}
+	e := entry{key: "key", vType: ToByte("int64"), value: "-12"}
 	data := e.Encode()
 	v, err := readValue(bufio.NewReader(bytes.NewReader(data)))
 	if err != nil {
@@ -45,4 +67,4 @@ func TestReadValueInt64(t *testing.T) {
 	if v.vType != "int64" {
 		t.Errorf("Got bad value type [%s]", v)
 	}
-}
\ No newline at end of file
+}
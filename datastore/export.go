@@ -0,0 +1,335 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// ErrInvalidRecord is wrapped by the errors Import returns for malformed input.
+var ErrInvalidRecord = errors.New("invalid record")
+
+// Розмір пачки записів, яку імпорт скидає на диск одним викликом write.
+const importBatchSize = 1 << 20
+
+type exportedRecord struct {
+	Key     string          `json:"key"`
+	Type    string          `json:"type,omitempty"`
+	Value   json.RawMessage `json:"value"`
+	Version uint64          `json:"version,omitempty"`
+}
+
+// CSV не має стовпця версії, тож імпортовані з нього записи отримують нові версії.
+type recordWriter interface {
+	Write(key, vType, value string, version uint64) error
+	Flush() error
+}
+
+type recordReader interface {
+	Read() (key, vType, value string, version uint64, err error)
+}
+
+type jsonlWriter struct {
+	out *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(key, vType, value string, version uint64) error {
+	var raw json.RawMessage
+	if vType == "int64" {
+		raw = json.RawMessage(value)
+	} else {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		raw = data
+	}
+	return w.enc.Encode(exportedRecord{key, vType, raw, version})
+}
+
+func (w *jsonlWriter) Flush() error {
+	return w.out.Flush()
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+}
+
+func (r *jsonlReader) Read() (string, string, string, uint64, error) {
+	var rec exportedRecord
+	if err := r.dec.Decode(&rec); err != nil {
+		return "", "", "", 0, err
+	}
+	if len(rec.Value) == 0 {
+		return "", "", "", 0, fmt.Errorf("record %q has no value", rec.Key)
+	}
+
+	var value string
+	if rec.Value[0] == '"' {
+		if err := json.Unmarshal(rec.Value, &value); err != nil {
+			return "", "", "", 0, err
+		}
+		if rec.Type == "" {
+			rec.Type = "string"
+		}
+	} else {
+		value = string(rec.Value)
+		if rec.Type == "" {
+			rec.Type = "int64"
+		}
+	}
+	return rec.Key, rec.Type, value, rec.Version, nil
+}
+
+var csvHeader = []string{"key", "type", "value"}
+
+type csvWriter struct {
+	out    *csv.Writer
+	header bool
+}
+
+func (w *csvWriter) Write(key, vType, value string, _ uint64) error {
+	if !w.header {
+		w.header = true
+		if err := w.out.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	return w.out.Write([]string{key, vType, value})
+}
+
+func (w *csvWriter) Flush() error {
+	if !w.header {
+		w.header = true
+		if err := w.out.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	w.out.Flush()
+	return w.out.Error()
+}
+
+type csvReader struct {
+	in     *csv.Reader
+	header bool
+}
+
+func (r *csvReader) Read() (string, string, string, uint64, error) {
+	row, err := r.in.Read()
+	if err != nil {
+		return "", "", "", 0, err
+	}
+	if !r.header {
+		r.header = true
+		if row[0] == csvHeader[0] && row[1] == csvHeader[1] && row[2] == csvHeader[2] {
+			return r.Read()
+		}
+	}
+	return row[0], row[1], row[2], 0, nil
+}
+
+func newRecordWriter(w io.Writer, format string) (recordWriter, error) {
+	switch format {
+	case FormatJSONL:
+		out := bufio.NewWriter(w)
+		return &jsonlWriter{out, json.NewEncoder(out)}, nil
+	case FormatCSV:
+		return &csvWriter{out: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+func newRecordReader(r io.Reader, format string) (recordReader, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlReader{json.NewDecoder(r)}, nil
+	case FormatCSV:
+		in := csv.NewReader(r)
+		in.FieldsPerRecord = len(csvHeader)
+		return &csvReader{in: in}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// Export streams every key with its value and type to w in the given format.
+// Keys are written in sorted order; writes made during the export may or may
+// not be included.
+func (db *Db) Export(w io.Writer, format string) error {
+	out, err := newRecordWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	db.mu.RLock()
+	keys := db.keys()
+	db.mu.RUnlock()
+
+	for _, key := range keys {
+		db.mu.RLock()
+		value, vType, err := db.getType(key)
+		version := db.version(key)
+		db.mu.RUnlock()
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := out.Write(key, vType, value, version); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// Import loads records produced by Export and returns how many of them were
+// stored. Records are appended to the active segment in large batches
+// instead of going through the write goroutine one by one.
+func (db *Db) Import(r io.Reader, format string) (int, error) {
+	return db.importRecords(r, format, true)
+}
+
+// ImportMissing is like Import, but skips the keys that are already stored,
+// so it never replaces a value written concurrently with the import.
+func (db *Db) ImportMissing(r io.Reader, format string) (int, error) {
+	return db.importRecords(r, format, false)
+}
+
+func (db *Db) importRecords(r io.Reader, format string, overwrite bool) (int, error) {
+	in, err := newRecordReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		batch     []entry
+		batchSize int
+		imported  int
+	)
+	// Записи читаються й перевіряються без блокування, тож повільне
+	// завантаження не зупиняє Get і Put; db.mu береться лише на запис пачки.
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		entries := batch
+		if !overwrite {
+			entries = make([]entry, 0, len(batch))
+			for _, e := range batch {
+				if !db.contains(e.key) {
+					entries = append(entries, e)
+				}
+			}
+		}
+		batch, batchSize = batch[:0], 0
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := db.putBatch(entries); err != nil {
+			return err
+		}
+		imported += len(entries)
+		return nil
+	}
+
+	for line := 1; ; line++ {
+		key, vType, value, version, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("%w %d: %w", ErrInvalidRecord, line, err)
+		}
+		if err := db.checkSize(key, value); err != nil {
+			return imported, fmt.Errorf("%w %d: %w", ErrInvalidRecord, line, err)
+		}
+		switch vType {
+		case "string":
+		case "int64":
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return imported, fmt.Errorf("%w %d: %w", ErrInvalidRecord, line, err)
+			}
+		default:
+			return imported, fmt.Errorf("%w %d: unknown data type %q", ErrInvalidRecord, line, vType)
+		}
+
+		batch = append(batch, entry{key: key, vType: ToByte(vType), value: value, version: version})
+		batchSize += len(key) + len(value)
+		if batchSize >= importBatchSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.shouldMerge() {
+		db.requestMerge()
+	}
+	return imported, nil
+}
+
+// putBatch writes the entries into the active block, starting a new one
+// when the active block has grown over the segment size.
+func (db *Db) putBatch(entries []entry) error {
+	actBlock := db.blocks[len(db.blocks)-1]
+	actBlock.mu.RLock()
+	curSize := actBlock.outOffset
+	actBlock.mu.RUnlock()
+	if curSize > db.segmentSize {
+		err := db.addNewBlockToDb()
+		if err != nil {
+			return err
+		}
+		actBlock = db.blocks[len(db.blocks)-1]
+	}
+
+	older := make(map[string]*block)
+	// версії ключів, що трапляються в пачці кілька разів
+	batched := make(map[string]uint64)
+	for i, e := range entries {
+		if b := db.owner(e.key); b != nil {
+			older[e.key] = b
+		}
+		if v, ok := batched[e.key]; ok && e.version <= v {
+			entries[i].version = 0
+		}
+		entries[i].version = db.nextVersion(e.key, entries[i].version)
+		batched[e.key] = entries[i].version
+	}
+	if err := db.checkQuota(entries); err != nil {
+		return err
+	}
+	err := actBlock.putBatch(entries)
+	if err != nil {
+		return err
+	}
+	for key, b := range older {
+		b.retire(key)
+	}
+	for _, e := range entries {
+		db.cache.remove(e.key)
+	}
+	db.notify()
+	return nil
+}
@@ -0,0 +1,182 @@
+package datastore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDb_ExportImport(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			srcDir, err := ioutil.TempDir("", "test-db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(srcDir)
+			dstDir, err := ioutil.TempDir("", "test-db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dstDir)
+
+			src, err := NewDb(srcDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			if err := src.Put("name", "with \"quotes\", commas\nand lines"); err != nil {
+				t.Fatal(err)
+			}
+			if err := src.PutInt64("counter", -42); err != nil {
+				t.Fatal(err)
+			}
+			if err := src.Put("digits", "123"); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := src.Export(&buf, format); err != nil {
+				t.Fatal(err)
+			}
+
+			dst, err := NewDb(dstDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dst.Close()
+			n, err := dst.Import(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Errorf("Expected 3 imported records, got %d", n)
+			}
+
+			if value, err := dst.Get("name"); err != nil || value != "with \"quotes\", commas\nand lines" {
+				t.Errorf("Bad string value %q: %v", value, err)
+			}
+			if value, err := dst.Get("digits"); err != nil || value != "123" {
+				t.Errorf("Bad string value %q: %v", value, err)
+			}
+			if value, err := dst.GetInt64("counter"); err != nil || value != -42 {
+				t.Errorf("Bad int64 value %d: %v", value, err)
+			}
+		})
+	}
+}
+
+func TestDb_ImportInfersTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	input := `{"key": "a", "value": "text"}
+{"key": "b", "value": 7}
+`
+	if _, err := db.Import(strings.NewReader(input), FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("a"); err != nil || value != "text" {
+		t.Errorf("Bad string value %q: %v", value, err)
+	}
+	if value, err := db.GetInt64("b"); err != nil || value != 7 {
+		t.Errorf("Bad int64 value %d: %v", value, err)
+	}
+
+	_, err = db.Import(strings.NewReader(`{"key": "c", "type": "int64", "value": "x"}`), FormatJSONL)
+	if err == nil {
+		t.Error("Expected an error for a malformed int64 value")
+	}
+}
+
+func TestDb_ImportMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("a", "newer"); err != nil {
+		t.Fatal(err)
+	}
+	input := `{"key": "a", "value": "older"}
+{"key": "b", "value": "moved"}
+`
+	n, err := db.ImportMissing(strings.NewReader(input), FormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 imported record, got %d", n)
+	}
+	if value, err := db.Get("a"); err != nil || value != "newer" {
+		t.Errorf("Existing value was replaced: %q, %v", value, err)
+	}
+	if value, err := db.Get("b"); err != nil || value != "moved" {
+		t.Errorf("Bad imported value %q: %v", value, err)
+	}
+}
+
+func TestDb_ImportSlowReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Імпорт чекає на вхід, поки інші запити працюють.
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := db.Import(r, FormatJSONL)
+		done <- err
+	}()
+	if _, err := io.WriteString(w, `{"key": "a", "value": "imported"}`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error)
+	go func() {
+		written <- db.Put("b", "written")
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		w.Close()
+		t.Fatal("Put is blocked by a stalled import")
+	}
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("a"); err != nil || value != "imported" {
+		t.Errorf("Bad imported value %q: %v", value, err)
+	}
+}
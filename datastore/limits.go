@@ -0,0 +1,34 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrEmptyKey = errors.New("empty key")
+
+// ErrTooLarge is returned when a key or a value exceeds the configured limit.
+type ErrTooLarge struct {
+	// What is either "key" or "value".
+	What  string
+	Size  int
+	Limit int
+}
+
+func (e *ErrTooLarge) Error() string {
+	return fmt.Sprintf("%s of %d bytes exceeds the limit of %d bytes", e.What, e.Size, e.Limit)
+}
+
+// checkSize validates a record before it is written.
+func (db *Db) checkSize(key, value string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if len(key) > db.maxKeySize {
+		return &ErrTooLarge{"key", len(key), db.maxKeySize}
+	}
+	if len(value) > db.maxValueSize {
+		return &ErrTooLarge{"value", len(value), db.maxValueSize}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDb_SizeLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultOptions()
+	options.MaxKeySize = 8
+	options.MaxValueSize = 16
+	db, err := NewDbWithOptions(dir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var tooLarge *ErrTooLarge
+	if err := db.Put(strings.Repeat("k", 9), "value"); !errors.As(err, &tooLarge) || tooLarge.What != "key" {
+		t.Errorf("Expected ErrTooLarge for the key, got %v", err)
+	}
+	if err := db.Put("key", strings.Repeat("v", 17)); !errors.As(err, &tooLarge) || tooLarge.What != "value" {
+		t.Errorf("Expected ErrTooLarge for the value, got %v", err)
+	}
+	if err := db.PutInt64(strings.Repeat("k", 9), 1); !errors.As(err, &tooLarge) {
+		t.Errorf("Expected ErrTooLarge for the int64 key, got %v", err)
+	}
+	if err := db.Put("", "value"); err != ErrEmptyKey {
+		t.Errorf("Expected ErrEmptyKey, got %v", err)
+	}
+	if err := db.Put(strings.Repeat("k", 8), strings.Repeat("v", 16)); err != nil {
+		t.Errorf("Expected the record within limits to be stored, got %v", err)
+	}
+}
+
+func TestDb_RecoverCorruptedLength(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	path := filepath.Join(dir, "segment-1")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data, 0xfffffff0)
+	if err := ioutil.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDb(dir)
+	if !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+package datastore
+
+import "errors"
+
+// ErrLocked is returned when the database is open in another process.
+var ErrLocked = errors.New("database is used by another process")
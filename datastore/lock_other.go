@@ -0,0 +1,10 @@
+//go:build !unix
+
+package datastore
+
+import "os"
+
+// lockDir does nothing where flock is not available.
+func lockDir(dir *os.File) error {
+	return nil
+}
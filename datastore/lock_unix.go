@@ -0,0 +1,20 @@
+//go:build unix
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockDir takes an exclusive lock of the open directory of a database. The
+// lock is released when the directory is closed, even if the process dies.
+func lockDir(dir *os.File) error {
+	err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return fmt.Errorf("%w: %s", ErrLocked, dir.Name())
+	}
+	return err
+}
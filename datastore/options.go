@@ -0,0 +1,84 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyncPolicy defines when appended records are flushed to stable storage.
+type SyncPolicy string
+
+const (
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+	// SyncAlways flushes the segment after every write.
+	SyncAlways SyncPolicy = "always"
+	// SyncSecond flushes the active segment once a second.
+	SyncSecond SyncPolicy = "second"
+)
+
+const (
+	DefaultMaxKeySize   = 1 << 16
+	DefaultMaxValueSize = 1 << 24
+)
+
+// Options configures a database opened with NewDbWithOptions.
+type Options struct {
+	// SegmentSize is the size after which the active segment is sealed.
+	SegmentSize int64
+	// SegmentPrefix is the file name prefix of the segments.
+	SegmentPrefix string
+	// MergePolicy defines when sealed segments are merged automatically.
+	MergePolicy MergePolicy
+	// Sync defines when writes are flushed to disk.
+	Sync SyncPolicy
+	// CacheSize is the number of recently used values kept in memory;
+	// 0 disables the cache.
+	CacheSize int
+	// MaxKeySize and MaxValueSize limit the size of stored records in bytes.
+	MaxKeySize   int
+	MaxValueSize int
+	// Quota limits the amount of live data; it is not limited by default.
+	Quota Quota
+}
+
+// DefaultOptions returns the options used by NewDb. Their merge policy
+// merges the sealed segments as soon as a third one appears.
+func DefaultOptions() Options {
+	return Options{
+		SegmentSize:   outFileSize,
+		SegmentPrefix: outFileName,
+		MergePolicy:   MergePolicy{MaxSegments: 2},
+		Sync:          SyncNever,
+		MaxKeySize:    DefaultMaxKeySize,
+		MaxValueSize:  DefaultMaxValueSize,
+	}
+}
+
+func (o Options) validate() error {
+	if o.SegmentSize <= 0 {
+		return fmt.Errorf("segment size must be positive, got %d", o.SegmentSize)
+	}
+	if o.SegmentPrefix == "" || strings.ContainsAny(o.SegmentPrefix, `/\`) {
+		return fmt.Errorf("invalid segment prefix %q", o.SegmentPrefix)
+	}
+	switch o.Sync {
+	case SyncNever, SyncAlways, SyncSecond:
+	default:
+		return fmt.Errorf("unknown sync policy %q", o.Sync)
+	}
+	if o.CacheSize < 0 {
+		return fmt.Errorf("cache size must not be negative, got %d", o.CacheSize)
+	}
+	if o.MaxKeySize <= 0 || o.MaxValueSize <= 0 {
+		return fmt.Errorf("max key and value sizes must be positive")
+	}
+	if err := o.Quota.validate(); err != nil {
+		return err
+	}
+	if o.MergePolicy.MaxSegments < 0 || o.MergePolicy.GarbageRatio < 0 || o.MergePolicy.GarbageRatio > 1 ||
+		o.MergePolicy.Interval < 0 {
+		return fmt.Errorf("invalid merge policy %+v", o.MergePolicy)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewDbWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("invalid options", func(t *testing.T) {
+		for _, modify := range []func(*Options){
+			func(o *Options) { o.SegmentSize = 0 },
+			func(o *Options) { o.SegmentPrefix = "a/b" },
+			func(o *Options) { o.Sync = "sometimes" },
+			func(o *Options) { o.MergePolicy.GarbageRatio = 2 },
+		} {
+			options := DefaultOptions()
+			modify(&options)
+			if _, err := NewDbWithOptions(dir, options); err == nil {
+				t.Errorf("Expected an error for options %+v", options)
+			}
+		}
+	})
+
+	t.Run("custom prefix, sync and cache", func(t *testing.T) {
+		options := DefaultOptions()
+		options.SegmentPrefix = "data-"
+		options.Sync = SyncAlways
+		options.CacheSize = 1
+		db, err := NewDbWithOptions(dir, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		if err := db.Put("a", "1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put("b", "2"); err != nil {
+			t.Fatal(err)
+		}
+		for key, expected := range map[string]string{"a": "1", "b": "2"} {
+			if value, err := db.Get(key); err != nil || value != expected {
+				t.Errorf("Bad value for %s: %s, %v", key, value, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "data-1")); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrPositionGone is returned by ReadFrom when the requested position
+// belongs to a segment that was merged away.
+var ErrPositionGone = errors.New("position is no longer available")
+
+// Position points right after a record in one of the numbered segments.
+// Records are appended to the active segment only, so positions grow
+// monotonically and can be used to follow the stream of writes.
+type Position struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// Position returns the end of the active segment.
+func (db *Db) Position() Position {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.position()
+}
+
+func (db *Db) position() Position {
+	active := db.blocks[len(db.blocks)-1]
+	return Position{active.number, active.stats().TotalBytes}
+}
+
+// Changed returns a channel that is closed after the next write.
+func (db *Db) Changed() <-chan struct{} {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.changed
+}
+
+// notify wakes up everybody waiting on Changed. db.mu must be held.
+func (db *Db) notify() {
+	close(db.changed)
+	db.changed = make(chan struct{})
+}
+
+// ReadFrom calls fn for up to limit records appended after pos, passing the
+// position that follows each of them, and returns the position to continue
+// from. Merged segments can't be read; ErrPositionGone is returned if pos
+// points into one of them.
+//
+// The records are read under the lock and fn is called after it is
+// released, so a slow fn, such as a send to a follower, does not block the
+// writes.
+func (db *Db) ReadFrom(pos Position, limit int, fn func(Record, Position) error) (Position, error) {
+	type positioned struct {
+		record Record
+		next   Position
+	}
+	var batch []positioned
+	next, err := db.readFrom(pos, limit, func(r Record, p Position) error {
+		batch = append(batch, positioned{r, p})
+		return nil
+	})
+	for _, r := range batch {
+		if err := fn(r.record, r.next); err != nil {
+			return pos, err
+		}
+		pos = r.next
+	}
+	if err != nil {
+		return pos, err
+	}
+	return next, nil
+}
+
+// readFrom is ReadFrom calling fn under the lock.
+func (db *Db) readFrom(pos Position, limit int, fn func(Record, Position) error) (Position, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	start := -1
+	for i, b := range db.blocks {
+		if b.number != 0 && b.number >= pos.Segment {
+			start = i
+			break
+		}
+	}
+	if pos.Segment == 0 || start < 0 {
+		return pos, fmt.Errorf("%w: segment %d", ErrPositionGone, pos.Segment)
+	}
+	if db.blocks[start].number != pos.Segment {
+		// Сегмент уже злили, але якщо його було прочитано повністю, можна продовжити з наступного.
+		if end, ok := db.sealedEnds[pos.Segment]; !ok || end != pos.Offset {
+			return pos, fmt.Errorf("%w: segment %d", ErrPositionGone, pos.Segment)
+		}
+		pos = Position{db.blocks[start].number, 0}
+	}
+
+	read := 0
+	for _, b := range db.blocks[start:] {
+		if b.number != pos.Segment {
+			pos = Position{b.number, 0}
+		}
+		next, n, err := b.readFrom(pos.Offset, limit-read, func(r Record, offset int64) error {
+			return fn(r, Position{b.number, offset})
+		})
+		if err != nil {
+			return pos, err
+		}
+		pos.Offset = next
+		read += n
+		if read >= limit {
+			break
+		}
+	}
+	return pos, nil
+}
+
+// readFrom decodes up to limit records starting at offset.
+func (b *block) readFrom(offset int64, limit int, fn func(Record, int64) error) (int64, int, error) {
+	end := b.stats().TotalBytes
+	if offset > end {
+		return offset, 0, fmt.Errorf("%w: offset %d is beyond the end of segment %d", ErrPositionGone, offset, b.number)
+	}
+	if offset == end {
+		return offset, 0, nil
+	}
+
+	input, err := os.Open(b.outPath)
+	if err != nil {
+		return offset, 0, err
+	}
+	defer input.Close()
+	_, err = input.Seek(offset, io.SeekStart)
+	if err != nil {
+		return offset, 0, err
+	}
+	reader, err := newSegmentReader(input)
+	if err != nil {
+		return offset, 0, err
+	}
+	reader.offset, reader.limit = offset, end
+
+	n := 0
+	for n < limit && reader.offset < end {
+		start := reader.offset
+		e, data, err := reader.next()
+		if err != nil {
+			return start, n, err
+		}
+		err = fn(Record{
+			Offset:   start,
+			Size:     len(data),
+			Key:      e.key,
+			Type:     ToType(e.vType),
+			Value:    e.value,
+			Version:  e.version,
+			Checksum: hasChecksum(data),
+		}, reader.offset)
+		if err != nil {
+			return start, n, err
+		}
+		n++
+	}
+	return reader.offset, n, nil
+}
+
+// snapshotBatch is the number of values Snapshot reads under the lock at once.
+const snapshotBatch = 256
+
+// Snapshot writes all the data to w in the given export format and returns
+// the position a follower should continue from. The keys are listed at that
+// position and their values are read in batches, with the lock released
+// while they are written to w, so the writes go on during the snapshot. A
+// key changed after the position is left out, since the stream from the
+// position replays its latest record anyway.
+func (db *Db) Snapshot(w io.Writer, format string) (Position, error) {
+	out, err := newRecordWriter(w, format)
+	if err != nil {
+		return Position{}, err
+	}
+
+	db.mu.RLock()
+	keys := db.keys()
+	versions := make([]uint64, len(keys))
+	for i, key := range keys {
+		versions[i] = db.version(key)
+	}
+	pos := db.position()
+	db.mu.RUnlock()
+
+	type snapshotRecord struct {
+		key, vType, value string
+		version           uint64
+	}
+	for len(keys) > 0 {
+		n := min(len(keys), snapshotBatch)
+		batch := make([]snapshotRecord, 0, n)
+		db.mu.RLock()
+		for i, key := range keys[:n] {
+			if db.version(key) != versions[i] {
+				// Ключ змінили після знімка, його останній запис прийде з потоку.
+				continue
+			}
+			value, vType, err := db.getType(key)
+			if err != nil {
+				db.mu.RUnlock()
+				return Position{}, err
+			}
+			batch = append(batch, snapshotRecord{key, vType, value, versions[i]})
+		}
+		db.mu.RUnlock()
+		keys, versions = keys[n:], versions[n:]
+
+		for _, r := range batch {
+			if err := out.Write(r.key, r.vType, r.value, r.version); err != nil {
+				return Position{}, err
+			}
+		}
+	}
+	return pos, out.Flush()
+}
+
+// Reset removes all the data and starts from an empty segment.
+func (db *Db) Reset() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, b := range db.blocks {
+		b.close()
+		if err := b.delete(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	db.blocks = nil
+	db.sealedEnds = make(map[int]int64)
+	// Версії ж починаються спочатку, щоб імпорт знімка зберіг версії лідера.
+	db.lastVersion = 0
+	if db.cache != nil {
+		db.cache = newValueCache(db.cache.size)
+	}
+	// Нумерацію не скидаємо, щоб позиції не повторювались.
+	err := db.addNewBlockToDb()
+	if err != nil {
+		return err
+	}
+	db.notify()
+	return nil
+}
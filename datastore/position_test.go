@@ -0,0 +1,112 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withTimeout fails the test if fn does not return in time, as it would if
+// a write waited for the lock held by fn's caller.
+func withTimeout(t *testing.T, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writes are blocked")
+	}
+}
+
+func TestDb_ReadFromWithoutLock(t *testing.T) {
+	db, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 3; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	withTimeout(t, func() {
+		_, err = db.ReadFrom(Position{Segment: 1}, 10, func(r Record, _ Position) error {
+			keys = append(keys, r.Key)
+			return db.Put("written-"+r.Key, "value")
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(keys, ","); got != "key0,key1,key2" {
+		t.Errorf("Read %s", got)
+	}
+}
+
+// slowWriter writes to the db while a snapshot is written to it.
+type slowWriter struct {
+	bytes.Buffer
+	db *Db
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	if err := w.db.Put("key1", "changed"); err != nil {
+		return 0, err
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestDb_SnapshotWithoutLock(t *testing.T) {
+	db, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < snapshotBatch+10; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := &slowWriter{db: db}
+	var pos Position
+	withTimeout(t, func() {
+		pos, err = db.Snapshot(w, FormatJSONL)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Змінений під час знімка ключ приходить із потоку після позиції знімка.
+	dst, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err := dst.Import(&w.Buffer, FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ReadFrom(pos, 1000, func(r Record, _ Position) error {
+		_, err := dst.PutValueWith(r.Key, r.Type, r.Value, WriteOptions{Version: r.Version})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key0", "key1", fmt.Sprintf("key%d", snapshotBatch+9)} {
+		expected, _ := db.Get(key)
+		expectedVersion, _ := db.Version(key)
+		value, version, err := dst.GetWithVersion(key)
+		if err != nil || value != expected || version != expectedVersion {
+			t.Errorf("Got %s = %q, version %d instead of %q, version %d: %v", key, value, version, expected, expectedVersion, err)
+		}
+	}
+}
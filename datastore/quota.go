@@ -0,0 +1,88 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is wrapped by the errors of the writes that would take
+// the database over its Quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the live data of a database; a zero field means no limit.
+// Bytes are counted like Stats.LiveBytes, with the record headers. Writes
+// that shrink the data or delete keys are allowed even over the quota.
+type Quota struct {
+	MaxKeys  int   `json:"maxKeys,omitempty"`
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// Quota returns the current quota of the database.
+func (db *Db) Quota() Quota {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.quota
+}
+
+// SetQuota replaces the quota. The data already stored is kept even if it
+// exceeds the new one.
+func (db *Db) SetQuota(quota Quota) error {
+	if err := quota.validate(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.quota = quota
+	return nil
+}
+
+func (q Quota) validate() error {
+	if q.MaxKeys < 0 || q.MaxBytes < 0 {
+		return fmt.Errorf("quota limits must not be negative, got %+v", q)
+	}
+	return nil
+}
+
+// checkQuota returns an error if writing the entries would make the number
+// of keys or the live bytes grow over the quota. db.mu must be held.
+func (db *Db) checkQuota(entries []entry) error {
+	q := db.quota
+	if q.MaxKeys == 0 && q.MaxBytes == 0 {
+		return nil
+	}
+	var keys int
+	var live int64
+	for _, b := range db.blocks {
+		s := b.stats()
+		keys += s.Keys
+		live += s.LiveBytes
+	}
+	newKeys, newLive := keys, live
+	// попередні записи ключів, зокрема з цієї ж пачки
+	previous := make(map[string]position)
+	for _, e := range entries {
+		pos, ok := previous[e.key]
+		if !ok {
+			pos, ok = db.latest(e.key)
+		}
+		size := int64(len(e.Encode()))
+		deleted := e.vType == TOMBSTONE_TYPE
+		if ok {
+			newLive -= pos.size
+		}
+		newLive += size
+		if (!ok || pos.deleted) && !deleted {
+			newKeys++
+		} else if ok && !pos.deleted && deleted {
+			newKeys--
+		}
+		previous[e.key] = position{size: size, deleted: deleted}
+	}
+	if q.MaxKeys > 0 && newKeys > q.MaxKeys && newKeys > keys {
+		return fmt.Errorf("%w: %d keys exceed the limit of %d", ErrQuotaExceeded, newKeys, q.MaxKeys)
+	}
+	if q.MaxBytes > 0 && newLive > q.MaxBytes && newLive > live {
+		return fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrQuotaExceeded, newLive, q.MaxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDb_Quota(t *testing.T) {
+	options := DefaultOptions()
+	options.Quota = Quota{MaxKeys: 2}
+	db, err := NewDbWithOptions(t.TempDir(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put("a", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutInt64("b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("c", "value"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for the third key, got %v", err)
+	}
+	if err := db.Put("a", "overwritten"); err != nil {
+		t.Errorf("Overwriting a key is not a new one: %v", err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("c", "value"); err != nil {
+		t.Errorf("Deleting a key must free the quota: %v", err)
+	}
+	if _, err := db.Import(strings.NewReader(`{"key":"d","value":"x"}`+"\n"), FormatJSONL); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected import to be limited by the quota, got %v", err)
+	}
+
+	used := db.Stats().LiveBytes
+	if err := db.SetQuota(Quota{MaxBytes: used + 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("c", strings.Repeat("v", 100)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded for a large value, got %v", err)
+	}
+	if err := db.Put("c", "v"); err != nil {
+		t.Errorf("Shrinking a value must be allowed: %v", err)
+	}
+	if err := db.SetQuota(Quota{MaxBytes: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Errorf("Deletes must be allowed over the quota: %v", err)
+	}
+	if err := db.SetQuota(Quota{MaxKeys: -1}); err == nil {
+		t.Error("Negative quota is accepted")
+	}
+}
@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// hashedKey is a key along with the hash ordering it in a scan.
+type hashedKey struct {
+	hash uint64
+	key  string
+}
+
+// Scan returns about count keys starting from the cursor, and the cursor to
+// continue from, which is 0 when the iteration is complete. Start with the
+// cursor 0.
+//
+// Keys are visited in the order of their hashes and the cursor is the hash
+// to resume from, so a key that exists for the whole iteration is returned
+// even if others are added or deleted in the meantime. Keys with the same
+// hash are always returned together, which may make a page longer than
+// count.
+//
+// The keys are sorted once when an iteration starts with the cursor 0, and
+// the following pages are looked up in that snapshot, so a page costs
+// O(log N + count). A snapshot taken by a later iteration serves the ones in
+// progress just as well, since it holds every key that existed all along.
+func (db *Db) Scan(cursor uint64, count int) ([]string, uint64) {
+	if count <= 0 {
+		count = 10
+	}
+	db.scanMu.Lock()
+	if cursor == 0 || db.scanKeys == nil {
+		db.scanKeys = db.hashedKeys()
+	}
+	keys := db.scanKeys
+	db.scanMu.Unlock()
+
+	start := sort.Search(len(keys), func(i int) bool { return keys[i].hash >= cursor })
+	end, next := start, uint64(0)
+	for ; end < len(keys); end++ {
+		if end-start >= count && keys[end].hash != keys[end-1].hash {
+			next = keys[end].hash
+			break
+		}
+	}
+	if next == 0 {
+		db.scanMu.Lock()
+		if len(keys) == 0 || len(db.scanKeys) > 0 && &db.scanKeys[0] == &keys[0] {
+			// Обхід завершено, тож знімок більше не потрібен.
+			db.scanKeys = nil
+		}
+		db.scanMu.Unlock()
+	}
+
+	// Ключі, видалені після знімка, пропускаються.
+	page := make([]string, 0, end-start)
+	db.mu.RLock()
+	for _, hk := range keys[start:end] {
+		if db.exists(hk.key) {
+			page = append(page, hk.key)
+		}
+	}
+	db.mu.RUnlock()
+	return page, next
+}
+
+// hashedKeys returns the keys of the database sorted by their scan hashes.
+func (db *Db) hashedKeys() []hashedKey {
+	db.mu.RLock()
+	keys := db.keys()
+	db.mu.RUnlock()
+
+	hashed := make([]hashedKey, len(keys))
+	for i, key := range keys {
+		// Нульовий курсор означає кінець обходу, тож хеші починаються з 1.
+		hashed[i] = hashedKey{scanHash(key), key}
+	}
+	sort.Slice(hashed, func(i, j int) bool {
+		if hashed[i].hash != hashed[j].hash {
+			return hashed[i].hash < hashed[j].hash
+		}
+		return hashed[i].key < hashed[j].key
+	})
+	return hashed
+}
+
+func scanHash(key string) uint64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return uint64(h.Sum32()) + 1
+}
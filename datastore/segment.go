@@ -0,0 +1,142 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Record describes a single entry as it is laid out in a segment file.
+type Record struct {
+	Offset   int64
+	Size     int
+	Key      string
+	Type     string
+	Value    string
+	Version  uint64
+	Checksum bool
+}
+
+type segmentReader struct {
+	in     *bufio.Reader
+	offset int64
+	// розмір сегмента; заголовок запису не може вимагати більше байтів
+	limit int64
+	buf   [bufSize]byte
+}
+
+func newSegmentReader(input *os.File) (*segmentReader, error) {
+	info, err := input.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &segmentReader{in: bufio.NewReaderSize(input, bufSize), limit: info.Size()}, nil
+}
+
+// next reads the record at the current offset. io.EOF is returned only
+// when the segment ends exactly at a record boundary.
+func (r *segmentReader) next() (entry, []byte, error) {
+	header, err := r.in.Peek(4)
+	if err == io.EOF && len(header) == 0 {
+		return entry{}, nil, io.EOF
+	}
+	if err == io.EOF {
+		return entry{}, nil, fmt.Errorf("offset %d: %w: truncated header", r.offset, ErrCorrupted)
+	}
+	if err != nil {
+		return entry{}, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header)
+	if size < 8+TYPE_SIZE {
+		return entry{}, nil, fmt.Errorf("offset %d: %w: record size %d is too small", r.offset, ErrCorrupted, size)
+	}
+	if r.offset+int64(size) > r.limit {
+		return entry{}, nil, fmt.Errorf("offset %d: %w: record of %d bytes exceeds the segment", r.offset, ErrCorrupted, size)
+	}
+
+	var data []byte
+	if size <= bufSize {
+		data = r.buf[:size]
+	} else {
+		data = make([]byte, size)
+	}
+	_, err = io.ReadFull(r.in, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return entry{}, nil, fmt.Errorf("offset %d: %w: truncated record of %d bytes", r.offset, ErrCorrupted, size)
+	}
+	if err != nil {
+		return entry{}, nil, err
+	}
+
+	var e entry
+	if err := decodeRecord(data, &e); err != nil {
+		return entry{}, nil, fmt.Errorf("offset %d: %w", r.offset, err)
+	}
+	r.offset += int64(size)
+	return e, data, nil
+}
+
+// ScanSegment decodes the segment file at path and calls fn for every record
+// in the order they were written. Scanning stops at the first framing or
+// checksum error, which is returned wrapping ErrCorrupted.
+func ScanSegment(path string, fn func(Record) error) error {
+	input, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	reader, err := newSegmentReader(input)
+	if err != nil {
+		return err
+	}
+	for {
+		offset := reader.offset
+		e, data, err := reader.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(Record{
+			Offset:   offset,
+			Size:     len(data),
+			Key:      e.key,
+			Type:     ToType(e.vType),
+			Value:    e.value,
+			Version:  e.version,
+			Checksum: hasChecksum(data),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Segments returns paths of the segment files with the given name prefix
+// stored in dir, oldest first.
+func Segments(dir, prefix string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	filesNames, err := f.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+	names, err := sortSegments(filesNames, prefix)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+	}
+	return paths, nil
+}
@@ -0,0 +1,134 @@
+package datastore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-segment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key1", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutInt64("key2", -5); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	segments, err := Segments(dir, DefaultOptions().SegmentPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("Expected 1 segment, got %v", segments)
+	}
+
+	var records []Record
+	err = ScanSegment(segments[0], func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if records[0].Key != "key1" || records[0].Type != "string" || records[0].Value != "value1" {
+		t.Errorf("Unexpected first record %+v", records[0])
+	}
+	if records[1].Key != "key2" || records[1].Type != "int64" || records[1].Value != "-5" {
+		t.Errorf("Unexpected second record %+v", records[1])
+	}
+	if records[1].Offset != int64(records[0].Size) || !records[1].Checksum {
+		t.Errorf("Unexpected second record %+v", records[1])
+	}
+
+	t.Run("corrupted value", func(t *testing.T) {
+		data, err := ioutil.ReadFile(segments[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[records[0].Size-CHECKSUM_SIZE-1] ^= 0xff
+		if err := ioutil.WriteFile(segments[0], data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		err = ScanSegment(segments[0], func(Record) error { return nil })
+		if !errors.Is(err, ErrCorrupted) {
+			t.Errorf("Expected ErrCorrupted, got %v", err)
+		}
+	})
+}
+
+func TestSegments_Order(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-segment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"segment-10", "segment-2", "segment-0", "segment-1-temp"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments, err := Segments(dir, DefaultOptions().SegmentPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"segment-0", "segment-2", "segment-10"}
+	if len(segments) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, segments)
+	}
+	for i, name := range expected {
+		if filepath.Base(segments[i]) != name {
+			t.Errorf("Expected %v, got %v", expected, segments)
+		}
+	}
+}
+
+func TestDb_Compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"a", "b", "c"} {
+		if err := db.Put("key", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get("key"); err != nil || value != "c" {
+		t.Errorf("Bad value after compaction: %s, %v", value, err)
+	}
+	db.Close()
+
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if value, err := db.Get("key"); err != nil || value != "c" {
+		t.Errorf("Bad value after reopening: %s, %v", value, err)
+	}
+}
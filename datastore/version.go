@@ -0,0 +1,173 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ErrVersionMismatch is returned by PutValueWith when the key doesn't have
+// the expected version.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// Conditions of WriteOptions.IfVersion besides the exact versions.
+const (
+	// AnyVersion writes the value whatever the current version is.
+	AnyVersion uint64 = 0
+	// ExistingVersion only requires the key to exist.
+	ExistingVersion uint64 = math.MaxUint64
+)
+
+// WriteOptions control the versions of a write made by PutValueWith.
+type WriteOptions struct {
+	// IfVersion is the version the key must have for the write to happen.
+	IfVersion uint64
+	// Version is given to the new record if it is greater than the version
+	// of the previous record of the key; otherwise the next version of the
+	// db is taken. Replicas use it to keep the versions of the leader.
+	Version uint64
+}
+
+// GetWithVersion returns the string value of the key along with its
+// version. Every write of the key gives it a greater version.
+func (db *Db) GetWithVersion(key string) (string, uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	val, vType, err := db.getType(key)
+	if err != nil {
+		return "", 0, err
+	}
+	if vType != "string" {
+		return "", 0, ErrWrongType
+	}
+	return val, db.version(key), nil
+}
+
+// GetInt64WithVersion is GetWithVersion for int64 values.
+func (db *Db) GetInt64WithVersion(key string) (int64, uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	val, vType, err := db.getType(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if vType != "int64" {
+		return 0, 0, ErrWrongType
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, db.version(key), nil
+}
+
+// Version returns the current version of the key, or ErrNotFound.
+func (db *Db) Version(key string) (uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if v := db.version(key); v != 0 {
+		return v, nil
+	}
+	return 0, ErrNotFound
+}
+
+// PutValueWith is PutValue that checks and sets the versions as described
+// by options and returns the version of the new record. Unlike PutValue,
+// it returns ErrNotFound when a missing key is deleted without a condition.
+func (db *Db) PutValueWith(key, vType, value string, options WriteOptions) (uint64, error) {
+	switch vType {
+	case "string", "tombstone":
+	case "int64":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown data type %q", vType)
+	}
+	if err := db.checkSize(key, value); err != nil {
+		return 0, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current := db.version(key)
+	switch options.IfVersion {
+	case AnyVersion:
+		if vType == "tombstone" && current == 0 {
+			return 0, ErrNotFound
+		}
+	case ExistingVersion:
+		if current == 0 {
+			return 0, ErrVersionMismatch
+		}
+	default:
+		if current != options.IfVersion {
+			return 0, ErrVersionMismatch
+		}
+	}
+	return db.putVersion(key, vType, value, options.Version)
+}
+
+// version returns the version of the live value of the key, or 0 if there
+// is none. db.mu must be held.
+func (db *Db) version(key string) uint64 {
+	if pos, ok := db.latest(key); ok && !pos.deleted {
+		return pos.version
+	}
+	return 0
+}
+
+// latest returns the newest record of the key, which may be a deletion
+// marker. db.mu must be held.
+func (db *Db) latest(key string) (position, bool) {
+	for j := len(db.blocks) - 1; j >= 0; j-- {
+		if pos, ok := db.blocks[j].lookup(key); ok {
+			return pos, true
+		}
+	}
+	return position{}, false
+}
+
+// nextVersion returns version if it is greater than the version of the
+// previous record of the key, or the next version of the db otherwise.
+// db.mu must be held.
+func (db *Db) nextVersion(key string, version uint64) uint64 {
+	if pos, ok := db.latest(key); version == 0 || ok && version <= pos.version {
+		db.lastVersion++
+		return db.lastVersion
+	}
+	if version > db.lastVersion {
+		db.lastVersion = version
+	}
+	return version
+}
+
+// recoverVersions continues the numbering after the greatest stored
+// version, which a merge keeps even if it belongs to a deletion marker. Records written before versions were introduced get them in
+// memory, in the order of segments and keys; a merge saves them to disk.
+func (db *Db) recoverVersions() {
+	for _, b := range db.blocks {
+		for _, pos := range b.index {
+			if pos.version > db.lastVersion {
+				db.lastVersion = pos.version
+			}
+		}
+	}
+	for _, b := range db.blocks {
+		var keys []string
+		for key, pos := range b.index {
+			if pos.version == 0 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			pos := b.index[key]
+			db.lastVersion++
+			pos.version = db.lastVersion
+			b.index[key] = pos
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package datastore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDb_Versions(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Put("key", "first"); err != nil {
+		t.Fatal(err)
+	}
+	value, v1, err := db.GetWithVersion("key")
+	if err != nil || value != "first" || v1 == 0 {
+		t.Fatalf("Got %q, version %d: %v", value, v1, err)
+	}
+	if err := db.Put("key", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, v2, _ := db.GetWithVersion("key"); v2 <= v1 {
+		t.Errorf("Version did not grow: %d after %d", v2, v1)
+	}
+	if _, _, err := db.GetInt64WithVersion("key"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+
+	if _, err := db.PutValueWith("key", "string", "stale", WriteOptions{IfVersion: v1}); err != ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch for a stale version, got %v", err)
+	}
+	current, _ := db.Version("key")
+	v3, err := db.PutValueWith("key", "int64", "3", WriteOptions{IfVersion: current})
+	if err != nil || v3 <= current {
+		t.Fatalf("Conditional write returned version %d: %v", v3, err)
+	}
+	if n, v, err := db.GetInt64WithVersion("key"); err != nil || n != 3 || v != v3 {
+		t.Errorf("Got %d, version %d: %v", n, v, err)
+	}
+	if _, err := db.PutValueWith("missing", "string", "value", WriteOptions{IfVersion: ExistingVersion}); err != ErrVersionMismatch {
+		t.Errorf("Expected ErrVersionMismatch for a missing key, got %v", err)
+	}
+	if _, err := db.PutValueWith("missing", "tombstone", "", WriteOptions{}); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting a missing key, got %v", err)
+	}
+	if _, err := db.PutValueWith("key", "tombstone", "", WriteOptions{IfVersion: v3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Version("key"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a deleted key, got %v", err)
+	}
+
+	// Явна версія береться, лише якщо вона новіша за попередній запис ключа.
+	if v, err := db.PutValueWith("replicated", "string", "value", WriteOptions{Version: 1000}); err != nil || v != 1000 {
+		t.Errorf("Expected the given version 1000, got %d: %v", v, err)
+	}
+	if v, _ := db.PutValueWith("replicated", "string", "value", WriteOptions{Version: 10}); v <= 1000 {
+		t.Errorf("Version went back to %d", v)
+	}
+
+	last, _ := db.Version("replicated")
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.Version("replicated"); err != nil || v != last {
+		t.Errorf("Version %d is not kept after compaction and reopening, got %d: %v", last, v, err)
+	}
+	if err := db.Put("new", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Version("new"); v <= last {
+		t.Errorf("Numbering did not continue after reopening: %d", v)
+	}
+}
+
+func TestDb_RecoverUnversioned(t *testing.T) {
+	dir := t.TempDir()
+	var data []byte
+	for _, e := range []entry{
+		{key: "b", vType: STRING_TYPE, value: "old"},
+		{key: "a", vType: STRING_TYPE, value: "old"},
+		{key: "b", vType: STRING_TYPE, value: "new", version: 5},
+	} {
+		data = append(data, e.Encode()...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, outFileName+"1"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v, err := db.Version("b"); err != nil || v != 5 {
+		t.Errorf("Expected the stored version 5, got %d: %v", v, err)
+	}
+	if v, err := db.Version("a"); err != nil || v != 6 {
+		t.Errorf("Expected an unversioned record to get version 6, got %d: %v", v, err)
+	}
+}
+
+func TestDb_ExportImportVersions(t *testing.T) {
+	src, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	for _, key := range []string{"b", "a", "b", "c"} {
+		if err := src.Put(key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := src.Snapshot(&buf, FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := dst.Put("other", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Import(&buf, FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		expected, _ := src.Version(key)
+		if v, err := dst.Version(key); err != nil || v != expected {
+			t.Errorf("Version of %s is %d instead of %d: %v", key, v, expected, err)
+		}
+	}
+}
+
+func TestDb_VersionsAfterMergedDelete(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("kept", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("deleted", "value"); err != nil {
+		t.Fatal(err)
+	}
+	issued, _ := db.Version("deleted")
+	if err := db.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Видалення з найбільшою версією зникло б під час злиття.
+	db, err = NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Get("deleted"); err != ErrNotFound {
+		t.Errorf("Deleted key is back: %v", err)
+	}
+	if err := db.Put("deleted", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Version("deleted"); v <= issued+1 {
+		t.Errorf("Version %d was issued before the merge", v)
+	}
+}
@@ -0,0 +1,313 @@
+// Package dbclient is a client of the HTTP API of cmd/db.
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for keys that don't exist.
+	ErrNotFound = errors.New("record does not exist")
+	// ErrWrongType is returned when the value has another type than requested.
+	ErrWrongType = errors.New("wrong type of value")
+	// ErrTooLarge is returned when the key or the value exceeds the limits of the db.
+	ErrTooLarge = errors.New("key or value is too large")
+	// ErrQuotaExceeded is returned for writes over the quota of a namespace.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrNoNamespace is returned when the namespace of the client doesn't exist.
+	ErrNoNamespace = errors.New("namespace does not exist")
+)
+
+// StatusError is an unexpected response of the db. It matches the
+// sentinel errors with errors.Is.
+type StatusError struct {
+	Code int
+	// Reason is the error code from the JSON body, e.g. "not_found". It is
+	// empty for db nodes running with --legacy-errors.
+	Reason  string
+	Message string
+	err     error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("db responded with %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// Router picks the nodes that may store a key. Writes go to the first one;
+// reads try the others in order while the key is not found, and deletes go
+// to all of them.
+type Router interface {
+	Route(key string) []string
+}
+
+type singleNode string
+
+func (n singleNode) Route(string) []string {
+	return []string{string(n)}
+}
+
+// SingleNode routes every key to the node with the given host:port address.
+func SingleNode(address string) Router {
+	return singleNode(address)
+}
+
+// Options configures a Client.
+type Options struct {
+	// Timeout limits every attempt of a request.
+	Timeout time.Duration
+	// Retries is the number of times a request is repeated after a network
+	// error or a 5xx response.
+	Retries int
+	// Backoff is the pause before the first retry; it doubles with every
+	// next one up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxIdleConns is the number of kept-alive connections per node.
+	MaxIdleConns int
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Timeout:      3 * time.Second,
+		Retries:      3,
+		Backoff:      50 * time.Millisecond,
+		MaxBackoff:   time.Second,
+		MaxIdleConns: 16,
+	}
+}
+
+// Client sends requests to the db nodes chosen by a Router. It is safe for
+// concurrent use and reuses connections.
+type Client struct {
+	router  Router
+	options Options
+	http    *http.Client
+	// Scheme of the node URLs, "http" by default.
+	Scheme string
+	// Namespace of the keys; empty for the default keyspace of the db.
+	Namespace string
+}
+
+func New(router Router, options Options) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0
+	transport.MaxIdleConnsPerHost = options.MaxIdleConns
+	return &Client{
+		router:  router,
+		options: options,
+		http:    &http.Client{Transport: transport},
+		Scheme:  "http",
+	}
+}
+
+// Get returns the string value of the key.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := c.get(ctx, key, "string", &value)
+	return value, err
+}
+
+// GetInt64 returns the int64 value of the key.
+func (c *Client) GetInt64(ctx context.Context, key string) (int64, error) {
+	var value int64
+	err := c.get(ctx, key, "int64", &value)
+	return value, err
+}
+
+// Put stores a string value.
+func (c *Client) Put(ctx context.Context, key, value string) error {
+	return c.put(ctx, key, "string", value)
+}
+
+// PutInt64 stores an int64 value.
+func (c *Client) PutInt64(ctx context.Context, key string, value int64) error {
+	return c.put(ctx, key, "int64", strconv.FormatInt(value, 10))
+}
+
+// Delete removes the key. It returns ErrNotFound if there is no such key,
+// unless the key was deleted by an earlier attempt of the same call.
+//
+// The key is deleted on every routed node, since reads fall back to the
+// others: during a rebalance the previous owner still holds the key, and
+// it must not be read or moved to the new owner after the delete. The key
+// is not found only if no node had it.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	nodes := c.router.Route(key)
+	if len(nodes) == 0 {
+		return fmt.Errorf("no db node for key %q", key)
+	}
+	found := false
+	var notFound error
+	for _, node := range nodes {
+		attempted := false
+		err := c.retry(ctx, func(ctx context.Context) error {
+			err := c.do(ctx, http.MethodDelete, node, key, "", nil, nil)
+			if errors.Is(err, ErrNotFound) && attempted {
+				return nil
+			}
+			attempted = true
+			return err
+		})
+		switch {
+		case err == nil:
+			found = true
+		case errors.Is(err, ErrNotFound):
+			notFound = err
+		default:
+			return err
+		}
+	}
+	if found {
+		return nil
+	}
+	return notFound
+}
+
+func (c *Client) get(ctx context.Context, key, vType string, value interface{}) error {
+	nodes := c.router.Route(key)
+	if len(nodes) == 0 {
+		return fmt.Errorf("no db node for key %q", key)
+	}
+	var err error
+	for _, node := range nodes {
+		err = c.retry(ctx, func(ctx context.Context) error {
+			var data struct {
+				Value json.RawMessage `json:"value"`
+			}
+			if err := c.do(ctx, http.MethodGet, node, key, vType, nil, &data); err != nil {
+				return err
+			}
+			return json.Unmarshal(data.Value, value)
+		})
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) put(ctx context.Context, key, vType, value string) error {
+	nodes := c.router.Route(key)
+	if len(nodes) == 0 {
+		return fmt.Errorf("no db node for key %q", key)
+	}
+	form := url.Values{"value": {value}}
+	return c.retry(ctx, func(ctx context.Context) error {
+		return c.do(ctx, http.MethodPost, nodes[0], key, vType, form, nil)
+	})
+}
+
+// retry calls attempt until it succeeds, fails with an error that is not
+// worth repeating, or the retries are exhausted.
+func (c *Client) retry(ctx context.Context, attempt func(context.Context) error) error {
+	backoff := c.options.Backoff
+	for i := 0; ; i++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+		err := attempt(attemptCtx)
+		cancel()
+		if err == nil || i >= c.options.Retries || !temporary(err) || ctx.Err() != nil {
+			return err
+		}
+		// Випадкова частка паузи, щоб клієнти не повторювали запити синхронно.
+		pause := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(pause):
+		}
+		backoff = min(2*backoff, c.options.MaxBackoff)
+	}
+}
+
+// temporary reports whether a failed request may succeed when repeated.
+func temporary(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		// Квота й непідтримувані запити не зміняться від повторення.
+		if status.Code == http.StatusInsufficientStorage || status.Code == http.StatusNotImplemented {
+			return false
+		}
+		return status.Code >= 500 || status.Code == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+func (c *Client) do(ctx context.Context, method, node, key, vType string, form url.Values, out interface{}) error {
+	path := "/db/" + key
+	if c.Namespace != "" {
+		path = "/db/_ns/" + c.Namespace + "/" + key
+	}
+	u := url.URL{Scheme: c.Scheme, Host: node, Path: path}
+	if vType != "" {
+		u.RawQuery = url.Values{"type": {vType}}.Encode()
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// statusError reads the JSON error body of the response. Plain text bodies
+// of the legacy mode are matched by the message.
+func statusError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	err := &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if strings.HasPrefix(resp.Header.Get("content-type"), "application/json") && json.Unmarshal(data, &body) == nil {
+		err.Reason, err.Message = body.Code, body.Message
+	}
+	switch {
+	case err.Reason == "namespace_not_found":
+		err.err = ErrNoNamespace
+	case err.Reason == "quota_exceeded":
+		err.err = ErrQuotaExceeded
+	case err.Reason == "not_found" || resp.StatusCode == http.StatusNotFound || err.Message == ErrNotFound.Error():
+		err.err = ErrNotFound
+	case err.Reason == "type_mismatch" || err.Message == ErrWrongType.Error():
+		err.err = ErrWrongType
+	case err.Reason == "too_large" || resp.StatusCode == http.StatusRequestEntityTooLarge:
+		err.err = ErrTooLarge
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServer imitates the HTTP API of cmd/db with a map.
+type testServer struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+	// failures is the number of the next requests answered with 503.
+	failures atomic.Int32
+	requests atomic.Int32
+}
+
+func (s *testServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	if s.failures.Add(-1) >= 0 {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/db/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPost:
+		if r.URL.Query().Get("type") == "int64" {
+			s.values[key] = json.Number(r.FormValue("value"))
+		} else {
+			s.values[key] = r.FormValue("value")
+		}
+	case http.MethodDelete:
+		if _, ok := s.values[key]; !ok {
+			http.Error(rw, ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		delete(s.values, key)
+	default:
+		value, ok := s.values[key]
+		if !ok {
+			http.Error(rw, ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if _, isNumber := value.(json.Number); isNumber != (r.URL.Query().Get("type") == "int64") {
+			rw.Header().Set("content-type", "application/json")
+			rw.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(rw).Encode(map[string]string{"code": "type_mismatch", "message": "wrong type of value", "key": key})
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"key": key, "value": value})
+	}
+}
+
+func startServer(t *testing.T) (*testServer, string) {
+	s := &testServer{values: make(map[string]interface{})}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, strings.TrimPrefix(server.URL, "http://")
+}
+
+func testOptions() Options {
+	options := DefaultOptions()
+	options.Backoff = time.Millisecond
+	options.MaxBackoff = 5 * time.Millisecond
+	return options
+}
+
+func TestClient_Values(t *testing.T) {
+	_, node := startServer(t)
+	c := New(SingleNode(node), testOptions())
+	ctx := context.Background()
+
+	if err := c.Put(ctx, "name", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutInt64(ctx, "counter", -42); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get(ctx, "name"); err != nil || value != "value" {
+		t.Errorf("Bad string value %q: %v", value, err)
+	}
+	if value, err := c.GetInt64(ctx, "counter"); err != nil || value != -42 {
+		t.Errorf("Bad int64 value %d: %v", value, err)
+	}
+	if _, err := c.GetInt64(ctx, "name"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+
+	if err := c.Delete(ctx, "name"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Get(ctx, "name")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	}
+	if err := c.Delete(ctx, "name"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting a missing key, got %v", err)
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	s, node := startServer(t)
+	c := New(SingleNode(node), testOptions())
+	ctx := context.Background()
+
+	s.failures.Store(2)
+	if err := c.Put(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.requests.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	s.failures.Store(10)
+	s.requests.Store(0)
+	_, err := c.Get(ctx, "key")
+	var status *StatusError
+	if !errors.As(err, &status) || status.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the last 503, got %v", err)
+	}
+	if n := s.requests.Load(); n != int32(testOptions().Retries+1) {
+		t.Errorf("Expected %d attempts, got %d", testOptions().Retries+1, n)
+	}
+
+	// Помилки клієнта не повторюються.
+	s.failures.Store(0)
+	s.requests.Store(0)
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) || s.requests.Load() != 1 {
+		t.Errorf("Not found was retried: %v, %d requests", err, s.requests.Load())
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	c := New(SingleNode(strings.TrimPrefix(server.URL, "http://")), testOptions())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Get(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Get took %s despite the deadline", elapsed)
+	}
+}
+
+type twoNodes [2]string
+
+func (n twoNodes) Route(string) []string {
+	return n[:]
+}
+
+func TestClient_Fallback(t *testing.T) {
+	_, owner := startServer(t)
+	previous, previousNode := startServer(t)
+	previous.values["old"] = "value"
+	c := New(twoNodes{owner, previousNode}, testOptions())
+	ctx := context.Background()
+
+	if value, err := c.Get(ctx, "old"); err != nil || value != "value" {
+		t.Errorf("Key is not read from the previous owner: %q, %v", value, err)
+	}
+	if err := c.Put(ctx, "new", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := previous.values["new"]; ok {
+		t.Error("Write went to the previous owner")
+	}
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Видалений ключ не читається з попереднього власника.
+	if err := c.Delete(ctx, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Deleted key is still read from the previous owner: %v", err)
+	}
+	if err := c.Delete(ctx, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "new"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting a missing key, got %v", err)
+	}
+}
+
+func TestClient_Namespace(t *testing.T) {
+	s, node := startServer(t)
+	c := New(SingleNode(node), testOptions())
+	c.Namespace = "team"
+	if err := c.Put(context.Background(), "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if s.values["_ns/team/key"] != "value" {
+		t.Errorf("Value is not stored in the namespace: %v", s.values)
+	}
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusInsufficientStorage)
+		_ = json.NewEncoder(rw).Encode(map[string]string{"code": "quota_exceeded", "message": "quota exceeded"})
+	}))
+	defer server.Close()
+	c = New(SingleNode(strings.TrimPrefix(server.URL, "http://")), testOptions())
+	c.Namespace = "team"
+	if err := c.Put(context.Background(), "key", "value"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Write over the quota was retried %d times", requests.Load()-1)
+	}
+}
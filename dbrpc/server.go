@@ -0,0 +1,166 @@
+// Package dbrpc is the gRPC API of cmd/db. The messages and the client are
+// generated from db.proto.
+package dbrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative db.proto
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scanPage is the number of keys read from the datastore at once by Scan.
+const scanPage = 256
+
+// Server implements DbServer on top of a datastore.Db.
+type Server struct {
+	UnimplementedDbServer
+	db *datastore.Db
+	// ReadOnly rejects the writes, as on a replica.
+	ReadOnly bool
+}
+
+func NewServer(db *datastore.Db) *Server {
+	return &Server{db: db}
+}
+
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	value, err := s.get(req.Key)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return &GetResponse{Value: value}, nil
+}
+
+func (s *Server) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	if err := s.put(req); err != nil {
+		return nil, err
+	}
+	return &PutResponse{}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	if err := s.delete(req); err != nil {
+		return nil, err
+	}
+	return &DeleteResponse{}, nil
+}
+
+func (s *Server) Batch(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	resp := &BatchResponse{}
+	for i, op := range req.Operations {
+		if err := ctx.Err(); err != nil {
+			return resp, status.FromContextError(err).Err()
+		}
+		var err error
+		switch op := op.Op.(type) {
+		case *Operation_Put:
+			err = s.put(op.Put)
+		case *Operation_Delete:
+			err = s.delete(op.Delete)
+		default:
+			err = status.Errorf(codes.InvalidArgument, "operation %d is empty", i)
+		}
+		if err != nil {
+			st := status.Convert(err)
+			return resp, status.Errorf(st.Code(), "operation %d: %s", i, st.Message())
+		}
+		resp.Applied++
+	}
+	return resp, nil
+}
+
+func (s *Server) Scan(req *ScanRequest, stream Db_ScanServer) error {
+	sent := 0
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor = s.db.Scan(cursor, scanPage)
+		for _, key := range keys {
+			if !strings.HasPrefix(key, req.Prefix) {
+				continue
+			}
+			value, err := s.get(key)
+			if errors.Is(err, datastore.ErrNotFound) {
+				// Ключ видалили під час обходу.
+				continue
+			}
+			if err != nil {
+				return statusOf(err)
+			}
+			if err := stream.Send(&Record{Key: key, Value: value}); err != nil {
+				return err
+			}
+			sent++
+			if req.Limit > 0 && sent >= int(req.Limit) {
+				return nil
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Server) get(key string) (*Value, error) {
+	value, vType, err := s.db.GetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if vType == "int64" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Kind: &Value_Int64Value{Int64Value: n}}, nil
+	}
+	return &Value{Kind: &Value_StringValue{StringValue: value}}, nil
+}
+
+func (s *Server) put(req *PutRequest) error {
+	if s.ReadOnly {
+		return errReadOnly
+	}
+	var err error
+	switch value := req.GetValue().GetKind().(type) {
+	case *Value_StringValue:
+		err = s.db.Put(req.Key, value.StringValue)
+	case *Value_Int64Value:
+		err = s.db.PutInt64(req.Key, value.Int64Value)
+	default:
+		return status.Errorf(codes.InvalidArgument, "missing value of %q", req.Key)
+	}
+	return statusOf(err)
+}
+
+func (s *Server) delete(req *DeleteRequest) error {
+	if s.ReadOnly {
+		return errReadOnly
+	}
+	return statusOf(s.db.Delete(req.Key))
+}
+
+var errReadOnly = status.Error(codes.FailedPrecondition, "read-only replica, send writes to the leader")
+
+// statusOf maps datastore errors to gRPC status codes.
+func statusOf(err error) error {
+	var tooLarge *datastore.ErrTooLarge
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, datastore.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, datastore.ErrWrongType):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.As(err, &tooLarge) || errors.Is(err, datastore.ErrEmptyKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
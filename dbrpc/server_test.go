@@ -0,0 +1,176 @@
+package dbrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startServer(t *testing.T, readOnly bool) (*datastore.Db, DbClient) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	s := NewServer(db)
+	s.ReadOnly = readOnly
+	RegisterDbServer(server, s)
+	go server.Serve(l)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+		db.Close()
+	})
+	return db, NewDbClient(conn)
+}
+
+func stringValue(s string) *Value {
+	return &Value{Kind: &Value_StringValue{StringValue: s}}
+}
+
+func int64Value(n int64) *Value {
+	return &Value{Kind: &Value_Int64Value{Int64Value: n}}
+}
+
+func TestServer_GetPutDelete(t *testing.T) {
+	db, c := startServer(t, false)
+	ctx := context.Background()
+
+	if _, err := c.Put(ctx, &PutRequest{Key: "name", Value: stringValue("value")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(ctx, &PutRequest{Key: "counter", Value: int64Value(-5)}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.Get(ctx, &GetRequest{Key: "name"}); err != nil || resp.Value.GetStringValue() != "value" {
+		t.Errorf("Bad string value %v: %v", resp, err)
+	}
+	if resp, err := c.Get(ctx, &GetRequest{Key: "counter"}); err != nil || resp.Value.GetInt64Value() != -5 {
+		t.Errorf("Bad int64 value %v: %v", resp, err)
+	}
+	if n, err := db.GetInt64("counter"); err != nil || n != -5 {
+		t.Errorf("Value stored as %d, %v", n, err)
+	}
+
+	if _, err := c.Delete(ctx, &DeleteRequest{Key: "name"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, &GetRequest{Key: "name"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound after delete, got %v", err)
+	}
+	if _, err := c.Delete(ctx, &DeleteRequest{Key: "name"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound deleting a missing key, got %v", err)
+	}
+	if _, err := c.Put(ctx, &PutRequest{Key: "empty"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a missing value, got %v", err)
+	}
+	if _, err := c.Put(ctx, &PutRequest{Value: stringValue("value")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for an empty key, got %v", err)
+	}
+}
+
+func TestServer_Batch(t *testing.T) {
+	db, c := startServer(t, false)
+	ctx := context.Background()
+
+	resp, err := c.Batch(ctx, &BatchRequest{Operations: []*Operation{
+		{Op: &Operation_Put{Put: &PutRequest{Key: "a", Value: stringValue("1")}}},
+		{Op: &Operation_Put{Put: &PutRequest{Key: "b", Value: int64Value(2)}}},
+		{Op: &Operation_Delete{Delete: &DeleteRequest{Key: "a"}}},
+	}})
+	if err != nil || resp.Applied != 3 {
+		t.Fatalf("Batch failed: %v, %v", resp, err)
+	}
+	if db.Exists("a") || !db.Exists("b") {
+		t.Error("Batch operations are not applied")
+	}
+
+	resp, err = c.Batch(ctx, &BatchRequest{Operations: []*Operation{
+		{Op: &Operation_Put{Put: &PutRequest{Key: "c", Value: stringValue("3")}}},
+		{Op: &Operation_Delete{Delete: &DeleteRequest{Key: "missing"}}},
+		{Op: &Operation_Put{Put: &PutRequest{Key: "d", Value: stringValue("4")}}},
+	}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected the batch to stop at the missing key, got %v, %v", resp, err)
+	}
+	if !db.Exists("c") || db.Exists("d") {
+		t.Error("Batch did not stop at the failed operation")
+	}
+}
+
+func TestServer_Scan(t *testing.T) {
+	_, c := startServer(t, false)
+	ctx := context.Background()
+	var ops []*Operation
+	for i := 0; i < 600; i++ {
+		ops = append(ops,
+			&Operation{Op: &Operation_Put{Put: &PutRequest{Key: fmt.Sprintf("user:%d", i), Value: int64Value(int64(i))}}},
+			&Operation{Op: &Operation_Put{Put: &PutRequest{Key: fmt.Sprintf("team:%d", i), Value: stringValue("team")}}})
+	}
+	if _, err := c.Batch(ctx, &BatchRequest{Operations: ops}); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := func(req *ScanRequest) []*Record {
+		stream, err := c.Scan(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []*Record
+		for {
+			record, err := stream.Recv()
+			if err == io.EOF {
+				return records
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	records := scan(&ScanRequest{Prefix: "user:"})
+	if len(records) != 600 {
+		t.Fatalf("Expected 600 records, got %d", len(records))
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Value.GetInt64Value() < records[j].Value.GetInt64Value() })
+	for i, record := range records {
+		if record.Key != fmt.Sprintf("user:%d", i) || record.Value.GetInt64Value() != int64(i) {
+			t.Fatalf("Unexpected record %v", record)
+		}
+	}
+	if records := scan(&ScanRequest{Limit: 10}); len(records) != 10 {
+		t.Errorf("Expected 10 records with a limit, got %d", len(records))
+	}
+	if records := scan(&ScanRequest{}); len(records) != 1200 {
+		t.Errorf("Expected all 1200 records, got %d", len(records))
+	}
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	_, c := startServer(t, true)
+	_, err := c.Put(context.Background(), &PutRequest{Key: "key", Value: stringValue("value")})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition on a replica, got %v", err)
+	}
+}
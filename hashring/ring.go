@@ -0,0 +1,100 @@
+// Package hashring places nodes on a consistent hash ring. It is shared by
+// the sharding of the db keyspace and the hash policy of cmd/lb.
+package hashring
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// Ring maps keys to nodes with consistent hashing. Every node is placed on
+// the ring at a number of points proportional to its weight, so keys spread
+// evenly and adding or removing a node only moves the keys of the ranges
+// next to its points. A key is owned by the first node clockwise from its
+// hash; when that node is unavailable, the next one is taken, which spreads
+// the keys of a failed node over all the others.
+//
+// A Ring is immutable; a membership change builds a new one.
+type Ring struct {
+	nodes  []string
+	points []uint32
+	// owners are the indices in nodes of the owners of the points.
+	owners []int
+}
+
+// New places every node on the ring at replicas points per unit of its
+// weight. weights may be nil, then every node weighs 1. A node given twice
+// keeps its first place.
+func New(nodes []string, weights []int, replicas int) *Ring {
+	r := new(Ring)
+	owners := make(map[uint32]int)
+	seen := make(map[string]bool)
+	for i, node := range nodes {
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		weight := 1
+		if weights != nil {
+			weight = weights[i]
+		}
+		index := len(r.nodes)
+		r.nodes = append(r.nodes, node)
+		for j := 0; j < replicas*weight; j++ {
+			point := Hash(node + "#" + strconv.Itoa(j))
+			// Колізії рідкісні, але результат не повинен залежати від порядку вузлів.
+			if owner, ok := owners[point]; ok && r.nodes[owner] < node {
+				continue
+			}
+			owners[point] = index
+		}
+	}
+	r.points = make([]uint32, 0, len(owners))
+	for point := range owners {
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	r.owners = make([]int, len(r.points))
+	for i, point := range r.points {
+		r.owners[i] = owners[point]
+	}
+	return r
+}
+
+// Nodes returns the nodes of the ring in the order they were given.
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// Owner returns the node responsible for the key, or "" for an empty ring.
+func (r *Ring) Owner(key string) string {
+	if i := r.Lookup(key, nil); i >= 0 {
+		return r.nodes[i]
+	}
+	return ""
+}
+
+// Lookup returns the index in Nodes of the first node clockwise from the
+// hash of the key that is accepted by ok, or -1 if there is none. A nil ok
+// accepts every node.
+func (r *Ring) Lookup(key string, ok func(i int) bool) int {
+	h := Hash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	for i := 0; i < len(r.points); i++ {
+		owner := r.owners[(start+i)%len(r.points)]
+		if ok == nil || ok(owner) {
+			return owner
+		}
+	}
+	return -1
+}
+
+// Hash is the hash of the keys and the points of the ring. It is taken from
+// sha256, as the simpler hashes place the points of similar names, such as
+// "server1:8080#1" and "server1:8080#2", too close to each other.
+func Hash(s string) uint32 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,48 @@
+package hashring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing_Weights(t *testing.T) {
+	r := New([]string{"small", "big", "small"}, []int{1, 3, 5}, 100)
+	if nodes := r.Nodes(); len(nodes) != 2 {
+		t.Fatalf("Duplicate node is placed twice: %v", nodes)
+	}
+	counts := make(map[string]int)
+	const keys = 30000
+	for i := 0; i < keys; i++ {
+		counts[r.Owner(fmt.Sprintf("key-%d", i))]++
+	}
+	if share := float64(counts["big"]) / keys; share < 0.65 || share > 0.85 {
+		t.Errorf("Node of weight 3 out of 4 owns %.2f of the keys", share)
+	}
+}
+
+func TestRing_Lookup(t *testing.T) {
+	r := New([]string{"n1", "n2", "n3"}, nil, 100)
+	const keys = 10000
+	taken := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := r.Owner(key)
+		next := r.Nodes()[r.Lookup(key, func(i int) bool { return r.Nodes()[i] != "n3" })]
+		if owner != "n3" && next != owner {
+			t.Fatalf("Key %s moved from an available node %s to %s", key, owner, next)
+		}
+		if owner == "n3" {
+			taken[next]++
+		}
+	}
+	// Ключі недоступного вузла розходяться між усіма іншими.
+	if len(taken) != 2 {
+		t.Errorf("Keys of the unavailable node went to %v", taken)
+	}
+	if i := r.Lookup("key", func(int) bool { return false }); i != -1 {
+		t.Errorf("Expected -1 without available nodes, got %d", i)
+	}
+	if owner := New(nil, nil, 100).Owner("key"); owner != "" {
+		t.Errorf("Empty ring returned %q", owner)
+	}
+}
@@ -0,0 +1,83 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Defaults of the circuit breaker.
+const (
+	DefaultBreakerFailures    = 5
+	DefaultBreakerErrorRate   = 0.5
+	DefaultBreakerWindow      = 10 * time.Second
+	DefaultBreakerMinRequests = 20
+	DefaultBreakerOpenTime    = 30 * time.Second
+	DefaultBreakerProbes      = 3
+)
+
+// CircuitBreaker configures the breaker cmd/lb keeps for every backend. A
+// closed breaker lets the requests through until it trips on the failures
+// and opens. An open breaker stops the requests for OpenTime and then turns
+// half-open, admitting Probes requests at a time: the breaker closes when
+// that many of them succeed and opens again on the first failure. A request
+// fails with a connection error, a 5xx status or, if SlowRequest is set, a
+// response slower than it.
+type CircuitBreaker struct {
+	// Disabled turns the breakers off.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// Failures trips the breaker after that many failed requests in a row,
+	// 5 by default; -1 disables it.
+	Failures int `json:"failures,omitempty" yaml:"failures,omitempty"`
+	// ErrorRate trips the breaker when this share of the requests of the
+	// rolling Window fails, 0.5 by default; -1 disables it.
+	ErrorRate float64 `json:"errorRate,omitempty" yaml:"errorRate,omitempty"`
+	// Window is the rolling period of the error rate, 10s by default.
+	Window Duration `json:"window,omitempty" yaml:"window,omitempty"`
+	// MinRequests is the number of requests in the window needed to judge
+	// the error rate, 20 by default.
+	MinRequests int `json:"minRequests,omitempty" yaml:"minRequests,omitempty"`
+	// OpenTime is how long the breaker stays open, 30s by default.
+	OpenTime Duration `json:"openTime,omitempty" yaml:"openTime,omitempty"`
+	// Probes is the number of requests admitted at a time by a half-open
+	// breaker and needed to close it, 3 by default.
+	Probes int `json:"probes,omitempty" yaml:"probes,omitempty"`
+	// SlowRequest counts the responses slower than it as failures. It is
+	// disabled if not set.
+	SlowRequest Duration `json:"slowRequest,omitempty" yaml:"slowRequest,omitempty"`
+}
+
+func (b *CircuitBreaker) validate() error {
+	if b.Failures == 0 {
+		b.Failures = DefaultBreakerFailures
+	}
+	if b.ErrorRate == 0 {
+		b.ErrorRate = DefaultBreakerErrorRate
+	}
+	if b.Window == 0 {
+		b.Window = Duration(DefaultBreakerWindow)
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = DefaultBreakerMinRequests
+	}
+	if b.OpenTime == 0 {
+		b.OpenTime = Duration(DefaultBreakerOpenTime)
+	}
+	if b.Probes == 0 {
+		b.Probes = DefaultBreakerProbes
+	}
+	var errs []error
+	if b.Failures < -1 {
+		errs = append(errs, fmt.Errorf("circuit breaker failures must be positive or -1, got %d", b.Failures))
+	}
+	if b.ErrorRate != -1 && (b.ErrorRate < 0 || b.ErrorRate > 1) {
+		errs = append(errs, fmt.Errorf("circuit breaker error rate must be between 0 and 1 or -1, got %g", b.ErrorRate))
+	}
+	if b.MinRequests < 0 || b.Probes < 0 {
+		errs = append(errs, errors.New("circuit breaker minimum requests and probes must be positive"))
+	}
+	if b.Window < 0 || b.OpenTime < 0 || b.SlowRequest < 0 {
+		errs = append(errs, errors.New("circuit breaker window, open time and slow request time must be positive"))
+	}
+	return errors.Join(errs...)
+}
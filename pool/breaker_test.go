@@ -0,0 +1,26 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_Validate(t *testing.T) {
+	var b CircuitBreaker
+	if err := b.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Failures != DefaultBreakerFailures || time.Duration(b.OpenTime) != DefaultBreakerOpenTime || b.Probes != DefaultBreakerProbes || b.SlowRequest != 0 {
+		t.Errorf("Unexpected defaults %+v", b)
+	}
+	for _, b := range []CircuitBreaker{
+		{Failures: -2},
+		{ErrorRate: 1.5},
+		{Probes: -1},
+		{SlowRequest: Duration(-time.Second)},
+	} {
+		if err := b.validate(); err == nil {
+			t.Errorf("%+v is accepted", b)
+		}
+	}
+}
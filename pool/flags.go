@@ -0,0 +1,127 @@
+package pool
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Environment variables read for the flags not given on the command line.
+const (
+	EnvConfig   = "LB_CONFIG"
+	EnvBackends = "LB_BACKENDS"
+	EnvHTTPS    = "LB_HTTPS"
+	EnvPolicy   = "LB_POLICY"
+	EnvRoutes   = "LB_ROUTES"
+)
+
+// Flags select the pool of a command. The backends are taken from
+// --backends, then from the --config file, then from the defaults of the
+// command; --https, if given, sets the default scheme, overriding the file
+// either way. A flag that was not given on the command line is read from
+// LB_BACKENDS, LB_CONFIG or LB_HTTPS.
+type Flags struct {
+	fs       *flag.FlagSet
+	defaults string
+
+	config   *string
+	backends *string
+	https    *bool
+	policy   *string
+	routes   *string
+}
+
+// RegisterFlags defines the flags of the pool on fs. defaults is the list of
+// backends in the format of ParseBackends used when none is configured.
+func RegisterFlags(fs *flag.FlagSet, defaults string) *Flags {
+	return &Flags{
+		fs:       fs,
+		defaults: defaults,
+		config:   fs.String("config", "", "YAML or JSON file with the backends (env "+EnvConfig+")"),
+		backends: fs.String("backends", "", "comma separated backends as [scheme://]host:port[?weight=N&tag=T], overrides the config file (env "+EnvBackends+")"),
+		https:    fs.Bool("https", false, "whether backends support HTTPs by default (env "+EnvHTTPS+")"),
+	}
+}
+
+// WithPolicy defines the flags selecting the policies of cmd/lb, which
+// override the ones of the config file: --policy from LB_POLICY and
+// --routes from LB_ROUTES.
+func (f *Flags) WithPolicy() *Flags {
+	f.policy = f.fs.String("policy", "", "policy of choosing a backend: round-robin, weighted-round-robin, least-outstanding, p2c or hash[:ip|:path|:header:<name>|:cookie:<name>] (env "+EnvPolicy+")")
+	f.routes = f.fs.String("routes", "", "comma separated policies of path prefixes as prefix=policy, the longest prefix wins (env "+EnvRoutes+")")
+	return f
+}
+
+// ConfigFile returns the path of the configuration file, if any.
+func (f *Flags) ConfigFile() string {
+	config, _ := f.lookup("config", EnvConfig, *f.config)
+	return config
+}
+
+// Load builds and validates the configuration selected by the flags. It
+// reads the file again on every call.
+func (f *Flags) Load() (*Config, error) {
+	c := &Config{}
+	if config := f.ConfigFile(); config != "" {
+		var err error
+		if c, err = Load(config); err != nil {
+			return nil, err
+		}
+	}
+	list, _ := f.lookup("backends", EnvBackends, *f.backends)
+	if list == "" && len(c.Backends) == 0 {
+		list = f.defaults
+	}
+	if list != "" {
+		backends, err := ParseBackends(list)
+		if err != nil {
+			return nil, err
+		}
+		c.Backends = backends
+	}
+	if https, ok := f.lookup("https", EnvHTTPS, strconv.FormatBool(*f.https)); ok {
+		enabled, err := strconv.ParseBool(https)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q of %s: %w", https, EnvHTTPS, err)
+		}
+		// Прапорець, заданий явно, перекриває схему з файлу в обидва боки.
+		c.Scheme = SchemeHTTP
+		if enabled {
+			c.Scheme = SchemeHTTPS
+		}
+	}
+	if f.policy != nil {
+		if policy, _ := f.lookup("policy", EnvPolicy, *f.policy); policy != "" {
+			c.Policy = policy
+		}
+		if list, _ := f.lookup("routes", EnvRoutes, *f.routes); list != "" {
+			routes, err := ParseRoutes(list)
+			if err != nil {
+				return nil, err
+			}
+			c.Routes = routes
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid backend pool: %w", err)
+	}
+	return c, nil
+}
+
+// lookup returns the value of the flag if it was set on the command line,
+// otherwise the one from the environment variable. ok reports whether any
+// of them was set.
+func (f *Flags) lookup(name, env, value string) (string, bool) {
+	set := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		set = set || fl.Name == name
+	})
+	if set {
+		return value, true
+	}
+	if v, ok := os.LookupEnv(env); ok {
+		return v, true
+	}
+	return value, false
+}
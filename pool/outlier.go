@@ -0,0 +1,94 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Defaults of the outlier detection.
+const (
+	DefaultConsecutiveErrors  = 5
+	DefaultOutlierInterval    = 10 * time.Second
+	DefaultOutlierErrorRate   = 0.5
+	DefaultOutlierMinRequests = 20
+	DefaultBaseEjection       = 30 * time.Second
+	DefaultMaxEjection        = 5 * time.Minute
+	DefaultMaxEjectedFraction = 0.5
+)
+
+// OutlierDetection configures the passive health checking of cmd/lb, which
+// ejects the backends failing the proxied requests from the pool. A request
+// fails with a connection error or a 5xx status. The ejection lasts
+// BaseEjection times the number of ejections of the backend in a row, which
+// doubles each time up to MaxEjection.
+type OutlierDetection struct {
+	// Disabled turns the detection off.
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// ConsecutiveErrors ejects a backend right away after that many failed
+	// requests in a row, 5 by default; -1 disables it.
+	ConsecutiveErrors int `json:"consecutiveErrors,omitempty" yaml:"consecutiveErrors,omitempty"`
+	// Interval is the window in which the error rate and the latency are
+	// measured, 10s by default.
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// ErrorRate ejects a backend with this share of failed requests in the
+	// window, 0.5 by default; -1 disables it.
+	ErrorRate float64 `json:"errorRate,omitempty" yaml:"errorRate,omitempty"`
+	// LatencyFactor ejects a backend whose mean latency in the window is
+	// that many times the median of the pool. It is disabled if not set.
+	LatencyFactor float64 `json:"latencyFactor,omitempty" yaml:"latencyFactor,omitempty"`
+	// MinRequests is the number of requests a backend needs in the window
+	// to be judged by the error rate and the latency, 20 by default.
+	MinRequests int `json:"minRequests,omitempty" yaml:"minRequests,omitempty"`
+	// BaseEjection and MaxEjection limit the time of an ejection, 30s and
+	// 5m by default.
+	BaseEjection Duration `json:"baseEjection,omitempty" yaml:"baseEjection,omitempty"`
+	MaxEjection  Duration `json:"maxEjection,omitempty" yaml:"maxEjection,omitempty"`
+	// MaxEjectedFraction is the share of the pool that can be ejected at
+	// once, 0.5 by default.
+	MaxEjectedFraction float64 `json:"maxEjectedFraction,omitempty" yaml:"maxEjectedFraction,omitempty"`
+}
+
+func (o *OutlierDetection) validate() error {
+	if o.ConsecutiveErrors == 0 {
+		o.ConsecutiveErrors = DefaultConsecutiveErrors
+	}
+	if o.Interval == 0 {
+		o.Interval = Duration(DefaultOutlierInterval)
+	}
+	if o.ErrorRate == 0 {
+		o.ErrorRate = DefaultOutlierErrorRate
+	}
+	if o.MinRequests == 0 {
+		o.MinRequests = DefaultOutlierMinRequests
+	}
+	if o.BaseEjection == 0 {
+		o.BaseEjection = Duration(DefaultBaseEjection)
+	}
+	if o.MaxEjection == 0 {
+		o.MaxEjection = Duration(DefaultMaxEjection)
+	}
+	if o.MaxEjectedFraction == 0 {
+		o.MaxEjectedFraction = DefaultMaxEjectedFraction
+	}
+	var errs []error
+	if o.ConsecutiveErrors < -1 {
+		errs = append(errs, fmt.Errorf("consecutive errors must be positive or -1, got %d", o.ConsecutiveErrors))
+	}
+	if o.ErrorRate != -1 && (o.ErrorRate < 0 || o.ErrorRate > 1) {
+		errs = append(errs, fmt.Errorf("error rate must be between 0 and 1 or -1, got %g", o.ErrorRate))
+	}
+	if o.LatencyFactor < 0 || (o.LatencyFactor > 0 && o.LatencyFactor <= 1) {
+		errs = append(errs, fmt.Errorf("latency factor must be greater than 1, got %g", o.LatencyFactor))
+	}
+	if o.MinRequests < 0 {
+		errs = append(errs, fmt.Errorf("minimum requests must be positive, got %d", o.MinRequests))
+	}
+	if o.Interval < 0 || o.BaseEjection < 0 || o.MaxEjection < o.BaseEjection {
+		errs = append(errs, errors.New("outlier detection interval and ejection times must be positive and the maximum ejection not less than the base one"))
+	}
+	if o.MaxEjectedFraction < 0 || o.MaxEjectedFraction > 1 {
+		errs = append(errs, fmt.Errorf("maximum ejected fraction must be between 0 and 1, got %g", o.MaxEjectedFraction))
+	}
+	return errors.Join(errs...)
+}
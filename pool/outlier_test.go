@@ -0,0 +1,27 @@
+package pool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierDetection_Validate(t *testing.T) {
+	var o OutlierDetection
+	if err := o.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if o.ConsecutiveErrors != DefaultConsecutiveErrors || time.Duration(o.MaxEjection) != DefaultMaxEjection || o.MaxEjectedFraction != DefaultMaxEjectedFraction || o.LatencyFactor != 0 {
+		t.Errorf("Unexpected defaults %+v", o)
+	}
+	for _, o := range []OutlierDetection{
+		{ErrorRate: 2},
+		{LatencyFactor: 0.5},
+		{MaxEjectedFraction: 1.5},
+		{BaseEjection: Duration(time.Hour), MaxEjection: Duration(time.Minute)},
+		{ConsecutiveErrors: -2},
+	} {
+		if err := o.validate(); err == nil {
+			t.Errorf("%+v is accepted", o)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Policies of choosing a backend for a request.
+const (
+	// PolicyRoundRobin sends the requests to the backends in turn.
+	PolicyRoundRobin = "round-robin"
+	// PolicyWeightedRoundRobin sends the requests in turn in proportion to
+	// the weights of the backends.
+	PolicyWeightedRoundRobin = "weighted-round-robin"
+	// PolicyLeastOutstanding sends a request to the backend with the least
+	// requests in flight relative to its weight.
+	PolicyLeastOutstanding = "least-outstanding"
+	// PolicyP2C picks two backends at random and sends a request to the one
+	// with less requests in flight.
+	PolicyP2C = "p2c"
+	// PolicyHash sends the requests with the same key to the same backend
+	// on a consistent hash ring.
+	PolicyHash = "hash"
+)
+
+// Keys of the hash policy.
+const (
+	HashIP     = "ip"
+	HashHeader = "header"
+	HashCookie = "cookie"
+	HashPath   = "path"
+)
+
+// Policy is a policy of choosing a backend written as its name, with the key
+// for the hash policy: "hash:ip", "hash:header:X-User-ID",
+// "hash:cookie:session" or "hash:path". "hash" alone hashes the client IP.
+type Policy struct {
+	Name string
+	// Key and Param are the key of the hash policy and the name of the
+	// header or the cookie it is read from.
+	Key   string
+	Param string
+}
+
+// ParsePolicy parses and checks a policy.
+func ParsePolicy(s string) (Policy, error) {
+	parts := strings.SplitN(s, ":", 3)
+	p := Policy{Name: parts[0]}
+	switch p.Name {
+	case PolicyRoundRobin, PolicyWeightedRoundRobin, PolicyLeastOutstanding, PolicyP2C:
+		if len(parts) > 1 {
+			return p, fmt.Errorf("policy %q takes no parameters", p.Name)
+		}
+	case PolicyHash:
+		p.Key = HashIP
+		if len(parts) > 1 {
+			p.Key = parts[1]
+		}
+		if len(parts) > 2 {
+			p.Param = parts[2]
+		}
+		switch p.Key {
+		case HashIP, HashPath:
+			if p.Param != "" {
+				return p, fmt.Errorf("hash key %q takes no name in policy %q", p.Key, s)
+			}
+		case HashHeader, HashCookie:
+			if p.Param == "" {
+				return p, fmt.Errorf("hash key %q needs a name in policy %q, e.g. %s:%s:X-User-ID", p.Key, s, PolicyHash, p.Key)
+			}
+			if p.Key == HashHeader {
+				p.Param = http.CanonicalHeaderKey(p.Param)
+			}
+		default:
+			return p, fmt.Errorf("unknown hash key %q in policy %q, expected ip, header, cookie or path", p.Key, s)
+		}
+	default:
+		return p, fmt.Errorf("unknown policy %q", s)
+	}
+	return p, nil
+}
+
+func (p Policy) String() string {
+	s := p.Name
+	if p.Key != "" {
+		s += ":" + p.Key
+	}
+	if p.Param != "" {
+		s += ":" + p.Param
+	}
+	return s
+}
+
+// Route selects the policy of the requests with a path prefix.
+type Route struct {
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Policy string `json:"policy" yaml:"policy"`
+}
+
+// ParseRoutes parses a comma separated list of routes given as
+// prefix=policy, for example "/api/=least-outstanding,/static/=hash:path".
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		prefix, policy, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route %q, expected prefix=policy", item)
+		}
+		routes = append(routes, Route{Prefix: prefix, Policy: policy})
+	}
+	return routes, nil
+}
+
+// Affinity modes.
+const (
+	AffinityIP     = "ip"
+	AffinityHeader = "header"
+	AffinityCookie = "cookie"
+)
+
+// Defaults of the affinity.
+const (
+	DefaultAffinityCookie = "lb-backend"
+	DefaultAffinityTTL    = time.Hour
+)
+
+// Affinity pins a client to the backend chosen for its first request, so
+// its next requests go to the same backend whatever the policy. When that
+// backend becomes unavailable, the client is pinned to another one.
+type Affinity struct {
+	// Mode identifies the clients by the IP, by a request header or by a
+	// cookie issued by cmd/lb. There is no affinity if it is empty.
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// Header identifying the client in the header mode, e.g. X-User-ID.
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	// Cookie is the name of the cookie of the cookie mode, lb-backend by
+	// default.
+	Cookie string `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	// TTL is how long a client stays pinned after its last request, 1h by
+	// default.
+	TTL Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+func (a *Affinity) validate() error {
+	if a.Cookie == "" {
+		a.Cookie = DefaultAffinityCookie
+	}
+	if a.TTL == 0 {
+		a.TTL = Duration(DefaultAffinityTTL)
+	}
+	switch a.Mode {
+	case "", AffinityIP, AffinityCookie:
+	case AffinityHeader:
+		if a.Header == "" {
+			return errors.New("affinity by header needs the name of the header")
+		}
+		a.Header = http.CanonicalHeaderKey(a.Header)
+	default:
+		return fmt.Errorf("unknown affinity mode %q, expected ip, header or cookie", a.Mode)
+	}
+	if a.TTL < 0 {
+		return fmt.Errorf("affinity TTL must be positive, got %s", time.Duration(a.TTL))
+	}
+	return nil
+}
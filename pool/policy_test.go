@@ -0,0 +1,76 @@
+package pool
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for s, expected := range map[string]Policy{
+		"round-robin":           {Name: PolicyRoundRobin},
+		"weighted-round-robin":  {Name: PolicyWeightedRoundRobin},
+		"least-outstanding":     {Name: PolicyLeastOutstanding},
+		"p2c":                   {Name: PolicyP2C},
+		"hash":                  {Name: PolicyHash, Key: HashIP},
+		"hash:path":             {Name: PolicyHash, Key: HashPath},
+		"hash:header:x-user-id": {Name: PolicyHash, Key: HashHeader, Param: "X-User-Id"},
+		"hash:cookie:session":   {Name: PolicyHash, Key: HashCookie, Param: "session"},
+	} {
+		p, err := ParsePolicy(s)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+		} else if p != expected {
+			t.Errorf("%s is parsed as %+v", s, p)
+		}
+	}
+	if p, _ := ParsePolicy("hash"); p.String() != "hash:ip" {
+		t.Errorf("Unexpected string %q", p)
+	}
+	for _, s := range []string{"", "random", "p2c:ip", "hash:header", "hash:ip:x", "hash:query"} {
+		if _, err := ParsePolicy(s); err == nil {
+			t.Errorf("%q is accepted", s)
+		}
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("/api/=least-outstanding, /static/=hash:path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Route{{"/api/", PolicyLeastOutstanding}, {"/static/", "hash:path"}}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("Unexpected routes %+v", routes)
+	}
+	if _, err := ParseRoutes("/api/"); err == nil {
+		t.Error("Route without a policy is accepted")
+	}
+
+	c := Config{
+		Routes:   []Route{{"api", "p2c"}, {"/a/", "p2c"}, {"/a/", "random"}},
+		Backends: []Backend{{Address: "server1:8080"}},
+	}
+	err = c.Validate()
+	for _, problem := range []string{"must start with /", "duplicate prefix", "unknown policy \"random\""} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Problem %q is not reported in %v", problem, err)
+		}
+	}
+}
+
+func TestAffinity_Validate(t *testing.T) {
+	a := Affinity{Mode: AffinityHeader, Header: "x-user-id"}
+	if err := a.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Header != "X-User-Id" || a.Cookie != DefaultAffinityCookie || time.Duration(a.TTL) != DefaultAffinityTTL {
+		t.Errorf("Unexpected defaults %+v", a)
+	}
+	for _, a := range []Affinity{{Mode: AffinityHeader}, {Mode: "session"}, {Mode: AffinityIP, TTL: -1}} {
+		if err := a.validate(); err == nil {
+			t.Errorf("%+v is accepted", a)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Package pool describes the backends served by cmd/lb. The same
+// configuration is read by cmd/stats to collect the reports of the servers,
+// so both commands always agree on the pool.
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// DefaultVirtualNodes is the number of points a backend of weight 1 gets on
+// the hash ring of cmd/lb.
+const DefaultVirtualNodes = 100
+
+// maxVirtualNodes and maxWeight limit the size of the ring.
+const (
+	maxVirtualNodes = 10000
+	maxWeight       = 1000
+)
+
+// Defaults of the health checks.
+const (
+	DefaultHealthPath     = "/health"
+	DefaultHealthInterval = 10 * time.Second
+	DefaultHealthTimeout  = 3 * time.Second
+	DefaultHealthStatus   = 200
+	DefaultHealthRise     = 2
+	DefaultHealthFall     = 3
+	DefaultHealthJitter   = 0.1
+)
+
+// Backend is a server of the pool.
+type Backend struct {
+	// Address is the host:port of the server.
+	Address string `json:"address" yaml:"address"`
+	// Weight is the capacity of the server relative to the others, 1 if
+	// not set.
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
+	// Scheme is http or https, the scheme of the pool if not set.
+	Scheme string   `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	Tags   []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// URL returns the address of the path on the backend.
+func (b Backend) URL(path string) string {
+	return b.Scheme + "://" + b.Address + path
+}
+
+// Duration is a time.Duration written as a string like "1m30s" in the
+// configuration file.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// HealthCheck configures the probes cmd/lb sends to the backends. The first
+// probe of a backend decides its state, after that it takes Rise passed or
+// Fall failed probes in a row to change it.
+type HealthCheck struct {
+	// Path is requested with GET, /health by default.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Interval between the probes of a backend, 10s by default.
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Timeout of a probe, 3s by default.
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Status is the response status of a passed probe, 200 by default.
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+	// Rise and Fall are the numbers of probes in a row that make an
+	// unhealthy backend healthy and the other way round, 2 and 3 by
+	// default.
+	Rise int `json:"rise,omitempty" yaml:"rise,omitempty"`
+	Fall int `json:"fall,omitempty" yaml:"fall,omitempty"`
+	// Jitter randomly changes every interval by up to this fraction, so the
+	// probes of the backends and of several balancers do not come at once;
+	// 0.1 if not set, 0 disables it.
+	Jitter *float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+}
+
+// Equal reports whether the health checks have the same settings.
+func (h HealthCheck) Equal(other HealthCheck) bool {
+	if (h.Jitter == nil) != (other.Jitter == nil) || h.Jitter != nil && *h.Jitter != *other.Jitter {
+		return false
+	}
+	h.Jitter, other.Jitter = nil, nil
+	return h == other
+}
+
+func (h *HealthCheck) validate() error {
+	if h.Path == "" {
+		h.Path = DefaultHealthPath
+	}
+	if h.Interval == 0 {
+		h.Interval = Duration(DefaultHealthInterval)
+	}
+	if h.Timeout == 0 {
+		h.Timeout = Duration(DefaultHealthTimeout)
+	}
+	if h.Status == 0 {
+		h.Status = DefaultHealthStatus
+	}
+	if h.Rise == 0 {
+		h.Rise = DefaultHealthRise
+	}
+	if h.Fall == 0 {
+		h.Fall = DefaultHealthFall
+	}
+	if h.Jitter == nil {
+		jitter := DefaultHealthJitter
+		h.Jitter = &jitter
+	}
+	var errs []error
+	if !strings.HasPrefix(h.Path, "/") {
+		errs = append(errs, fmt.Errorf("health check path %q must start with /", h.Path))
+	}
+	if h.Interval < 0 || h.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("health check interval and timeout must be positive, got %s and %s",
+			time.Duration(h.Interval), time.Duration(h.Timeout)))
+	}
+	if h.Status < 100 || h.Status > 599 {
+		errs = append(errs, fmt.Errorf("invalid health check status %d", h.Status))
+	}
+	if h.Rise < 0 || h.Fall < 0 {
+		errs = append(errs, fmt.Errorf("health check rise and fall must be positive, got %d and %d", h.Rise, h.Fall))
+	}
+	if *h.Jitter < 0 || *h.Jitter >= 1 {
+		errs = append(errs, fmt.Errorf("health check jitter must be less than 1, got %g", *h.Jitter))
+	}
+	return errors.Join(errs...)
+}
+
+// Config is the content of the configuration file, e.g.
+//
+//	scheme: http
+//	policy: hash:ip
+//	routes:
+//	  - prefix: /api/
+//	    policy: least-outstanding
+//	affinity:
+//	  mode: cookie
+//	  ttl: 30m
+//	virtualNodes: 100
+//	healthCheck:
+//	  path: /health
+//	  interval: 10s
+//	  timeout: 3s
+//	  rise: 2
+//	  fall: 3
+//	outlierDetection:
+//	  consecutiveErrors: 5
+//	  maxEjectedFraction: 0.5
+//	circuitBreaker:
+//	  failures: 5
+//	  openTime: 30s
+//	  slowRequest: 2s
+//	retry:
+//	  attempts: 3
+//	  statuses: [502, 503, 504]
+//	backends:
+//	  - address: server1:8080
+//	    weight: 2
+//	    tags: [eu]
+//	  - address: server2:8080
+type Config struct {
+	// Scheme is the default scheme of the backends, http if not set.
+	Scheme string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	// Policy of choosing a backend for the requests not matched by the
+	// routes, see ParsePolicy. The client IP is hashed by default.
+	Policy string `json:"policy,omitempty" yaml:"policy,omitempty"`
+	// Routes set the policies of path prefixes, the longest prefix wins.
+	Routes   []Route  `json:"routes,omitempty" yaml:"routes,omitempty"`
+	Affinity Affinity `json:"affinity" yaml:"affinity"`
+	// VirtualNodes is the number of points on the hash ring per unit of
+	// the weight of a backend, 100 by default.
+	VirtualNodes int         `json:"virtualNodes,omitempty" yaml:"virtualNodes,omitempty"`
+	HealthCheck  HealthCheck `json:"healthCheck" yaml:"healthCheck"`
+	// OutlierDetection ejects the backends failing the proxied requests.
+	OutlierDetection OutlierDetection `json:"outlierDetection" yaml:"outlierDetection"`
+	// CircuitBreaker stops the requests to the backends failing them.
+	CircuitBreaker CircuitBreaker `json:"circuitBreaker" yaml:"circuitBreaker"`
+	// Retry sends the failed requests to other backends.
+	Retry    Retry     `json:"retry" yaml:"retry"`
+	Backends []Backend `json:"backends" yaml:"backends"`
+}
+
+// Load reads the configuration from a YAML or JSON file, the format is
+// chosen by the extension. Unknown fields are rejected, so a typo does not
+// silently leave a setting at its default.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(&c)
+	case ".yaml", ".yml":
+		d := yaml.NewDecoder(bytes.NewReader(data))
+		d.KnownFields(true)
+		err = d.Decode(&c)
+	default:
+		return nil, fmt.Errorf("config %s: unsupported format %q, expected .yaml, .yml or .json", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// ParseBackends parses a comma separated list of backends given as
+// [scheme://]host:port[?weight=N&tag=T...], for example
+// "server1:8080,https://server2:8443?weight=2&tag=eu&tag=canary".
+func ParseBackends(s string) ([]Backend, error) {
+	var backends []Backend
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var b Backend
+		if i := strings.Index(item, "://"); i >= 0 {
+			b.Scheme, item = item[:i], item[i+3:]
+		}
+		address, query, _ := strings.Cut(item, "?")
+		b.Address = address
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %w", address, err)
+		}
+		for name, values := range params {
+			switch name {
+			case "weight":
+				if b.Weight, err = strconv.Atoi(values[len(values)-1]); err != nil {
+					return nil, fmt.Errorf("backend %q: invalid weight %q", address, values[len(values)-1])
+				}
+			case "tag":
+				b.Tags = values
+			default:
+				return nil, fmt.Errorf("backend %q: unknown parameter %q", address, name)
+			}
+		}
+		backends = append(backends, b)
+	}
+	return backends, nil
+}
+
+// Validate fills in the defaults and checks the configuration. All the
+// problems found are reported at once.
+func (c *Config) Validate() error {
+	if c.Scheme == "" {
+		c.Scheme = SchemeHTTP
+	}
+	if c.Policy == "" {
+		c.Policy = PolicyHash
+	}
+	if c.VirtualNodes == 0 {
+		c.VirtualNodes = DefaultVirtualNodes
+	}
+	var errs []error
+	if !validScheme(c.Scheme) {
+		errs = append(errs, fmt.Errorf("invalid scheme %q, expected http or https", c.Scheme))
+	}
+	if _, err := ParsePolicy(c.Policy); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Affinity.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	prefixes := make(map[string]bool)
+	for i, r := range c.Routes {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("route %d: prefix %q must start with /", i, r.Prefix))
+		}
+		if prefixes[r.Prefix] {
+			errs = append(errs, fmt.Errorf("route %d: duplicate prefix %s", i, r.Prefix))
+		}
+		prefixes[r.Prefix] = true
+		if _, err := ParsePolicy(r.Policy); err != nil {
+			errs = append(errs, fmt.Errorf("route %d (%s): %w", i, r.Prefix, err))
+		}
+	}
+	if c.VirtualNodes < 0 || c.VirtualNodes > maxVirtualNodes {
+		errs = append(errs, fmt.Errorf("virtual nodes must be between 1 and %d, got %d", maxVirtualNodes, c.VirtualNodes))
+	}
+	if err := c.HealthCheck.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.OutlierDetection.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.CircuitBreaker.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Retry.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.Backends) == 0 {
+		errs = append(errs, errors.New("no backends configured"))
+	}
+	seen := make(map[string]bool)
+	for i := range c.Backends {
+		b := &c.Backends[i]
+		if err := b.Validate(c.Scheme); err != nil {
+			errs = append(errs, fmt.Errorf("backend %d (%s): %w", i, b.Address, err))
+		}
+		if seen[b.Address] {
+			errs = append(errs, fmt.Errorf("backend %d: duplicate address %s", i, b.Address))
+		}
+		seen[b.Address] = true
+	}
+	return errors.Join(errs...)
+}
+
+// Validate fills in the defaults of the backend, taking the scheme of the
+// pool if it has none, and checks it.
+func (b *Backend) Validate(scheme string) error {
+	if b.Scheme == "" {
+		b.Scheme = scheme
+	}
+	if b.Weight == 0 {
+		b.Weight = 1
+	}
+	host, port, err := net.SplitHostPort(b.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address, expected host:port: %w", err)
+	}
+	if n, err := strconv.Atoi(port); host == "" || err != nil || n < 1 || n > 65535 {
+		return errors.New("invalid address, expected host:port")
+	}
+	if b.Weight < 0 || b.Weight > maxWeight {
+		return fmt.Errorf("weight must be between 1 and %d, got %d", maxWeight, b.Weight)
+	}
+	if !validScheme(b.Scheme) {
+		return fmt.Errorf("invalid scheme %q, expected http or https", b.Scheme)
+	}
+	for _, tag := range b.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("empty tag")
+		}
+	}
+	return nil
+}
+
+func validScheme(s string) bool {
+	return s == SchemeHTTP || s == SchemeHTTPS
+}
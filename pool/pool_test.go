@@ -0,0 +1,224 @@
+package pool
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBackends(t *testing.T) {
+	backends, err := ParseBackends(" server1:8080, https://server2:8443?weight=2&tag=eu&tag=canary,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Backend{
+		{Address: "server1:8080"},
+		{Address: "server2:8443", Weight: 2, Scheme: "https", Tags: []string{"eu", "canary"}},
+	}
+	if !reflect.DeepEqual(backends, expected) {
+		t.Errorf("Unexpected backends %+v", backends)
+	}
+	for _, list := range []string{"server1:8080?weight=x", "server1:8080?zone=eu"} {
+		if _, err := ParseBackends(list); err == nil {
+			t.Errorf("%q is accepted", list)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pool.yaml": "scheme: https\nbackends:\n  - address: server1:8080\n    weight: 3\n    tags: [eu]\n  - address: server2:8080\n    scheme: http\n",
+		"pool.json": `{"scheme": "https", "backends": [{"address": "server1:8080", "weight": 3, "tags": ["eu"]}, {"address": "server2:8080", "scheme": "http"}]}`,
+	}
+	expected := []Backend{
+		{Address: "server1:8080", Weight: 3, Scheme: "https", Tags: []string{"eu"}},
+		{Address: "server2:8080", Weight: 1, Scheme: "http"},
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		c, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.Backends, expected) {
+			t.Errorf("%s: unexpected backends %+v", name, c.Backends)
+		}
+	}
+
+	path := filepath.Join(dir, "typo.yaml")
+	if err := os.WriteFile(path, []byte("backend:\n  - address: server1:8080\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Unknown field is accepted")
+	}
+	if _, err := Load(filepath.Join(dir, "pool.toml")); err == nil {
+		t.Error("Missing file is accepted")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	c := Config{Scheme: "ftp", Backends: []Backend{
+		{Address: "server1"},
+		{Address: "server2:8080", Weight: -1},
+		{Address: "server3:8080", Scheme: "tcp"},
+		{Address: "server4:8080", Scheme: "http", Tags: []string{""}},
+		{Address: "server4:8080"},
+		{Address: ":8080"},
+	}}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Invalid config is accepted")
+	}
+	for _, problem := range []string{"scheme \"ftp\"", "server1", "server2:8080", "server3:8080", "empty tag", "duplicate", "backend 5"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Problem %q is not reported in %q", problem, err)
+		}
+	}
+	if err := (&Config{}).Validate(); err == nil {
+		t.Error("Empty pool is accepted")
+	}
+	c = Config{VirtualNodes: -1, Backends: []Backend{{Address: "server1:8080", Weight: 100000}}}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "virtual nodes") || !strings.Contains(err.Error(), "weight") {
+		t.Errorf("Size of the ring is not limited: %v", err)
+	}
+}
+
+func TestConfig_HealthCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.json")
+	content := `{"policy": "hash:ip", "healthCheck": {"interval": "1m30s"}, "backends": [{"address": "server1:8080"}]}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	expected := HealthCheck{
+		Path:     DefaultHealthPath,
+		Interval: Duration(90 * time.Second),
+		Timeout:  Duration(DefaultHealthTimeout),
+		Status:   DefaultHealthStatus,
+		Rise:     DefaultHealthRise,
+		Fall:     DefaultHealthFall,
+		Jitter:   jitter(DefaultHealthJitter),
+	}
+	if !c.HealthCheck.Equal(expected) {
+		t.Errorf("Unexpected health check %+v", c.HealthCheck)
+	}
+
+	// Нульовий jitter вимикає розкид, а не замінюється типовим.
+	disabled := &Config{HealthCheck: HealthCheck{Jitter: jitter(0)}, Backends: c.Backends}
+	if err := disabled.Validate(); err != nil || *disabled.HealthCheck.Jitter != 0 {
+		t.Errorf("Jitter 0 is replaced with %g: %v", *disabled.HealthCheck.Jitter, err)
+	}
+
+	for _, check := range []HealthCheck{{Status: 42}, {Rise: -1}, {Jitter: jitter(1)}, {Timeout: -1}} {
+		c := &Config{HealthCheck: check, Backends: c.Backends}
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v is accepted", check)
+		}
+	}
+
+	c = &Config{Policy: "random", HealthCheck: HealthCheck{Path: "health"}, Backends: c.Backends}
+	err = c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "random") || !strings.Contains(err.Error(), "health check path") {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.json")
+	if err := os.WriteFile(path, []byte(`{"backends": [{"address": "file:8080"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	load := func(args ...string) []string {
+		t.Helper()
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags := RegisterFlags(fs, "default:8080")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		c, err := flags.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var urls []string
+		for _, b := range c.Backends {
+			urls = append(urls, b.URL(""))
+		}
+		return urls
+	}
+
+	if urls := load(); !reflect.DeepEqual(urls, []string{"http://default:8080"}) {
+		t.Errorf("Expected the defaults, got %v", urls)
+	}
+	if urls := load("--config", path, "--https"); !reflect.DeepEqual(urls, []string{"https://file:8080"}) {
+		t.Errorf("Expected the backends of the file, got %v", urls)
+	}
+	if urls := load("--config", path, "--backends", "flag:8080"); !reflect.DeepEqual(urls, []string{"http://flag:8080"}) {
+		t.Errorf("Expected --backends to override the file, got %v", urls)
+	}
+	httpsPath := filepath.Join(t.TempDir(), "https.json")
+	if err := os.WriteFile(httpsPath, []byte(`{"scheme": "https", "backends": [{"address": "file:8080"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if urls := load("--config", httpsPath); !reflect.DeepEqual(urls, []string{"https://file:8080"}) {
+		t.Errorf("Expected the scheme of the file, got %v", urls)
+	}
+	if urls := load("--config", httpsPath, "--https=false"); !reflect.DeepEqual(urls, []string{"http://file:8080"}) {
+		t.Errorf("Expected --https=false to override the file, got %v", urls)
+	}
+
+	t.Setenv(EnvConfig, path)
+	if urls := load(); !reflect.DeepEqual(urls, []string{"http://file:8080"}) {
+		t.Errorf("Expected the file of %s, got %v", EnvConfig, urls)
+	}
+	t.Setenv(EnvBackends, "env:8080")
+	if urls := load(); !reflect.DeepEqual(urls, []string{"http://env:8080"}) {
+		t.Errorf("Expected the backends of %s, got %v", EnvBackends, urls)
+	}
+	if urls := load("--backends", "flag:8080"); !reflect.DeepEqual(urls, []string{"http://flag:8080"}) {
+		t.Errorf("Expected the flag to override %s, got %v", EnvBackends, urls)
+	}
+}
+
+func TestFlags_Policy(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs, "default:8080").WithPolicy()
+	if err := fs.Parse([]string{"--policy", "p2c"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvRoutes, "/api/=least-outstanding")
+	c, err := flags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Policy != PolicyP2C || !reflect.DeepEqual(c.Routes, []Route{{"/api/", PolicyLeastOutstanding}}) {
+		t.Errorf("Unexpected policies %q %+v", c.Policy, c.Routes)
+	}
+
+	t.Setenv(EnvPolicy, "random")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := RegisterFlags(fs, "default:8080").WithPolicy().Load(); err == nil {
+		t.Error("Unknown policy is accepted")
+	}
+}
+
+func jitter(value float64) *float64 {
+	return &value
+}
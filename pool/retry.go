@@ -0,0 +1,74 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Defaults of the retries.
+const (
+	DefaultRetryAttempts       = 3
+	DefaultRetryBudget         = 0.2
+	DefaultMinRetriesPerSecond = 10
+	DefaultRetryMaxBody        = 1 << 20
+)
+
+// DefaultRetryStatuses are retried besides the connection errors.
+var DefaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// Retry configures how cmd/lb sends a failed request to another backend. A
+// request is retried if the backend could not be connected, which is safe
+// for any method, or if it failed after that with a connection error or one
+// of the Statuses and the request is idempotent. Its body must fit in
+// MaxBody to be replayed.
+type Retry struct {
+	// Attempts is the maximum number of backends tried for a request, 3 by
+	// default; 1 disables the retries.
+	Attempts int `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+	// Statuses of the responses retried, 502, 503 and 504 if not set.
+	Statuses []int `json:"statuses" yaml:"statuses"`
+	// Budget is the share of the requests that can be retried, so that an
+	// outage of the pool does not multiply the load, 0.2 by default.
+	Budget float64 `json:"budget,omitempty" yaml:"budget,omitempty"`
+	// MinRetriesPerSecond are allowed over the budget when there is little
+	// traffic, 10 by default.
+	MinRetriesPerSecond int `json:"minRetriesPerSecond,omitempty" yaml:"minRetriesPerSecond,omitempty"`
+	// MaxBody is the size of the largest request body buffered to be
+	// replayed, 1 MiB by default.
+	MaxBody int64 `json:"maxBody,omitempty" yaml:"maxBody,omitempty"`
+}
+
+func (r *Retry) validate() error {
+	if r.Attempts == 0 {
+		r.Attempts = DefaultRetryAttempts
+	}
+	if r.Statuses == nil {
+		r.Statuses = DefaultRetryStatuses
+	}
+	if r.Budget == 0 {
+		r.Budget = DefaultRetryBudget
+	}
+	if r.MinRetriesPerSecond == 0 {
+		r.MinRetriesPerSecond = DefaultMinRetriesPerSecond
+	}
+	if r.MaxBody == 0 {
+		r.MaxBody = DefaultRetryMaxBody
+	}
+	var errs []error
+	if r.Attempts < 1 {
+		errs = append(errs, fmt.Errorf("retry attempts must be positive, got %d", r.Attempts))
+	}
+	for _, status := range r.Statuses {
+		if status < 100 || status > 599 {
+			errs = append(errs, fmt.Errorf("invalid retry status %d", status))
+		}
+	}
+	if r.Budget < 0 || r.Budget > 1 {
+		errs = append(errs, fmt.Errorf("retry budget must be between 0 and 1, got %g", r.Budget))
+	}
+	if r.MinRetriesPerSecond < 0 || r.MaxBody < 0 {
+		errs = append(errs, errors.New("minimum retries per second and maximum body size must be positive"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,30 @@
+package pool
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRetry_Validate(t *testing.T) {
+	var r Retry
+	if err := r.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Attempts != DefaultRetryAttempts || !reflect.DeepEqual(r.Statuses, DefaultRetryStatuses) || r.Budget != DefaultRetryBudget || r.MaxBody != DefaultRetryMaxBody {
+		t.Errorf("Unexpected defaults %+v", r)
+	}
+	r = Retry{Statuses: []int{}}
+	if err := r.validate(); err != nil || len(r.Statuses) != 0 {
+		t.Errorf("Empty statuses are replaced with %v: %v", r.Statuses, err)
+	}
+	for _, r := range []Retry{
+		{Attempts: -1},
+		{Statuses: []int{42}},
+		{Budget: 1.5},
+		{MaxBody: -1},
+	} {
+		if err := r.validate(); err == nil {
+			t.Errorf("%+v is accepted", r)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package raft
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// StateMachine is the replicated state. Apply must be deterministic, so
+// every node ends up in the same state after applying the same entries.
+type StateMachine interface {
+	// Apply applies the command of the log entry with the index.
+	Apply(index uint64, command []byte) error
+	// Snapshot writes the whole state to w.
+	Snapshot(w io.Writer) error
+	// Restore replaces the state with a snapshot; an empty one resets it.
+	Restore(r io.Reader) error
+}
+
+// Command is a write to a datastore.Db as it is stored in the log. A
+// command of the "tombstone" type deletes the key.
+type Command struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// IfVersion makes the write conditional, see datastore.WriteOptions.
+	IfVersion uint64 `json:"ifVersion,omitempty"`
+}
+
+// DbStateMachine applies Commands to a datastore.Db. The index of the
+// entry becomes the version of the written record, so the versions are the
+// same on every node. Snapshots use the JSON Lines export format, which
+// keeps the versions.
+type DbStateMachine struct {
+	db *datastore.Db
+}
+
+func NewDbStateMachine(db *datastore.Db) *DbStateMachine {
+	return &DbStateMachine{db: db}
+}
+
+func (m *DbStateMachine) Apply(index uint64, command []byte) error {
+	var c Command
+	if err := json.Unmarshal(command, &c); err != nil {
+		return err
+	}
+	_, err := m.db.PutValueWith(c.Key, c.Type, c.Value, datastore.WriteOptions{IfVersion: c.IfVersion, Version: index})
+	return err
+}
+
+func (m *DbStateMachine) Snapshot(w io.Writer) error {
+	_, err := m.db.Snapshot(w, datastore.FormatJSONL)
+	return err
+}
+
+func (m *DbStateMachine) Restore(r io.Reader) error {
+	if err := m.db.Reset(); err != nil {
+		return err
+	}
+	_, err := m.db.Import(r, datastore.FormatJSONL)
+	return err
+}
@@ -0,0 +1,915 @@
+// Package raft implements the Raft consensus algorithm, used to replicate
+// writes to a datastore.Db across a cluster of nodes. It supports leader
+// election, log compaction with snapshots of the state machine and
+// single-server membership changes.
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader is returned for proposals sent to a node that is not the
+	// leader; use Leader to find where to send them.
+	ErrNotLeader = errors.New("not the leader")
+	// ErrLeadershipLost is returned when the proposed entry was replaced by
+	// a new leader before it got committed.
+	ErrLeadershipLost = errors.New("leadership lost before the entry was committed")
+	// ErrStopped is returned once the node is stopped.
+	ErrStopped = errors.New("raft node is stopped")
+	// ErrConfigChangeInProgress is returned when a membership change is
+	// proposed before the previous one is committed.
+	ErrConfigChangeInProgress = errors.New("another membership change is in progress")
+)
+
+// Role of a node in the current term.
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
+
+// Peer is a member of the cluster. The address is only interpreted by the
+// transport.
+type Peer struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// EntryType distinguishes commands for the state machine from the entries
+// used by the algorithm itself.
+type EntryType uint8
+
+const (
+	EntryCommand EntryType = iota
+	// EntryNoop is appended by every new leader and by Barrier.
+	EntryNoop
+	// EntryConfig carries the new list of members encoded in JSON.
+	EntryConfig
+)
+
+// Entry is a record of the replicated log.
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type,omitempty"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+// Options configures a node created with NewNode.
+type Options struct {
+	// HeartbeatInterval is how often the leader replicates the log when
+	// there is nothing new to send.
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is the minimal time without a heartbeat after which
+	// a follower starts an election. The actual timeout is randomized
+	// between ElectionTimeout and twice as much.
+	ElectionTimeout time.Duration
+	// RequestTimeout limits a single request to another node.
+	RequestTimeout time.Duration
+	// SnapshotThreshold is the number of applied entries after which the
+	// state machine is snapshotted and the log is compacted (0 disables).
+	SnapshotThreshold uint64
+	// MaxEntries is the maximal number of entries sent in one request.
+	MaxEntries int
+}
+
+// DefaultOptions returns options suitable for nodes in a local network.
+func DefaultOptions() Options {
+	return Options{
+		HeartbeatInterval: 50 * time.Millisecond,
+		ElectionTimeout:   500 * time.Millisecond,
+		RequestTimeout:    2 * time.Second,
+		SnapshotThreshold: 1024,
+		MaxEntries:        256,
+	}
+}
+
+func (o Options) validate() error {
+	if o.HeartbeatInterval <= 0 || o.ElectionTimeout <= 0 || o.RequestTimeout <= 0 {
+		return fmt.Errorf("timeouts must be positive")
+	}
+	if o.ElectionTimeout < 2*o.HeartbeatInterval {
+		return fmt.Errorf("election timeout %s is too short for heartbeats every %s", o.ElectionTimeout, o.HeartbeatInterval)
+	}
+	if o.MaxEntries <= 0 {
+		return fmt.Errorf("max entries must be positive, got %d", o.MaxEntries)
+	}
+	return nil
+}
+
+// Node is a member of a Raft cluster. It replicates the entries proposed
+// to the leader and applies the committed ones to the state machine.
+type Node struct {
+	id        string
+	options   Options
+	fsm       StateMachine
+	storage   Storage
+	transport Transport
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	// applyMu serializes changes of the state machine: applying entries,
+	// taking snapshots and installing the ones received from the leader.
+	// It is always taken before mu.
+	applyMu sync.Mutex
+
+	mu sync.Mutex
+	// committed is signalled when commitIndex grows or the node stops.
+	committed *sync.Cond
+	stopped   bool
+	role      Role
+	term      uint64
+	vote      string
+	leader    string
+	// log[0] stands for the last entry included in the snapshot.
+	log             []Entry
+	members         []Peer
+	configIndex     uint64
+	snapshotMembers []Peer
+	commitIndex     uint64
+	lastApplied     uint64
+	deadline        time.Time
+	heardFromLeader time.Time
+	votes           map[string]bool
+	nextIndex       map[string]uint64
+	matchIndex      map[string]uint64
+	lastContact     map[string]time.Time
+	inflight        map[string]bool
+	waiters         map[uint64]*waiter
+}
+
+type waiter struct {
+	term uint64
+	done chan error
+}
+
+// NewNode starts a node with the given id. peers is the initial membership
+// of a new cluster including the node itself; it is ignored if the storage
+// already has a state. A node that joins an existing cluster is started
+// without peers and added with AddMember on the leader.
+//
+// The state machine is restored from the latest snapshot, or reset if there
+// is none, and the rest of the log is applied again once the node learns
+// which entries are committed.
+func NewNode(id string, peers []Peer, fsm StateMachine, storage Storage, transport Transport, options Options) (*Node, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	state, entries, meta, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		id:              id,
+		options:         options,
+		fsm:             fsm,
+		storage:         storage,
+		transport:       transport,
+		term:            state.Term,
+		vote:            state.Vote,
+		log:             []Entry{{Index: meta.Index, Term: meta.Term}},
+		snapshotMembers: meta.Members,
+		commitIndex:     meta.Index,
+		lastApplied:     meta.Index,
+		nextIndex:       make(map[string]uint64),
+		matchIndex:      make(map[string]uint64),
+		lastContact:     make(map[string]time.Time),
+		inflight:        make(map[string]bool),
+		waiters:         make(map[uint64]*waiter),
+	}
+	n.committed = sync.NewCond(&n.mu)
+	for _, e := range entries {
+		// Журнал міг не встигнути стиснутись після збереження знімка.
+		if e.Index > meta.Index {
+			n.log = append(n.log, e)
+		}
+	}
+	if meta.Index == 0 && len(n.log) == 1 && len(peers) > 0 {
+		// Всі вузли нового кластера починають з однакового запису конфігурації.
+		data, err := json.Marshal(peers)
+		if err != nil {
+			return nil, err
+		}
+		bootstrap := Entry{Index: 1, Type: EntryConfig, Data: data}
+		if err := storage.Append([]Entry{bootstrap}); err != nil {
+			return nil, err
+		}
+		n.log = append(n.log, bootstrap)
+	}
+	n.members, n.configIndex = n.configAt(n.lastIndex())
+
+	var snapshot []byte
+	if meta.Index > 0 {
+		_, snapshot, err = storage.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := fsm.Restore(bytes.NewReader(snapshot)); err != nil {
+		return nil, fmt.Errorf("failed to restore the state machine: %w", err)
+	}
+
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	n.resetElectionTimer()
+	n.wg.Add(2)
+	go n.run()
+	go n.applyCommitted()
+	return n, nil
+}
+
+// Stop stops the node. Pending proposals fail with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	n.role = Follower
+	for index, w := range n.waiters {
+		w.done <- ErrStopped
+		delete(n.waiters, index)
+	}
+	n.committed.Broadcast()
+	n.mu.Unlock()
+
+	n.cancel()
+	n.wg.Wait()
+}
+
+// ID returns the id of the node.
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Status describes the state of a node.
+type Status struct {
+	ID            string `json:"id"`
+	Role          string `json:"role"`
+	Term          uint64 `json:"term"`
+	Leader        string `json:"leader,omitempty"`
+	CommitIndex   uint64 `json:"commitIndex"`
+	LastApplied   uint64 `json:"lastApplied"`
+	LastIndex     uint64 `json:"lastIndex"`
+	SnapshotIndex uint64 `json:"snapshotIndex"`
+	Members       []Peer `json:"members"`
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:            n.id,
+		Role:          n.role.String(),
+		Term:          n.term,
+		Leader:        n.leader,
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.log[0].Index,
+		Members:       append([]Peer(nil), n.members...),
+	}
+}
+
+// IsLeader reports whether the node believes it is the leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == Leader
+}
+
+// Leader returns the current leader known to the node, if any.
+func (n *Node) Leader() (Peer, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.leader == "" {
+		return Peer{}, false
+	}
+	for _, p := range n.members {
+		if p.ID == n.leader {
+			return p, true
+		}
+	}
+	return Peer{ID: n.leader}, true
+}
+
+// Members returns the latest membership known to the node.
+func (n *Node) Members() []Peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Peer(nil), n.members...)
+}
+
+// Propose replicates a command and returns after it has been committed and
+// applied to the state machine of the leader, with the index of the entry
+// and the error returned by StateMachine.Apply.
+func (n *Node) Propose(ctx context.Context, command []byte) (uint64, error) {
+	return n.propose(ctx, func() (Entry, error) {
+		return Entry{Type: EntryCommand, Data: command}, nil
+	})
+}
+
+// Barrier returns once all the entries committed before the call are
+// applied to the state machine of the leader, so reads that follow it
+// observe every completed write.
+func (n *Node) Barrier(ctx context.Context) error {
+	_, err := n.propose(ctx, func() (Entry, error) {
+		return Entry{Type: EntryNoop}, nil
+	})
+	return err
+}
+
+// AddMember adds a node to the cluster, or changes its address. The new
+// node should already be running; it receives the log or a snapshot from
+// the leader.
+func (n *Node) AddMember(ctx context.Context, peer Peer) error {
+	return n.changeMembers(ctx, func(members []Peer) []Peer {
+		for i, p := range members {
+			if p.ID == peer.ID {
+				members[i] = peer
+				return members
+			}
+		}
+		return append(members, peer)
+	})
+}
+
+// RemoveMember removes a node from the cluster. A leader that removes itself
+// steps down once the change is committed.
+func (n *Node) RemoveMember(ctx context.Context, id string) error {
+	return n.changeMembers(ctx, func(members []Peer) []Peer {
+		for i, p := range members {
+			if p.ID == id {
+				return append(members[:i], members[i+1:]...)
+			}
+		}
+		return members
+	})
+}
+
+// changeMembers proposes a configuration entry. Only one server is added or
+// removed at a time, so the majorities of the old and the new configuration
+// always overlap.
+func (n *Node) changeMembers(ctx context.Context, change func([]Peer) []Peer) error {
+	_, err := n.propose(ctx, func() (Entry, error) {
+		if n.configIndex > n.commitIndex {
+			return Entry{}, ErrConfigChangeInProgress
+		}
+		members := change(append([]Peer(nil), n.members...))
+		if len(members) == 0 {
+			return Entry{}, fmt.Errorf("can't remove the last member")
+		}
+		data, err := json.Marshal(members)
+		return Entry{Type: EntryConfig, Data: data}, err
+	})
+	return err
+}
+
+// propose appends the entry built by newEntry to the log of the leader and
+// waits until it is applied. newEntry is called with n.mu held.
+func (n *Node) propose(ctx context.Context, newEntry func() (Entry, error)) (uint64, error) {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return 0, ErrStopped
+	}
+	if n.role != Leader {
+		n.mu.Unlock()
+		return 0, ErrNotLeader
+	}
+	e, err := newEntry()
+	if err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+	index := n.appendEntries(e)
+	w := &waiter{term: n.term, done: make(chan error, 1)}
+	n.waiters[index] = w
+	n.advanceCommit()
+	n.broadcast()
+	n.mu.Unlock()
+
+	select {
+	case err := <-w.done:
+		return index, err
+	case <-ctx.Done():
+		n.mu.Lock()
+		if n.waiters[index] == w {
+			delete(n.waiters, index)
+		}
+		n.mu.Unlock()
+		return index, ctx.Err()
+	}
+}
+
+// run drives elections and heartbeats.
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.options.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.tick()
+		}
+	}
+}
+
+func (n *Node) tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return
+	}
+	now := time.Now()
+	switch {
+	case n.role == Leader:
+		// Лідер, відрізаний від більшості, поступається, щоб клієнти не чекали на нього марно.
+		recent := func(p Peer) bool {
+			return p.ID == n.id || now.Sub(n.lastContact[p.ID]) < n.options.ElectionTimeout
+		}
+		if !n.hasQuorum(recent) {
+			log.Printf("raft %s: lost contact with the majority in term %d", n.id, n.term)
+			n.becomeFollower(n.term, "")
+			return
+		}
+		n.broadcast()
+	case now.After(n.deadline) && n.isMember(n.id):
+		n.campaign()
+	}
+}
+
+func (n *Node) resetElectionTimer() {
+	timeout := n.options.ElectionTimeout + time.Duration(rand.Int63n(int64(n.options.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+func (n *Node) becomeFollower(term uint64, leader string) {
+	if term > n.term {
+		n.term, n.vote = term, ""
+		n.saveState()
+	}
+	n.role = Follower
+	n.leader = leader
+	n.resetElectionTimer()
+}
+
+func (n *Node) campaign() {
+	n.role = Candidate
+	n.term++
+	n.vote = n.id
+	n.leader = ""
+	n.saveState()
+	n.resetElectionTimer()
+	n.votes = map[string]bool{n.id: true}
+	log.Printf("raft %s: starting an election in term %d", n.id, n.term)
+	if n.hasQuorum(func(p Peer) bool { return n.votes[p.ID] }) {
+		n.becomeLeader()
+		return
+	}
+
+	req := &VoteRequest{
+		Term:      n.term,
+		Candidate: n.id,
+		LastIndex: n.lastIndex(),
+		LastTerm:  n.termAt(n.lastIndex()),
+	}
+	for _, p := range n.members {
+		if p.ID != n.id {
+			n.spawn(func() { n.requestVote(p, req) })
+		}
+	}
+}
+
+func (n *Node) requestVote(p Peer, req *VoteRequest) {
+	ctx, cancel := context.WithTimeout(n.ctx, n.options.RequestTimeout)
+	defer cancel()
+	resp, err := n.transport.RequestVote(ctx, p, req)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return
+	}
+	if n.role != Candidate || n.term != req.Term || !resp.Granted {
+		return
+	}
+	n.votes[p.ID] = true
+	if n.hasQuorum(func(p Peer) bool { return n.votes[p.ID] }) {
+		n.becomeLeader()
+	}
+}
+
+func (n *Node) becomeLeader() {
+	log.Printf("raft %s: became the leader in term %d", n.id, n.term)
+	n.role = Leader
+	n.leader = n.id
+	now := time.Now()
+	for _, p := range n.members {
+		n.nextIndex[p.ID] = n.lastIndex() + 1
+		n.matchIndex[p.ID] = 0
+		n.lastContact[p.ID] = now
+	}
+	// Записи попередніх термів фіксуються лише разом із записом поточного терму.
+	n.appendEntries(Entry{Type: EntryNoop})
+	n.advanceCommit()
+	n.broadcast()
+}
+
+// spawn runs fn in a goroutine the node waits for when it is stopped.
+func (n *Node) spawn(fn func()) {
+	if n.stopped {
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		fn()
+	}()
+}
+
+// broadcast sends the new entries, or a heartbeat, to every follower.
+func (n *Node) broadcast() {
+	for _, p := range n.members {
+		if _, ok := n.nextIndex[p.ID]; !ok {
+			// Новий учасник, доданий після обрання лідера.
+			n.nextIndex[p.ID] = n.lastIndex() + 1
+			n.lastContact[p.ID] = time.Now()
+		}
+		if p.ID != n.id && !n.inflight[p.ID] {
+			n.inflight[p.ID] = true
+			n.spawn(func() { n.replicate(p) })
+		}
+	}
+}
+
+// replicate brings the log of the follower p up to date. Only one
+// replicate call runs per follower at a time.
+func (n *Node) replicate(p Peer) {
+	defer func() {
+		n.mu.Lock()
+		n.inflight[p.ID] = false
+		n.mu.Unlock()
+	}()
+	for {
+		n.mu.Lock()
+		if n.role != Leader || n.stopped || !n.isMember(p.ID) {
+			n.mu.Unlock()
+			return
+		}
+		var more bool
+		if n.nextIndex[p.ID] <= n.log[0].Index {
+			more = n.sendSnapshot(p)
+		} else {
+			more = n.sendEntries(p)
+		}
+		n.mu.Unlock()
+		if !more {
+			return
+		}
+	}
+}
+
+// sendEntries sends the entries starting at the next index of the
+// follower. It is called with n.mu held and releases it for the request.
+// It returns true if there is more to send.
+func (n *Node) sendEntries(p Peer) bool {
+	term := n.term
+	next := n.nextIndex[p.ID]
+	last := n.lastIndex()
+	if last >= next+uint64(n.options.MaxEntries) {
+		last = next + uint64(n.options.MaxEntries) - 1
+	}
+	req := &AppendRequest{
+		Term:      term,
+		Leader:    n.id,
+		PrevIndex: next - 1,
+		PrevTerm:  n.termAt(next - 1),
+		Entries:   append([]Entry(nil), n.slice(next, last+1)...),
+		Commit:    n.commitIndex,
+	}
+
+	n.mu.Unlock()
+	ctx, cancel := context.WithTimeout(n.ctx, n.options.RequestTimeout)
+	resp, err := n.transport.AppendEntries(ctx, p, req)
+	cancel()
+	n.mu.Lock()
+
+	if err != nil {
+		return false
+	}
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+	if n.role != Leader || n.term != term {
+		return false
+	}
+	n.lastContact[p.ID] = time.Now()
+	if !resp.Success {
+		// Відступаємо до кінця журналу фоловера або на один запис, якщо терми не збіглися.
+		next := min(next-1, resp.LastIndex+1)
+		n.nextIndex[p.ID] = max(next, 1)
+		return true
+	}
+	match := req.PrevIndex + uint64(len(req.Entries))
+	if match > n.matchIndex[p.ID] {
+		n.matchIndex[p.ID] = match
+	}
+	n.nextIndex[p.ID] = match + 1
+	n.advanceCommit()
+	return n.nextIndex[p.ID] <= n.lastIndex()
+}
+
+// sendSnapshot sends the latest snapshot to a follower that is too far
+// behind to catch up from the log. It is called with n.mu held and
+// releases it for the request.
+func (n *Node) sendSnapshot(p Peer) bool {
+	term := n.term
+	meta, data, err := n.storage.Snapshot()
+	if err != nil {
+		log.Printf("raft %s: failed to load the snapshot: %s", n.id, err)
+		return false
+	}
+	req := &SnapshotRequest{Term: term, Leader: n.id, Meta: meta, Data: data}
+
+	n.mu.Unlock()
+	log.Printf("raft %s: sending a snapshot at %d to %s", n.id, meta.Index, p.ID)
+	ctx, cancel := context.WithTimeout(n.ctx, n.options.RequestTimeout)
+	resp, err := n.transport.InstallSnapshot(ctx, p, req)
+	cancel()
+	n.mu.Lock()
+
+	if err != nil {
+		return false
+	}
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term, "")
+		return false
+	}
+	if n.role != Leader || n.term != term {
+		return false
+	}
+	n.lastContact[p.ID] = time.Now()
+	if meta.Index > n.matchIndex[p.ID] {
+		n.matchIndex[p.ID] = meta.Index
+	}
+	n.nextIndex[p.ID] = n.matchIndex[p.ID] + 1
+	n.advanceCommit()
+	return n.nextIndex[p.ID] <= n.lastIndex()
+}
+
+// advanceCommit commits the entries of the current term stored on the
+// majority of the members.
+func (n *Node) advanceCommit() {
+	if n.role != Leader {
+		return
+	}
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		replicated := n.hasQuorum(func(p Peer) bool {
+			if p.ID == n.id {
+				return true
+			}
+			return n.matchIndex[p.ID] >= index
+		})
+		if replicated {
+			n.setCommitIndex(index)
+			break
+		}
+	}
+	if n.configIndex <= n.commitIndex && !n.isMember(n.id) {
+		log.Printf("raft %s: removed from the cluster, stepping down", n.id)
+		n.becomeFollower(n.term, "")
+	}
+}
+
+func (n *Node) setCommitIndex(index uint64) {
+	if index > n.commitIndex {
+		n.commitIndex = index
+		n.committed.Broadcast()
+	}
+}
+
+// hasQuorum reports whether the majority of members satisfy the predicate.
+func (n *Node) hasQuorum(ok func(Peer) bool) bool {
+	count := 0
+	for _, p := range n.members {
+		if ok(p) {
+			count++
+		}
+	}
+	return count > len(n.members)/2
+}
+
+func (n *Node) isMember(id string) bool {
+	for _, p := range n.members {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// appendEntries adds entries of the current term to the log of the leader
+// and returns the index of the last one.
+func (n *Node) appendEntries(entries ...Entry) uint64 {
+	for i := range entries {
+		entries[i].Index = n.lastIndex() + 1 + uint64(i)
+		entries[i].Term = n.term
+	}
+	n.storeEntries(entries)
+	return n.lastIndex()
+}
+
+// storeEntries persists entries and adds them to the end of the log.
+func (n *Node) storeEntries(entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := n.storage.Append(entries); err != nil {
+		log.Panicf("raft %s: failed to append to the log: %s", n.id, err)
+	}
+	n.log = append(n.log, entries...)
+	for _, e := range entries {
+		if e.Type == EntryConfig {
+			n.members, n.configIndex = n.configAt(e.Index)
+		}
+	}
+}
+
+// truncate removes the entries starting at index, which are not committed.
+func (n *Node) truncate(index uint64) {
+	n.log = n.log[:index-n.log[0].Index]
+	if err := n.storage.Rewrite(n.log[1:]); err != nil {
+		log.Panicf("raft %s: failed to truncate the log: %s", n.id, err)
+	}
+	if n.configIndex >= index {
+		n.members, n.configIndex = n.configAt(n.lastIndex())
+	}
+}
+
+func (n *Node) saveState() {
+	if err := n.storage.SaveState(HardState{Term: n.term, Vote: n.vote}); err != nil {
+		log.Panicf("raft %s: failed to save the state: %s", n.id, err)
+	}
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+// termAt returns the term of the entry at index, which must not be below
+// the snapshot.
+func (n *Node) termAt(index uint64) uint64 {
+	return n.log[index-n.log[0].Index].Term
+}
+
+// slice returns the entries in [from, to).
+func (n *Node) slice(from, to uint64) []Entry {
+	base := n.log[0].Index
+	return n.log[from-base : to-base]
+}
+
+// configAt returns the membership in effect at index and the index of the
+// entry that set it.
+func (n *Node) configAt(index uint64) ([]Peer, uint64) {
+	for i := index; i > n.log[0].Index; i-- {
+		e := n.log[i-n.log[0].Index]
+		if e.Type != EntryConfig {
+			continue
+		}
+		var members []Peer
+		if err := json.Unmarshal(e.Data, &members); err != nil {
+			log.Panicf("raft %s: corrupted configuration entry %d: %s", n.id, e.Index, err)
+		}
+		return members, e.Index
+	}
+	return n.snapshotMembers, n.log[0].Index
+}
+
+// applyCommitted applies committed entries to the state machine and
+// completes the proposals waiting for them.
+func (n *Node) applyCommitted() {
+	defer n.wg.Done()
+	for {
+		n.mu.Lock()
+		for !n.stopped && n.lastApplied >= n.commitIndex {
+			n.committed.Wait()
+		}
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+		from := n.lastApplied
+		entries := append([]Entry(nil), n.slice(from+1, n.commitIndex+1)...)
+		n.mu.Unlock()
+
+		n.applyMu.Lock()
+		n.mu.Lock()
+		// Поки ми не тримали applyMu, могли встановити знімок від лідера.
+		installed := n.lastApplied != from
+		n.mu.Unlock()
+		if !installed {
+			for _, e := range entries {
+				var err error
+				if e.Type == EntryCommand {
+					err = n.fsm.Apply(e.Index, e.Data)
+				}
+				n.mu.Lock()
+				n.lastApplied = e.Index
+				n.complete(e, err)
+				n.mu.Unlock()
+			}
+			n.maybeSnapshot()
+		}
+		n.applyMu.Unlock()
+	}
+}
+
+// complete passes the result of an applied entry to the proposal waiting
+// for it.
+func (n *Node) complete(e Entry, err error) {
+	w, ok := n.waiters[e.Index]
+	if !ok {
+		return
+	}
+	delete(n.waiters, e.Index)
+	if w.term != e.Term {
+		err = ErrLeadershipLost
+	}
+	w.done <- err
+}
+
+// maybeSnapshot compacts the log once enough entries are applied. It is
+// called with applyMu held, so the state machine matches lastApplied.
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	index := n.lastApplied
+	due := n.options.SnapshotThreshold > 0 && index-n.log[0].Index >= n.options.SnapshotThreshold
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	n.mu.Lock()
+	members, _ := n.configAt(index)
+	meta := SnapshotMeta{Index: index, Term: n.termAt(index), Members: members}
+	n.mu.Unlock()
+
+	// Знімок пишеться у файл одразу, без копії в пам'яті. Застосовані
+	// записи не зміняться, а встановлення знімка чекає на applyMu.
+	if err := n.storage.SaveSnapshot(meta, n.fsm.Snapshot); err != nil {
+		log.Printf("raft %s: failed to save the snapshot: %s", n.id, err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.compact(meta)
+}
+
+// compact drops the entries included in the snapshot.
+func (n *Node) compact(meta SnapshotMeta) {
+	var rest []Entry
+	if meta.Index <= n.lastIndex() && n.termAt(meta.Index) == meta.Term {
+		rest = n.slice(meta.Index+1, n.lastIndex()+1)
+	}
+	n.log = append([]Entry{{Index: meta.Index, Term: meta.Term}}, rest...)
+	n.snapshotMembers = meta.Members
+	if err := n.storage.Rewrite(n.log[1:]); err != nil {
+		log.Panicf("raft %s: failed to compact the log: %s", n.id, err)
+	}
+	n.members, n.configIndex = n.configAt(n.lastIndex())
+}
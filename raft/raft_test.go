@@ -0,0 +1,387 @@
+package raft
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// memoryMachine stores "key=value" commands in a map.
+type memoryMachine struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (m *memoryMachine) Apply(_ uint64, command []byte) error {
+	key, value, ok := strings.Cut(string(command), "=")
+	if !ok {
+		return fmt.Errorf("bad command %q", command)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *memoryMachine) Snapshot(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, value := range m.data {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryMachine) Restore(r io.Reader) error {
+	data := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		data[key] = value
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = data
+	return scanner.Err()
+}
+
+func (m *memoryMachine) get(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.data[key]
+}
+
+var testOptions = Options{
+	HeartbeatInterval: 10 * time.Millisecond,
+	ElectionTimeout:   60 * time.Millisecond,
+	RequestTimeout:    50 * time.Millisecond,
+	SnapshotThreshold: 0,
+	MaxEntries:        16,
+}
+
+type testCluster struct {
+	t        *testing.T
+	network  *MemoryNetwork
+	options  Options
+	nodes    map[string]*Node
+	machines map[string]*memoryMachine
+	storages map[string]*MemoryStorage
+}
+
+func newTestCluster(t *testing.T, size int, options Options) *testCluster {
+	c := &testCluster{
+		t:        t,
+		network:  NewMemoryNetwork(),
+		options:  options,
+		nodes:    make(map[string]*Node),
+		machines: make(map[string]*memoryMachine),
+		storages: make(map[string]*MemoryStorage),
+	}
+	var peers []Peer
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		peers = append(peers, Peer{ID: id, Address: id})
+	}
+	for _, p := range peers {
+		c.start(p.ID, peers)
+	}
+	t.Cleanup(c.stop)
+	return c
+}
+
+func (c *testCluster) start(id string, peers []Peer) *Node {
+	c.t.Helper()
+	if c.storages[id] == nil {
+		c.storages[id] = NewMemoryStorage()
+	}
+	c.machines[id] = &memoryMachine{data: make(map[string]string)}
+	node, err := NewNode(id, peers, c.machines[id], c.storages[id], c.network.Transport(id), c.options)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.network.Register(id, node)
+	c.nodes[id] = node
+	return node
+}
+
+func (c *testCluster) stop() {
+	for _, n := range c.nodes {
+		n.Stop()
+	}
+}
+
+// leader waits until exactly one of the connected nodes is the leader.
+func (c *testCluster) leader(except ...string) *Node {
+	c.t.Helper()
+	skip := make(map[string]bool)
+	for _, id := range except {
+		skip[id] = true
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*Node
+		for id, n := range c.nodes {
+			if !skip[id] && n.IsLeader() {
+				leaders = append(leaders, n)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatal("No leader elected")
+	return nil
+}
+
+func (c *testCluster) put(key, value string, except ...string) {
+	c.t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		_, err := c.leader(except...).Propose(ctx, []byte(key+"="+value))
+		cancel()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("Failed to put %s: %s", key, err)
+		}
+	}
+}
+
+// waitFor waits until the node applies the value.
+func (c *testCluster) waitFor(id, key, value string) {
+	c.t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for c.machines[id].get(key) != value {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("Node %s has %s=%q, expected %q", id, key, c.machines[id].get(key), value)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNode_Election(t *testing.T) {
+	c := newTestCluster(t, 3, testOptions)
+	leader := c.leader()
+	term := leader.Status().Term
+
+	time.Sleep(5 * testOptions.ElectionTimeout)
+	if again := c.leader(); again != leader || again.Status().Term != term {
+		t.Errorf("Leadership changed without failures: %s in term %d", again.ID(), again.Status().Term)
+	}
+	for id, n := range c.nodes {
+		if l, ok := n.Leader(); !ok || l.ID != leader.ID() {
+			t.Errorf("Node %s doesn't know the leader: %v", id, l)
+		}
+	}
+}
+
+func TestNode_Replication(t *testing.T) {
+	c := newTestCluster(t, 3, testOptions)
+	for i := 0; i < 50; i++ {
+		c.put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+	for id := range c.nodes {
+		c.waitFor(id, "key49", "value49")
+		if v := c.machines[id].get("key0"); v != "value0" {
+			t.Errorf("Node %s has key0=%q", id, v)
+		}
+	}
+
+	follower := c.nodes["n1"]
+	if follower.IsLeader() {
+		follower = c.nodes["n2"]
+	}
+	if _, err := follower.Propose(context.Background(), []byte("a=b")); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("Expected ErrNotLeader from a follower, got %v", err)
+	}
+}
+
+func TestNode_LeaderFailure(t *testing.T) {
+	c := newTestCluster(t, 3, testOptions)
+	c.put("key", "first")
+	old := c.leader()
+	oldTerm := old.Status().Term
+	c.network.Disconnect(old.ID())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := old.Propose(ctx, []byte("key=lost")); err == nil {
+		t.Error("A partitioned leader committed an entry")
+	}
+
+	c.put("key", "second", old.ID())
+	leader := c.leader(old.ID())
+	if leader.Status().Term <= oldTerm {
+		t.Errorf("New leader has an old term: %+v", leader.Status())
+	}
+
+	c.network.Connect(old.ID())
+	c.waitFor(old.ID(), "key", "second")
+	if old.IsLeader() && old.Status().Term < leader.Status().Term {
+		t.Error("Old leader didn't step down")
+	}
+	for id := range c.nodes {
+		c.waitFor(id, "key", "second")
+	}
+}
+
+func TestNode_Snapshot(t *testing.T) {
+	options := testOptions
+	options.SnapshotThreshold = 10
+	c := newTestCluster(t, 3, options)
+	c.put("key", "0")
+
+	lagging := "n1"
+	if c.leader().ID() == lagging {
+		lagging = "n2"
+	}
+	c.network.Disconnect(lagging)
+	for i := 1; i <= 50; i++ {
+		c.put(fmt.Sprintf("key%d", i), fmt.Sprint(i), lagging)
+	}
+	status := c.leader(lagging).Status()
+	if status.SnapshotIndex == 0 || status.LastIndex-status.SnapshotIndex > options.SnapshotThreshold+1 {
+		t.Errorf("Log is not compacted: %+v", status)
+	}
+
+	c.network.Connect(lagging)
+	c.waitFor(lagging, "key50", "50")
+	c.waitFor(lagging, "key", "0")
+	if c.nodes[lagging].Status().SnapshotIndex == 0 {
+		t.Error("Lagging node didn't install a snapshot")
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		c.nodes[lagging].Stop()
+		c.start(lagging, nil)
+		c.put("after", "restart")
+		c.waitFor(lagging, "after", "restart")
+		c.waitFor(lagging, "key25", "25")
+	})
+}
+
+func TestNode_Membership(t *testing.T) {
+	c := newTestCluster(t, 3, testOptions)
+	c.put("key", "value")
+
+	joining := c.start("n4", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := c.leader().AddMember(ctx, Peer{ID: "n4", Address: "n4"}); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor("n4", "key", "value")
+	if members := joining.Members(); len(members) != 4 {
+		t.Errorf("Unexpected members of the new node: %v", members)
+	}
+
+	leader := c.leader()
+	if err := leader.RemoveMember(ctx, leader.ID()); err != nil {
+		t.Fatal(err)
+	}
+	removed := leader.ID()
+	next := c.leader(removed)
+	if members := next.Members(); len(members) != 3 {
+		t.Errorf("Unexpected members after removal: %v", members)
+	}
+	c.put("after", "removal", removed)
+	c.waitFor("n4", "after", "removal")
+	if c.nodes[removed].IsLeader() {
+		t.Error("Removed leader didn't step down")
+	}
+}
+
+func TestNode_FileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := datastore.NewDb(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	options := testOptions
+	options.SnapshotThreshold = 5
+	start := func() (*Node, *FileStorage) {
+		storage, err := NewFileStorage(dir + "/raft")
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers := []Peer{{ID: "single", Address: "single"}}
+		node, err := NewNode("single", peers, NewDbStateMachine(db), storage, NewMemoryNetwork().Transport("single"), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node, storage
+	}
+
+	node, storage := start()
+	deadline := time.Now().Add(3 * time.Second)
+	for !node.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := 0; i < 12; i++ {
+		data := fmt.Sprintf(`{"key":"key%d","type":"int64","value":"%d"}`, i, i)
+		if _, err := node.Propose(context.Background(), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index, err := node.Propose(context.Background(), []byte(`{"key":"key","type":"string","value":"value"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Version("key"); err != nil || v != index {
+		t.Errorf("Expected the index %d as the version, got %d: %v", index, v, err)
+	}
+	stale := fmt.Sprintf(`{"key":"key","type":"string","value":"stale","ifVersion":%d}`, index-1)
+	if _, err := node.Propose(context.Background(), []byte(stale)); !errors.Is(err, datastore.ErrVersionMismatch) {
+		t.Errorf("Expected a version mismatch, got %v", err)
+	}
+	if _, err := node.Propose(context.Background(), []byte(`{"key":"bad","type":"bool","value":"x"}`)); err == nil {
+		t.Error("Expected an error for an unknown type")
+	}
+	node.Stop()
+	storage.Close()
+
+	node, storage = start()
+	defer storage.Close()
+	defer node.Stop()
+	if err := node.Barrier(context.Background()); err != nil && !errors.Is(err, ErrNotLeader) {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(3 * time.Second)
+	for node.Status().LastApplied < node.Status().LastIndex && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if value, err := db.GetInt64("key11"); err != nil || value != 11 {
+		t.Errorf("Bad value after restart: %d, %v", value, err)
+	}
+	if v, err := db.Version("key"); err != nil || v != index {
+		t.Errorf("Version %d is not kept after restart, got %d: %v", index, v, err)
+	}
+	if value, err := db.Get("key"); err != nil || value != "value" {
+		t.Errorf("Bad value after restart: %s, %v", value, err)
+	}
+	if status := node.Status(); status.SnapshotIndex == 0 || status.Term < 2 {
+		t.Errorf("Unexpected status after restart: %+v", status)
+	}
+}
@@ -0,0 +1,176 @@
+package raft
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"time"
+)
+
+// VoteRequest is sent by candidates to gather votes.
+type VoteRequest struct {
+	Term      uint64 `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex uint64 `json:"lastIndex"`
+	LastTerm  uint64 `json:"lastTerm"`
+}
+
+type VoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// AppendRequest is sent by the leader to replicate entries following the
+// one at PrevIndex. Without entries it serves as a heartbeat.
+type AppendRequest struct {
+	Term      uint64  `json:"term"`
+	Leader    string  `json:"leader"`
+	PrevIndex uint64  `json:"prevIndex"`
+	PrevTerm  uint64  `json:"prevTerm"`
+	Entries   []Entry `json:"entries,omitempty"`
+	Commit    uint64  `json:"commit"`
+}
+
+// AppendResponse reports the last index of the follower when the entries
+// don't follow its log, so the leader can skip to it at once.
+type AppendResponse struct {
+	Term      uint64 `json:"term"`
+	Success   bool   `json:"success"`
+	LastIndex uint64 `json:"lastIndex"`
+}
+
+// SnapshotRequest carries the whole snapshot of the leader to a follower
+// whose missing entries are already compacted.
+type SnapshotRequest struct {
+	Term   uint64       `json:"term"`
+	Leader string       `json:"leader"`
+	Meta   SnapshotMeta `json:"meta"`
+	Data   []byte       `json:"data"`
+}
+
+type SnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// Handler processes requests from other nodes. It is implemented by Node
+// and used by the transports on the receiving side.
+type Handler interface {
+	HandleRequestVote(req *VoteRequest) (*VoteResponse, error)
+	HandleAppendEntries(req *AppendRequest) (*AppendResponse, error)
+	HandleInstallSnapshot(req *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+func (n *Node) HandleRequestVote(req *VoteRequest) (*VoteResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	// Поки живий лідер, ігноруємо кандидатів, наприклад, вилучених з кластера вузлів.
+	if n.role == Follower && n.leader != "" && time.Since(n.heardFromLeader) < n.options.ElectionTimeout {
+		return &VoteResponse{Term: n.term}, nil
+	}
+	if req.Term < n.term {
+		return &VoteResponse{Term: n.term}, nil
+	}
+	if req.Term > n.term {
+		n.becomeFollower(req.Term, "")
+	}
+
+	last := n.lastIndex()
+	upToDate := req.LastTerm > n.termAt(last) || (req.LastTerm == n.termAt(last) && req.LastIndex >= last)
+	if (n.vote == "" || n.vote == req.Candidate) && upToDate {
+		n.vote = req.Candidate
+		n.saveState()
+		n.resetElectionTimer()
+		return &VoteResponse{Term: n.term, Granted: true}, nil
+	}
+	return &VoteResponse{Term: n.term}, nil
+}
+
+func (n *Node) HandleAppendEntries(req *AppendRequest) (*AppendResponse, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if req.Term < n.term {
+		return &AppendResponse{Term: n.term, LastIndex: n.lastIndex()}, nil
+	}
+	n.acceptLeader(req.Term, req.Leader)
+
+	prevIndex, prevTerm, entries := req.PrevIndex, req.PrevTerm, req.Entries
+	if snapshot := n.log[0]; prevIndex < snapshot.Index {
+		// Початок уже є у знімку, а зафіксовані записи збігаються в усіх вузлів.
+		skip := min(snapshot.Index-prevIndex, uint64(len(entries)))
+		entries = entries[skip:]
+		prevIndex, prevTerm = snapshot.Index, snapshot.Term
+		if len(entries) > 0 && entries[0].Index != prevIndex+1 {
+			return &AppendResponse{Term: n.term, LastIndex: n.lastIndex()}, nil
+		}
+	}
+	if prevIndex > n.lastIndex() {
+		return &AppendResponse{Term: n.term, LastIndex: n.lastIndex()}, nil
+	}
+	if n.termAt(prevIndex) != prevTerm {
+		return &AppendResponse{Term: n.term, LastIndex: prevIndex - 1}, nil
+	}
+
+	for i, e := range entries {
+		if e.Index > n.lastIndex() {
+			n.storeEntries(entries[i:])
+			break
+		}
+		if n.termAt(e.Index) != e.Term {
+			n.truncate(e.Index)
+			n.storeEntries(entries[i:])
+			break
+		}
+	}
+	if req.Commit > n.commitIndex {
+		n.setCommitIndex(min(req.Commit, prevIndex+uint64(len(entries))))
+	}
+	return &AppendResponse{Term: n.term, Success: true, LastIndex: n.lastIndex()}, nil
+}
+
+func (n *Node) HandleInstallSnapshot(req *SnapshotRequest) (*SnapshotResponse, error) {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if req.Term < n.term {
+		return &SnapshotResponse{Term: n.term}, nil
+	}
+	n.acceptLeader(req.Term, req.Leader)
+	if req.Meta.Index <= n.lastApplied {
+		return &SnapshotResponse{Term: n.term}, nil
+	}
+
+	log.Printf("raft %s: installing a snapshot at %d from %s", n.id, req.Meta.Index, req.Leader)
+	if err := n.fsm.Restore(bytes.NewReader(req.Data)); err != nil {
+		return nil, err
+	}
+	if err := n.storage.SaveSnapshot(req.Meta, func(w io.Writer) error {
+		_, err := w.Write(req.Data)
+		return err
+	}); err != nil {
+		log.Panicf("raft %s: failed to save the snapshot: %s", n.id, err)
+	}
+	n.compact(req.Meta)
+	n.lastApplied = req.Meta.Index
+	n.setCommitIndex(req.Meta.Index)
+	return &SnapshotResponse{Term: n.term}, nil
+}
+
+// acceptLeader records that a valid leader of the term is active.
+func (n *Node) acceptLeader(term uint64, leader string) {
+	if term > n.term || n.role != Follower {
+		n.becomeFollower(term, leader)
+	}
+	n.leader = leader
+	n.heardFromLeader = time.Now()
+	n.resetElectionTimer()
+}
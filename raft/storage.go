@@ -0,0 +1,352 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HardState is the part of the node state that has to survive restarts.
+type HardState struct {
+	Term uint64 `json:"term"`
+	Vote string `json:"vote,omitempty"`
+}
+
+// SnapshotMeta describes the last entry included in a snapshot and the
+// cluster membership at that point.
+type SnapshotMeta struct {
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"`
+	Members []Peer `json:"members"`
+}
+
+// Storage persists the state, the log and the latest snapshot of a node.
+type Storage interface {
+	// Load returns the persisted state, the log entries and the metadata of
+	// the latest snapshot, which is zero if there is none.
+	Load() (HardState, []Entry, SnapshotMeta, error)
+	SaveState(state HardState) error
+	// Append adds entries to the end of the log.
+	Append(entries []Entry) error
+	// Rewrite replaces the whole log. It is used when a conflicting suffix
+	// is truncated or the log is compacted after a snapshot.
+	Rewrite(entries []Entry) error
+	// SaveSnapshot replaces the snapshot with the data written by write,
+	// so that a large state machine is not held in memory.
+	SaveSnapshot(meta SnapshotMeta, write func(io.Writer) error) error
+	// Snapshot returns the latest snapshot.
+	Snapshot() (SnapshotMeta, []byte, error)
+}
+
+// MemoryStorage keeps everything in memory. It is meant for tests, where
+// a node "restarts" by being created again with the same storage.
+type MemoryStorage struct {
+	mu           sync.Mutex
+	state        HardState
+	entries      []Entry
+	meta         SnapshotMeta
+	snapshotData []byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return new(MemoryStorage)
+}
+
+func (s *MemoryStorage) Load() (HardState, []Entry, SnapshotMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, append([]Entry(nil), s.entries...), s.meta, nil
+}
+
+func (s *MemoryStorage) SaveState(state HardState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *MemoryStorage) Rewrite(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append([]Entry(nil), entries...)
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(meta SnapshotMeta, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta, s.snapshotData = meta, buf.Bytes()
+	return nil
+}
+
+func (s *MemoryStorage) Snapshot() (SnapshotMeta, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.meta, s.snapshotData, nil
+}
+
+const (
+	stateFileName    = "state.json"
+	logFileName      = "log.jsonl"
+	snapshotFileName = "snapshot"
+)
+
+// FileStorage keeps the state of a node in a directory: the term and vote
+// in state.json, the log in log.jsonl with one entry per line and the
+// snapshot in a file whose first line is the metadata.
+type FileStorage struct {
+	dir string
+
+	mu  sync.Mutex
+	log *os.File
+}
+
+// NewFileStorage opens the storage in dir, creating the directory if needed.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &FileStorage{dir: dir}
+	f, err := os.OpenFile(s.path(logFileName), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s.log = f
+	return s, nil
+}
+
+func (s *FileStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// Close closes the log file.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.Close()
+}
+
+func (s *FileStorage) Load() (HardState, []Entry, SnapshotMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var state HardState
+	data, err := os.ReadFile(s.path(stateFileName))
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+		if err != nil {
+			return state, nil, SnapshotMeta{}, fmt.Errorf("corrupted %s: %w", stateFileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return state, nil, SnapshotMeta{}, err
+	}
+
+	meta, err := s.snapshotMeta()
+	if err != nil {
+		return state, nil, meta, err
+	}
+	entries, err := s.readLog()
+	return state, entries, meta, err
+}
+
+// readLog decodes the log file. A partially written last line is left
+// after a crash in the middle of Append and is dropped.
+func (s *FileStorage) readLog() ([]Entry, error) {
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	in := bufio.NewReader(s.log)
+	for {
+		line, err := in.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return entries, s.rewrite(entries)
+			}
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("corrupted %s: %w", logFileName, err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+func (s *FileStorage) SaveState(state HardState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(stateFileName), writeData(data))
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(data); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *FileStorage) Rewrite(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rewrite(entries)
+}
+
+func (s *FileStorage) rewrite(entries []Entry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path(logFileName), writeData(data)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(logFileName), os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.log.Close()
+	s.log = f
+	return nil
+}
+
+func (s *FileStorage) SaveSnapshot(meta SnapshotMeta, write func(io.Writer) error) error {
+	header, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path(snapshotFileName), func(w io.Writer) error {
+		if _, err := w.Write(append(header, '\n')); err != nil {
+			return err
+		}
+		return write(w)
+	})
+}
+
+func (s *FileStorage) Snapshot() (SnapshotMeta, []byte, error) {
+	data, err := os.ReadFile(s.path(snapshotFileName))
+	if os.IsNotExist(err) {
+		return SnapshotMeta{}, nil, nil
+	}
+	if err != nil {
+		return SnapshotMeta{}, nil, err
+	}
+	var meta SnapshotMeta
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return meta, nil, fmt.Errorf("corrupted %s: no metadata", snapshotFileName)
+	}
+	if err := json.Unmarshal(data[:end], &meta); err != nil {
+		return meta, nil, fmt.Errorf("corrupted %s: %w", snapshotFileName, err)
+	}
+	return meta, data[end+1:], nil
+}
+
+// snapshotMeta reads only the first line of the snapshot file.
+func (s *FileStorage) snapshotMeta() (SnapshotMeta, error) {
+	f, err := os.Open(s.path(snapshotFileName))
+	if os.IsNotExist(err) {
+		return SnapshotMeta{}, nil
+	}
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	defer f.Close()
+	var meta SnapshotMeta
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return meta, fmt.Errorf("corrupted %s: %w", snapshotFileName, err)
+	}
+	if err := json.Unmarshal(line, &meta); err != nil {
+		return meta, fmt.Errorf("corrupted %s: %w", snapshotFileName, err)
+	}
+	return meta, nil
+}
+
+func encodeEntries(entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeData returns a writeFileAtomic callback writing data.
+func writeData(data []byte) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+}
+
+// writeFileAtomic replaces the file at path with the content written by
+// write, so a crash leaves either the old or the new content. The directory
+// is synced after the rename, so the new file survives a crash too.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	err = write(out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the entries of the directory, such as a renamed file.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
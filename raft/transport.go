@@ -0,0 +1,256 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Transport delivers requests to other nodes.
+type Transport interface {
+	RequestVote(ctx context.Context, to Peer, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, to Peer, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, to Peer, req *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+// ErrUnreachable is returned by the simulated network for nodes that are
+// disconnected or not registered.
+var ErrUnreachable = errors.New("node is unreachable")
+
+// MemoryNetwork connects nodes running in one process. Nodes can be
+// disconnected from the rest to simulate failures and network partitions.
+// Messages are encoded to JSON and back, just as over HTTP.
+type MemoryNetwork struct {
+	mu           sync.Mutex
+	handlers     map[string]Handler
+	disconnected map[string]bool
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		handlers:     make(map[string]Handler),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Register makes the handler receive requests sent to the id.
+func (m *MemoryNetwork) Register(id string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[id] = h
+}
+
+// Disconnect drops all the requests sent to and from the node.
+func (m *MemoryNetwork) Disconnect(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disconnected[id] = true
+}
+
+// Connect restores the connection of the node.
+func (m *MemoryNetwork) Connect(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.disconnected, id)
+}
+
+// Transport returns the transport used by the node with the given id.
+func (m *MemoryNetwork) Transport(id string) Transport {
+	return &memoryTransport{network: m, from: id}
+}
+
+func (m *MemoryNetwork) handler(from, to string) (Handler, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.handlers[to]
+	if !ok || m.disconnected[from] || m.disconnected[to] {
+		return nil, fmt.Errorf("%w: %s", ErrUnreachable, to)
+	}
+	return h, nil
+}
+
+type memoryTransport struct {
+	network *MemoryNetwork
+	from    string
+}
+
+func (t *memoryTransport) RequestVote(ctx context.Context, to Peer, req *VoteRequest) (*VoteResponse, error) {
+	resp := new(VoteResponse)
+	err := t.call(ctx, to, req, resp, func(h Handler, req interface{}) (interface{}, error) {
+		return h.HandleRequestVote(req.(*VoteRequest))
+	})
+	return resp, err
+}
+
+func (t *memoryTransport) AppendEntries(ctx context.Context, to Peer, req *AppendRequest) (*AppendResponse, error) {
+	resp := new(AppendResponse)
+	err := t.call(ctx, to, req, resp, func(h Handler, req interface{}) (interface{}, error) {
+		return h.HandleAppendEntries(req.(*AppendRequest))
+	})
+	return resp, err
+}
+
+func (t *memoryTransport) InstallSnapshot(ctx context.Context, to Peer, req *SnapshotRequest) (*SnapshotResponse, error) {
+	resp := new(SnapshotResponse)
+	err := t.call(ctx, to, req, resp, func(h Handler, req interface{}) (interface{}, error) {
+		return h.HandleInstallSnapshot(req.(*SnapshotRequest))
+	})
+	return resp, err
+}
+
+// call delivers a copy of req to the handler of the peer and decodes its
+// response into resp. The response is lost if either node is disconnected
+// while the request is handled.
+func (t *memoryTransport) call(ctx context.Context, to Peer, req, resp interface{}, handle func(Handler, interface{}) (interface{}, error)) error {
+	h, err := t.network.handler(t.from, to.ID)
+	if err != nil {
+		return err
+	}
+	in := newOf(req)
+	if err := roundTrip(req, in); err != nil {
+		return err
+	}
+
+	type result struct {
+		out interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := handle(h, in)
+		done <- result{out, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return r.err
+		}
+		if _, err := t.network.handler(t.from, to.ID); err != nil {
+			return err
+		}
+		return roundTrip(r.out, resp)
+	}
+}
+
+func newOf(v interface{}) interface{} {
+	switch v.(type) {
+	case *VoteRequest:
+		return new(VoteRequest)
+	case *AppendRequest:
+		return new(AppendRequest)
+	case *SnapshotRequest:
+		return new(SnapshotRequest)
+	default:
+		panic(fmt.Sprintf("unexpected message %T", v))
+	}
+}
+
+func roundTrip(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+const (
+	votePath     = "/raft/vote"
+	appendPath   = "/raft/append"
+	snapshotPath = "/raft/snapshot"
+)
+
+// HTTPTransport sends requests as JSON to the handler created with
+// NewHTTPHandler. Peer addresses are base URLs like "http://db1:8100".
+type HTTPTransport struct {
+	Client *http.Client
+}
+
+func NewHTTPTransport() *HTTPTransport {
+	return &HTTPTransport{Client: new(http.Client)}
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, to Peer, req *VoteRequest) (*VoteResponse, error) {
+	resp := new(VoteResponse)
+	return resp, t.post(ctx, to, votePath, req, resp)
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, to Peer, req *AppendRequest) (*AppendResponse, error) {
+	resp := new(AppendResponse)
+	return resp, t.post(ctx, to, appendPath, req, resp)
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, to Peer, req *SnapshotRequest) (*SnapshotResponse, error) {
+	resp := new(SnapshotResponse)
+	return resp, t.post(ctx, to, snapshotPath, req, resp)
+}
+
+func (t *HTTPTransport) post(ctx context.Context, to Peer, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(to.Address, "/") + path
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("content-type", "application/json")
+	res, err := t.Client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: unexpected status %s: %s", url, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// NewHTTPHandler serves the requests of HTTPTransport under /raft/.
+func NewHTTPHandler(h Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(votePath, func(rw http.ResponseWriter, r *http.Request) {
+		req := new(VoteRequest)
+		serve(rw, r, req, func() (interface{}, error) { return h.HandleRequestVote(req) })
+	})
+	mux.HandleFunc(appendPath, func(rw http.ResponseWriter, r *http.Request) {
+		req := new(AppendRequest)
+		serve(rw, r, req, func() (interface{}, error) { return h.HandleAppendEntries(req) })
+	})
+	mux.HandleFunc(snapshotPath, func(rw http.ResponseWriter, r *http.Request) {
+		req := new(SnapshotRequest)
+		serve(rw, r, req, func() (interface{}, error) { return h.HandleInstallSnapshot(req) })
+	})
+	return mux
+}
+
+func serve(rw http.ResponseWriter, r *http.Request, req interface{}, handle func() (interface{}, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := handle()
+	if errors.Is(err, ErrStopped) {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(rw).Encode(resp)
+}
@@ -0,0 +1,207 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// Як часто фоловер зберігає позицію, до якої застосував записи.
+const saveInterval = 200 * time.Millisecond
+
+// Follower applies the records streamed by a leader to a local database and
+// remembers the last applied position in a state file, so it can resume
+// after a restart.
+type Follower struct {
+	db        *datastore.Db
+	leaderURL string
+	statePath string
+	client    *http.Client
+
+	// RetryDelay is the pause before reconnecting after a failure.
+	RetryDelay time.Duration
+
+	mu       sync.Mutex
+	pos      datastore.Position
+	resyncs  int
+	lastSave time.Time
+}
+
+// NewFollower creates a follower of the leader available at leaderURL
+// (e.g. "http://db:8100"), loading the position saved in statePath.
+func NewFollower(db *datastore.Db, leaderURL, statePath string) (*Follower, error) {
+	f := &Follower{
+		db:         db,
+		leaderURL:  leaderURL,
+		statePath:  statePath,
+		client:     new(http.Client),
+		RetryDelay: time.Second,
+	}
+	data, err := os.ReadFile(statePath)
+	if err == nil {
+		err = json.Unmarshal(data, &f.pos)
+		if err != nil {
+			return nil, fmt.Errorf("corrupted replication state %s: %w", statePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Position returns the position of the last record applied.
+func (f *Follower) Position() datastore.Position {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pos
+}
+
+// Resyncs returns how many times the follower had to load a full snapshot.
+func (f *Follower) Resyncs() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.resyncs
+}
+
+// Run follows the leader until ctx is cancelled.
+func (f *Follower) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := f.follow(ctx)
+		if err == errGone {
+			err = f.resync(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Replication from %s failed: %s", f.leaderURL, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(f.RetryDelay):
+			}
+		}
+	}
+	if err := f.save(); err != nil {
+		log.Printf("Failed to save replication state: %s", err)
+	}
+}
+
+var errGone = fmt.Errorf("replication position is gone")
+
+// follow applies the stream of records until it ends.
+func (f *Follower) follow(ctx context.Context) error {
+	pos := f.Position()
+	url := fmt.Sprintf("%s/admin/replication?segment=%d&offset=%d", f.leaderURL, pos.Segment, pos.Offset)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var msg message
+		err := dec.Decode(&msg)
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Key != "" {
+			// Запис зберігає версію лідера, тож ETag однаковий на всіх репліках.
+			_, err := f.db.PutValueWith(msg.Key, msg.Type, msg.Value, datastore.WriteOptions{Version: msg.Version})
+			if err != nil && err != datastore.ErrNotFound {
+				return err
+			}
+		}
+		f.setPosition(datastore.Position{Segment: msg.Segment, Offset: msg.Offset})
+	}
+}
+
+// resync replaces the local data with a snapshot of the leader.
+func (f *Follower) resync(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.leaderURL+"/admin/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected snapshot status %s", resp.Status)
+	}
+
+	log.Printf("Loading a snapshot from %s", f.leaderURL)
+	err = f.db.Reset()
+	if err != nil {
+		return err
+	}
+	_, err = f.db.Import(resp.Body, datastore.FormatJSONL)
+	if err != nil {
+		return err
+	}
+	// Трейлери доступні лише після того, як тіло відповіді прочитано повністю.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	segment, err := strconv.Atoi(resp.Trailer.Get(segmentHeader))
+	if err != nil {
+		return fmt.Errorf("snapshot without a position: %w", err)
+	}
+	offset, err := strconv.ParseInt(resp.Trailer.Get(offsetHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("snapshot without a position: %w", err)
+	}
+
+	f.mu.Lock()
+	f.pos = datastore.Position{Segment: segment, Offset: offset}
+	f.resyncs++
+	f.mu.Unlock()
+	return f.save()
+}
+
+func (f *Follower) setPosition(pos datastore.Position) {
+	f.mu.Lock()
+	f.pos = pos
+	due := time.Since(f.lastSave) > saveInterval
+	f.mu.Unlock()
+	if due {
+		if err := f.save(); err != nil {
+			log.Printf("Failed to save replication state: %s", err)
+		}
+	}
+}
+
+// save atomically writes the current position to the state file.
+func (f *Follower) save() error {
+	f.mu.Lock()
+	data, err := json.Marshal(f.pos)
+	f.lastSave = time.Now()
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := f.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.statePath)
+}
@@ -0,0 +1,133 @@
+// Package replication implements asynchronous leader-follower replication
+// of a datastore.Db by shipping appended records over HTTP.
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+const (
+	segmentHeader = "X-Replication-Segment"
+	offsetHeader  = "X-Replication-Offset"
+)
+
+// message is a single line of the replication stream. Messages without a
+// key are heartbeats that only carry the current position.
+type message struct {
+	Segment int    `json:"segment"`
+	Offset  int64  `json:"offset"`
+	Key     string `json:"key,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// Leader serves the stream of appended records and snapshots to followers.
+type Leader struct {
+	db *datastore.Db
+	// Heartbeat is how often an idle stream reports the current position.
+	Heartbeat time.Duration
+	// BatchSize is the number of records read from the segments at once.
+	BatchSize int
+}
+
+func NewLeader(db *datastore.Db) *Leader {
+	return &Leader{db: db, Heartbeat: time.Second, BatchSize: 512}
+}
+
+// HandleStream streams records appended after the position given by the
+// "segment" and "offset" query parameters until the client disconnects.
+// It responds with 410 Gone if the position is no longer available and the
+// follower has to start over from a snapshot.
+func (l *Leader) HandleStream(rw http.ResponseWriter, r *http.Request) {
+	pos, err := parsePosition(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, _ := rw.(http.Flusher)
+	enc := json.NewEncoder(rw)
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			rw.Header().Set("content-type", "application/x-ndjson")
+			rw.WriteHeader(http.StatusOK)
+		}
+	}
+
+	heartbeat := time.NewTimer(l.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		changed := l.db.Changed()
+		next, err := l.db.ReadFrom(pos, l.BatchSize, func(rec datastore.Record, p datastore.Position) error {
+			start()
+			return enc.Encode(message{p.Segment, p.Offset, rec.Key, rec.Type, rec.Value, rec.Version})
+		})
+		if errors.Is(err, datastore.ErrPositionGone) && !started {
+			http.Error(rw, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			log.Printf("Replication stream from %v failed: %s", pos, err)
+			return
+		}
+		start()
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if next != pos {
+			pos = next
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-heartbeat.C:
+			heartbeat.Reset(l.Heartbeat)
+			if err := enc.Encode(message{Segment: pos.Segment, Offset: pos.Offset}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// HandleSnapshot writes all the data in the JSON Lines export format. The
+// position to stream from afterwards is sent in the response trailers.
+func (l *Leader) HandleSnapshot(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("content-type", "application/x-ndjson")
+	rw.Header().Set("Trailer", segmentHeader+", "+offsetHeader)
+	pos, err := l.db.Snapshot(rw, datastore.FormatJSONL)
+	if err != nil {
+		log.Printf("Replication snapshot failed: %s", err)
+		return
+	}
+	rw.Header().Set(segmentHeader, strconv.Itoa(pos.Segment))
+	rw.Header().Set(offsetHeader, strconv.FormatInt(pos.Offset, 10))
+}
+
+func parsePosition(query url.Values) (datastore.Position, error) {
+	var pos datastore.Position
+	var err error
+	if s := query.Get("segment"); s != "" {
+		pos.Segment, err = strconv.Atoi(s)
+		if err != nil {
+			return pos, err
+		}
+	}
+	if s := query.Get("offset"); s != "" {
+		pos.Offset, err = strconv.ParseInt(s, 10, 64)
+	}
+	return pos, err
+}
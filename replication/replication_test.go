@@ -0,0 +1,198 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+type testFollower struct {
+	dir    string
+	db     *datastore.Db
+	f      *Follower
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startFollower(t *testing.T, dir, leaderURL string) *testFollower {
+	t.Helper()
+	db, err := datastore.NewDb(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFollower(db, leaderURL, filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.RetryDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	tf := &testFollower{dir, db, f, cancel, make(chan struct{})}
+	go func() {
+		f.Run(ctx)
+		close(tf.done)
+	}()
+	return tf
+}
+
+func (tf *testFollower) stop() {
+	tf.cancel()
+	<-tf.done
+	tf.db.Close()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func hasValue(db *datastore.Db, key, expected string) func() bool {
+	return func() bool {
+		value, err := db.Get(key)
+		return err == nil && value == expected
+	}
+}
+
+// active returns the size of the active segment.
+func active(db *datastore.Db) int64 {
+	segments := db.Stats().Segments
+	return segments[len(segments)-1].TotalBytes
+}
+
+func TestReplication(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-replication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := datastore.DefaultOptions()
+	options.SegmentSize = 200
+	leaderDb, err := datastore.NewDbWithOptions(filepath.Join(dir, "leader"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leaderDb.Close()
+	if err := leaderDb.Put("before", "followers"); err != nil {
+		t.Fatal(err)
+	}
+
+	leader := NewLeader(leaderDb)
+	leader.Heartbeat = 20 * time.Millisecond
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/replication", leader.HandleStream)
+	mux.HandleFunc("/admin/snapshot", leader.HandleSnapshot)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	followers := []*testFollower{
+		startFollower(t, filepath.Join(dir, "follower1"), server.URL),
+		startFollower(t, filepath.Join(dir, "follower2"), server.URL),
+	}
+	defer func() {
+		for _, tf := range followers {
+			tf.stop()
+		}
+	}()
+
+	t.Run("initial snapshot and stream", func(t *testing.T) {
+		if err := leaderDb.PutInt64("counter", 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := leaderDb.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		for _, tf := range followers {
+			waitFor(t, "snapshot data", hasValue(tf.db, "before", "followers"))
+			waitFor(t, "streamed data", hasValue(tf.db, "key", "value"))
+			waitFor(t, "int64 value", func() bool {
+				n, err := tf.db.GetInt64("counter")
+				return err == nil && n == 1
+			})
+		}
+	})
+
+	t.Run("resume after restart", func(t *testing.T) {
+		tf := followers[0]
+		tf.stop()
+		resyncs := tf.f.Resyncs()
+
+		if err := leaderDb.Put("key", "while stopped"); err != nil {
+			t.Fatal(err)
+		}
+		followers[0] = startFollower(t, tf.dir, server.URL)
+		waitFor(t, "missed write", hasValue(followers[0].db, "key", "while stopped"))
+		if followers[0].f.Resyncs() != 0 || resyncs != 1 {
+			t.Errorf("Expected the follower to resume without a snapshot")
+		}
+		if followers[0].f.Position() != leaderDb.Position() {
+			t.Errorf("Positions differ: %v vs %v", followers[0].f.Position(), leaderDb.Position())
+		}
+	})
+
+	t.Run("segments rollover and merge", func(t *testing.T) {
+		for i := 0; i < 50; i++ {
+			if err := leaderDb.Put(fmt.Sprintf("key%d", i%7), fmt.Sprintf("value%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Злиття відбувається у фоні.
+		waitFor(t, "merge on the leader", func() bool { return leaderDb.Stats().Compactions > 0 })
+		for _, tf := range followers {
+			for i := 43; i < 50; i++ {
+				waitFor(t, "writes after merge", hasValue(tf.db, fmt.Sprintf("key%d", i%7), fmt.Sprintf("value%d", i)))
+			}
+		}
+	})
+
+	t.Run("snapshot when position is gone", func(t *testing.T) {
+		// Позиція фоловера має опинитися всередині сегмента, який потім зіллють,
+		// тож активний сегмент лідера не повинен бути вже заповненим.
+		for active(leaderDb) > options.SegmentSize {
+			if err := leaderDb.Put("filler", "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		waitFor(t, "filler", func() bool { return followers[1].f.Position() == leaderDb.Position() })
+		tf := followers[1]
+		tf.stop()
+		for i := 0; i < 50; i++ {
+			if err := leaderDb.Put(fmt.Sprintf("gone%d", i), "value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		followers[1] = startFollower(t, tf.dir, server.URL)
+		waitFor(t, "snapshot", hasValue(followers[1].db, "gone49", "value"))
+		if followers[1].f.Resyncs() != 1 {
+			t.Errorf("Expected a resync, got %d", followers[1].f.Resyncs())
+		}
+		waitFor(t, "old data", hasValue(followers[1].db, "before", "followers"))
+	})
+
+	t.Run("versions of the leader", func(t *testing.T) {
+		for _, key := range []string{"before", "counter", "key", "key6", "gone49"} {
+			expected, err := leaderDb.Version(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tf := range followers {
+				waitFor(t, "version of "+key, func() bool {
+					v, err := tf.db.Version(key)
+					return err == nil && v == expected
+				})
+			}
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package resp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Client is a minimal RESP client. Do sends a command and waits for the
+// reply; Send and Receive pipeline several commands. It is safe for
+// concurrent use, but pipelines must not be interleaved with other calls.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	in   *Reader
+	out  *Writer
+	// pending is the number of commands sent without reading their replies.
+	pending int
+}
+
+// Dial connects to a RESP server at the TCP address.
+func Dial(address string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, in: NewReader(conn), out: NewWriter(conn)}, nil
+}
+
+// Do sends a command and returns its reply. An error reply is returned as
+// an Error.
+func (c *Client) Do(args ...string) (Value, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.WriteCommand(args...)
+	if err := c.out.Flush(); err != nil {
+		return Value{}, err
+	}
+	return c.in.ReadValue()
+}
+
+// Send buffers a command without waiting for the reply.
+func (c *Client) Send(args ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.out.WriteCommand(args...)
+	c.pending++
+}
+
+// Receive sends the buffered commands and reads all their replies. The
+// error replies are kept in errs at the positions of their commands.
+func (c *Client) Receive() (replies []Value, errs []error, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.out.Flush(); err != nil {
+		return nil, nil, err
+	}
+	replies, errs = make([]Value, c.pending), make([]error, c.pending)
+	for i := range replies {
+		replies[i], errs[i] = c.in.ReadValue()
+		if _, ok := errs[i].(Error); errs[i] != nil && !ok {
+			return nil, nil, errs[i]
+		}
+	}
+	c.pending = 0
+	return replies, errs, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,113 @@
+package resp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// sweepInterval is how often the keys whose time to live is over are
+// deleted in the background.
+const sweepInterval = 100 * time.Millisecond
+
+// expirations keeps the deadlines set by EXPIRE and SET with EX or PX. The
+// datastore has no notion of a time to live, so the deadlines live in the
+// memory of the server. They are lost on restart, and the keys whose
+// deadlines were lost never expire; set them again after a restart.
+//
+// A deadline belongs to the version of the key it was set for, so a write
+// made through any API, including HTTP and gRPC, drops it: the key is only
+// deleted if it still has that version. Due keys are deleted when they are
+// accessed through the server and by a periodic sweep.
+type expirations struct {
+	db *datastore.Db
+
+	mu        sync.Mutex
+	deadlines map[string]deadline
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// deadline is the time the version of a key expires at.
+type deadline struct {
+	at      time.Time
+	version uint64
+}
+
+func newExpirations(db *datastore.Db) *expirations {
+	e := &expirations{
+		db:        db,
+		deadlines: make(map[string]deadline),
+		done:      make(chan struct{}),
+	}
+	go e.sweep()
+	return e
+}
+
+// set makes the version of the key expire at the given time.
+func (e *expirations) set(key string, version uint64, at time.Time) {
+	e.mu.Lock()
+	e.deadlines[key] = deadline{at, version}
+	e.mu.Unlock()
+}
+
+func (e *expirations) clear(key string) {
+	e.mu.Lock()
+	delete(e.deadlines, key)
+	e.mu.Unlock()
+}
+
+// keep moves the deadline of the key to its new version, for the writes
+// that keep the time to live, such as INCRBY. previous is the version the
+// write replaced.
+func (e *expirations) keep(key string, previous, version uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if d, ok := e.deadlines[key]; ok && d.version == previous {
+		e.deadlines[key] = deadline{d.at, version}
+	}
+}
+
+// expireIfDue deletes the key if its time to live is over and reports
+// whether it did so.
+func (e *expirations) expireIfDue(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d, ok := e.deadlines[key]
+	if !ok || time.Now().Before(d.at) {
+		return false
+	}
+	return e.expire(key, d)
+}
+
+// expire deletes the key if it still has the version of the deadline. mu
+// must be held.
+func (e *expirations) expire(key string, d deadline) bool {
+	delete(e.deadlines, key)
+	_, err := e.db.PutValueWith(key, "tombstone", "", datastore.WriteOptions{IfVersion: d.version})
+	return err == nil
+}
+
+func (e *expirations) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case now := <-ticker.C:
+			e.mu.Lock()
+			for key, d := range e.deadlines {
+				if !now.Before(d.at) {
+					e.expire(key, d)
+				}
+			}
+			e.mu.Unlock()
+		}
+	}
+}
+
+func (e *expirations) stop() {
+	e.stopOnce.Do(func() { close(e.done) })
+}
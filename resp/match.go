@@ -0,0 +1,86 @@
+package resp
+
+// match reports whether the key matches a glob-style pattern as used by
+// SCAN ... MATCH: * matches any sequence, ? any single byte, [abc], [^abc]
+// and [a-z] match sets of bytes, and \ escapes the next byte.
+func match(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if match(pattern, key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if key == "" {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		case '[':
+			if key == "" {
+				return false
+			}
+			matched, rest, ok := matchSet(pattern[1:], key[0])
+			if !ok {
+				// Незакритий клас порівнюється як звичайний символ.
+				if key[0] != '[' {
+					return false
+				}
+				pattern, key = pattern[1:], key[1:]
+				continue
+			}
+			if !matched {
+				return false
+			}
+			pattern, key = rest, key[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if key == "" || pattern[0] != key[0] {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+	return key == ""
+}
+
+// matchSet matches c against the set at the start of pattern, right after
+// the '['. It returns the rest of the pattern after the closing ']', or
+// ok = false if there is none.
+func matchSet(pattern string, c byte) (matched bool, rest string, ok bool) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate, pattern = true, pattern[1:]
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == ']' && i > 0:
+			return matched != negate, pattern[i+1:], true
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+	return false, "", false
+}
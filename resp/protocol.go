@@ -0,0 +1,251 @@
+// Package resp implements a subset of the Redis serialization protocol
+// (RESP2) on top of datastore.Db, so Redis clients can talk to the db.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits of the requests the reader accepts.
+const (
+	maxBulkLength  = 64 << 20
+	maxArrayLength = 1 << 20
+	maxLineLength  = 64 << 10
+)
+
+// ErrProtocol is wrapped by the errors of malformed input.
+var ErrProtocol = errors.New("protocol error")
+
+// Error is an error reply of the server.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Value is a reply. Kind is the RESP type prefix: '+' for simple strings,
+// ':' for integers, '$' for bulk strings and '*' for arrays. Null bulk
+// strings and arrays have Null set.
+type Value struct {
+	Kind  byte
+	Str   string
+	Int   int64
+	Array []Value
+	Null  bool
+}
+
+func (v Value) String() string {
+	switch {
+	case v.Null:
+		return "(nil)"
+	case v.Kind == ':':
+		return strconv.FormatInt(v.Int, 10)
+	case v.Kind == '*':
+		items := make([]string, len(v.Array))
+		for i, item := range v.Array {
+			items[i] = item.String()
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	default:
+		return v.Str
+	}
+}
+
+// Reader reads RESP messages.
+type Reader struct {
+	in *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{in: bufio.NewReader(r)}
+}
+
+// Buffered returns the number of bytes already received but not read.
+// The server flushes its replies only when it runs out of pipelined
+// commands.
+func (r *Reader) Buffered() int {
+	return r.in.Buffered()
+}
+
+// ReadCommand reads a command sent as an array of bulk strings or as an
+// inline command, a line of space separated words.
+func (r *Reader) ReadCommand() ([]string, error) {
+	prefix, err := r.in.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if prefix[0] != '*' {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		return strings.Fields(line), nil
+	}
+	v, err := r.ReadValue()
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, len(v.Array))
+	for i, arg := range v.Array {
+		if arg.Kind != '$' || arg.Null {
+			return nil, fmt.Errorf("%w: expected a bulk string argument", ErrProtocol)
+		}
+		args[i] = arg.Str
+	}
+	return args, nil
+}
+
+// ReadValue reads a single message. Error replies are returned as Error.
+func (r *Reader) ReadValue() (Value, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	if line == "" {
+		return Value{}, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+	kind, rest := line[0], line[1:]
+	switch kind {
+	case '+':
+		return Value{Kind: kind, Str: rest}, nil
+	case '-':
+		return Value{}, Error(rest)
+	case ':':
+		n, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return Value{}, fmt.Errorf("%w: bad integer %q", ErrProtocol, rest)
+		}
+		return Value{Kind: kind, Int: n}, nil
+	case '$':
+		n, err := r.length(rest, maxBulkLength)
+		if err != nil {
+			return Value{}, err
+		}
+		if n < 0 {
+			return Value{Kind: kind, Null: true}, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r.in, data); err != nil {
+			return Value{}, err
+		}
+		if data[n] != '\r' || data[n+1] != '\n' {
+			return Value{}, fmt.Errorf("%w: bulk string is not terminated by CRLF", ErrProtocol)
+		}
+		return Value{Kind: kind, Str: string(data[:n])}, nil
+	case '*':
+		n, err := r.length(rest, maxArrayLength)
+		if err != nil {
+			return Value{}, err
+		}
+		if n < 0 {
+			return Value{Kind: kind, Null: true}, nil
+		}
+		v := Value{Kind: kind, Array: make([]Value, 0, min(n, 1024))}
+		for i := 0; i < n; i++ {
+			item, err := r.ReadValue()
+			if err != nil {
+				return Value{}, err
+			}
+			v.Array = append(v.Array, item)
+		}
+		return v, nil
+	default:
+		return Value{}, fmt.Errorf("%w: unknown type %q", ErrProtocol, kind)
+	}
+}
+
+func (r *Reader) length(s string, limit int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 || n > limit {
+		return 0, fmt.Errorf("%w: bad length %q", ErrProtocol, s)
+	}
+	return n, nil
+}
+
+func (r *Reader) readLine() (string, error) {
+	line, err := r.in.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// Рядок довший за буфер читаємо частинами, але не безмежно.
+		long := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull && len(long) <= maxLineLength {
+			line, err = r.in.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		if err == bufio.ErrBufferFull {
+			return "", fmt.Errorf("%w: line is too long", ErrProtocol)
+		}
+		line = long
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// Writer writes RESP messages into a buffer; call Flush to send them.
+type Writer struct {
+	out *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{out: bufio.NewWriter(w)}
+}
+
+func (w *Writer) WriteSimple(s string) {
+	w.out.WriteByte('+')
+	w.out.WriteString(s)
+	w.out.WriteString("\r\n")
+}
+
+func (w *Writer) WriteError(s string) {
+	w.out.WriteByte('-')
+	// Переведення рядка розірвало б відповідь.
+	w.out.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(s))
+	w.out.WriteString("\r\n")
+}
+
+func (w *Writer) WriteInt(n int64) {
+	w.out.WriteByte(':')
+	w.out.WriteString(strconv.FormatInt(n, 10))
+	w.out.WriteString("\r\n")
+}
+
+func (w *Writer) WriteBulk(s string) {
+	w.out.WriteByte('$')
+	w.out.WriteString(strconv.Itoa(len(s)))
+	w.out.WriteString("\r\n")
+	w.out.WriteString(s)
+	w.out.WriteString("\r\n")
+}
+
+// WriteNull writes a null bulk string.
+func (w *Writer) WriteNull() {
+	w.out.WriteString("$-1\r\n")
+}
+
+// WriteArray writes the header of an array of n items, which are written next.
+func (w *Writer) WriteArray(n int) {
+	w.out.WriteByte('*')
+	w.out.WriteString(strconv.Itoa(n))
+	w.out.WriteString("\r\n")
+}
+
+// WriteCommand writes a command as an array of bulk strings.
+func (w *Writer) WriteCommand(args ...string) {
+	w.WriteArray(len(args))
+	for _, arg := range args {
+		w.WriteBulk(arg)
+	}
+}
+
+func (w *Writer) Flush() error {
+	return w.out.Flush()
+}
@@ -0,0 +1,271 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+func startServer(t *testing.T) (*datastore.Db, string) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db)
+	go s.Serve(l)
+	t.Cleanup(func() {
+		s.Close()
+		db.Close()
+	})
+	return db, l.Addr().String()
+}
+
+func dial(t *testing.T, address string) *Client {
+	c, err := Dial(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func expect(t *testing.T, c *Client, expected string, args ...string) {
+	t.Helper()
+	v, err := c.Do(args...)
+	got := v.String()
+	if err != nil {
+		got = "error: " + err.Error()
+	}
+	if got != expected {
+		t.Errorf("%s: expected %q, got %q", strings.Join(args, " "), expected, got)
+	}
+}
+
+func TestServer_Commands(t *testing.T) {
+	db, address := startServer(t)
+	c := dial(t, address)
+
+	expect(t, c, "PONG", "PING")
+	expect(t, c, "hello", "ping", "hello")
+	expect(t, c, "(nil)", "GET", "missing")
+	expect(t, c, "OK", "SET", "key", "value")
+	expect(t, c, "value", "GET", "key")
+	expect(t, c, "5", "INCRBY", "counter", "5")
+	expect(t, c, "2", "incrby", "counter", "-3")
+	expect(t, c, "2", "GET", "counter")
+	expect(t, c, "error: ERR value is not an integer or out of range", "INCRBY", "key", "1")
+	expect(t, c, "error: ERR value is not an integer or out of range", "INCRBY", "counter", "x")
+	expect(t, c, "3", "EXISTS", "key", "counter", "key", "missing")
+	expect(t, c, "2", "DEL", "key", "counter", "missing")
+	expect(t, c, "0", "EXISTS", "key")
+	expect(t, c, "error: ERR wrong number of arguments for 'get' command", "GET")
+	expect(t, c, "error: ERR unknown command 'FLUSHALL', with args beginning with: ", "FLUSHALL")
+
+	// Значення, записані через HTTP API, видно через RESP і навпаки.
+	if err := db.PutInt64("number", 42); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, "42", "GET", "number")
+	expect(t, c, "43", "INCRBY", "number", "1")
+	if n, err := db.GetInt64("number"); err != nil || n != 43 {
+		t.Errorf("INCRBY stored %d, %v", n, err)
+	}
+}
+
+func TestServer_Expire(t *testing.T) {
+	db, address := startServer(t)
+	c := dial(t, address)
+
+	expect(t, c, "OK", "SET", "short", "value", "PX", "50")
+	expect(t, c, "OK", "SET", "long", "value")
+	expect(t, c, "1", "EXPIRE", "long", "100")
+	expect(t, c, "0", "EXPIRE", "missing", "100")
+	expect(t, c, "OK", "SET", "gone", "value")
+	expect(t, c, "1", "EXPIRE", "gone", "0")
+	expect(t, c, "(nil)", "GET", "gone")
+
+	time.Sleep(60 * time.Millisecond)
+	expect(t, c, "(nil)", "GET", "short")
+	expect(t, c, "value", "GET", "long")
+
+	// SET знімає термін життя, а фонове прибирання видаляє прострочені ключі.
+	expect(t, c, "OK", "SET", "reset", "value", "PX", "30")
+	expect(t, c, "OK", "SET", "reset", "value")
+	expect(t, c, "OK", "SET", "swept", "value", "PX", "30")
+	// Запис через інший API теж знімає термін, а INCRBY його зберігає.
+	expect(t, c, "OK", "SET", "rewritten", "value", "PX", "30")
+	if err := db.Put("rewritten", "fresh"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, "1", "INCRBY", "counter", "1")
+	expect(t, c, "1", "EXPIRE", "counter", "1")
+	expect(t, c, "2", "INCRBY", "counter", "1")
+	time.Sleep(30*time.Millisecond + 2*sweepInterval)
+	expect(t, c, "value", "GET", "reset")
+	expect(t, c, "fresh", "GET", "rewritten")
+	if _, err := db.Get("swept"); err != datastore.ErrNotFound {
+		t.Errorf("Expired key was not swept: %v", err)
+	}
+	time.Sleep(time.Second)
+	expect(t, c, "(nil)", "GET", "counter")
+}
+
+func TestServer_Scan(t *testing.T) {
+	_, address := startServer(t)
+	c := dial(t, address)
+	for i := 0; i < 50; i++ {
+		c.Send("SET", fmt.Sprintf("user:%d", i), "value")
+		c.Send("SET", fmt.Sprintf("team:%d", i), "value")
+	}
+	if _, _, err := c.Receive(); err != nil {
+		t.Fatal(err)
+	}
+
+	var found []string
+	cursor := "0"
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("SCAN does not finish")
+		}
+		v, err := c.Do("SCAN", cursor, "MATCH", "user:*", "COUNT", "7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor = v.Array[0].Str
+		for _, key := range v.Array[1].Array {
+			found = append(found, key.Str)
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(found)
+	if len(found) != 50 || found[0] != "user:0" || found[49] != "user:9" {
+		t.Errorf("Unexpected keys %v", found)
+	}
+	expect(t, c, "error: ERR invalid cursor", "SCAN", "x")
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	_, address := startServer(t)
+	const clients, commands = 10, 200
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := Dial(address, time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+			for j := 0; j < commands; j++ {
+				c.Send("INCRBY", "counter", "1")
+			}
+			c.Send("PING")
+			replies, _, err := c.Receive()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(replies) != commands+1 || replies[commands].Str != "PONG" {
+				t.Errorf("Unexpected replies to a pipeline: %d", len(replies))
+			}
+		}()
+	}
+	wg.Wait()
+	expect(t, dial(t, address), fmt.Sprint(clients*commands), "GET", "counter")
+}
+
+func TestServer_Protocol(t *testing.T) {
+	_, address := startServer(t)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	in := bufio.NewReader(conn)
+
+	// Інлайн-команди, як із telnet.
+	fmt.Fprint(conn, "SET inline value\r\nGET inline\r\n")
+	for _, expected := range []string{"+OK\r\n", "$5\r\n", "value\r\n"} {
+		if line, _ := in.ReadString('\n'); line != expected {
+			t.Errorf("Expected %q, got %q", expected, line)
+		}
+	}
+	fmt.Fprint(conn, "*1\r\n$4\r\nPING\r\n*1\r\n$x\r\n")
+	if line, _ := in.ReadString('\n'); line != "+PONG\r\n" {
+		t.Errorf("Expected PONG, got %q", line)
+	}
+	if line, _ := in.ReadString('\n'); !strings.HasPrefix(line, "-ERR protocol error") {
+		t.Errorf("Expected a protocol error, got %q", line)
+	}
+	if _, err := in.ReadString('\n'); err == nil {
+		t.Error("Connection is not closed after a protocol error")
+	}
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	db, err := datastore.NewDb(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(db)
+	s.ReadOnly = true
+	go s.Serve(l)
+	defer s.Close()
+
+	c := dial(t, l.Addr().String())
+	expect(t, c, "error: READONLY You can't write against a read only replica.", "SET", "key", "other")
+	expect(t, c, "value", "GET", "key")
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		matches      bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "team:1", false},
+		{"*:1", "user:1", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"[", "[", true},
+	} {
+		if match(tc.pattern, tc.key) != tc.matches {
+			t.Errorf("match(%q, %q) != %v", tc.pattern, tc.key, tc.matches)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package resp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+)
+
+// ErrServerClosed is returned by Serve after Close.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server answers RESP commands from any number of connections. Commands
+// pipelined by a client are executed in order and their replies are sent
+// together.
+type Server struct {
+	db *datastore.Db
+	// ReadOnly rejects the commands that change data, as on a replica.
+	ReadOnly bool
+
+	ttl *expirations
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+func NewServer(db *datastore.Db) *Server {
+	s := &Server{
+		db:        db,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	s.ttl = newExpirations(db)
+	return s
+}
+
+// ListenAndServe listens on the TCP address and serves the connections.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections until the listener fails or the server is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// Close stops the listeners, drops the connections and waits until the
+// commands being executed finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.ttl.stop()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	in, out := NewReader(conn), NewWriter(conn)
+	for {
+		args, err := in.ReadCommand()
+		if errors.Is(err, ErrProtocol) {
+			out.WriteError("ERR " + err.Error())
+			_ = out.Flush()
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("RESP connection from %s: %s", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.execute(out, args)
+		// Відповіді на конвеєр команд відправляються разом.
+		if in.Buffered() == 0 || quit {
+			if err := out.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+type command struct {
+	// arity is the number of arguments including the name; -n means at least n.
+	arity int
+	write bool
+	run   func(s *Server, out *Writer, args []string)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":   {-1, false, (*Server).ping},
+		"get":    {2, false, (*Server).get},
+		"set":    {-3, true, (*Server).set},
+		"del":    {-2, true, (*Server).del},
+		"incrby": {3, true, (*Server).incrBy},
+		"exists": {-2, false, (*Server).exists},
+		"scan":   {-2, false, (*Server).scan},
+		"expire": {3, true, (*Server).expire},
+	}
+}
+
+// execute runs a command and writes its reply. It returns true for QUIT.
+func (s *Server) execute(out *Writer, args []string) bool {
+	name := strings.ToLower(args[0])
+	if name == "quit" {
+		out.WriteSimple("OK")
+		return true
+	}
+	c, ok := commands[name]
+	if !ok {
+		out.WriteError(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0], quoteArgs(args[1:])))
+		return false
+	}
+	if (c.arity > 0 && len(args) != c.arity) || (c.arity < 0 && len(args) < -c.arity) {
+		out.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	if c.write && s.ReadOnly {
+		out.WriteError("READONLY You can't write against a read only replica.")
+		return false
+	}
+	c.run(s, out, args)
+	return false
+}
+
+func quoteArgs(args []string) string {
+	var b strings.Builder
+	for _, arg := range args {
+		fmt.Fprintf(&b, "'%s' ", arg)
+	}
+	return b.String()
+}
+
+func writeDbError(out *Writer, err error) {
+	switch {
+	case errors.Is(err, datastore.ErrWrongType):
+		out.WriteError("ERR value is not an integer or out of range")
+	case errors.Is(err, datastore.ErrOverflow):
+		out.WriteError("ERR increment or decrement would overflow")
+	default:
+		out.WriteError("ERR " + err.Error())
+	}
+}
+
+func (s *Server) ping(out *Writer, args []string) {
+	switch len(args) {
+	case 1:
+		out.WriteSimple("PONG")
+	case 2:
+		out.WriteBulk(args[1])
+	default:
+		out.WriteError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (s *Server) get(out *Writer, args []string) {
+	if s.ttl.expireIfDue(args[1]) {
+		out.WriteNull()
+		return
+	}
+	value, _, err := s.db.GetValue(args[1])
+	if err == datastore.ErrNotFound {
+		out.WriteNull()
+		return
+	}
+	if err != nil {
+		writeDbError(out, err)
+		return
+	}
+	out.WriteBulk(value)
+}
+
+// maxExpireSeconds keeps deadlines within the range of time.Duration.
+const maxExpireSeconds = int64(1<<63-1) / int64(time.Second)
+
+// set supports the EX and PX options, which set the time to live.
+func (s *Server) set(out *Writer, args []string) {
+	var ttl time.Duration
+	for i := 3; i < len(args); i += 2 {
+		option := strings.ToLower(args[i])
+		if (option != "ex" && option != "px") || i+1 >= len(args) {
+			out.WriteError("ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || n <= 0 || n > maxExpireSeconds {
+			out.WriteError("ERR invalid expire time in 'set' command")
+			return
+		}
+		if option == "ex" {
+			ttl = time.Duration(n) * time.Second
+		} else {
+			ttl = time.Duration(n) * time.Millisecond
+		}
+	}
+	version, err := s.db.PutValueWith(args[1], "string", args[2], datastore.WriteOptions{})
+	if err != nil {
+		writeDbError(out, err)
+		return
+	}
+	if ttl > 0 {
+		s.ttl.set(args[1], version, time.Now().Add(ttl))
+	} else {
+		s.ttl.clear(args[1])
+	}
+	out.WriteSimple("OK")
+}
+
+func (s *Server) del(out *Writer, args []string) {
+	var deleted int64
+	for _, key := range args[1:] {
+		s.ttl.clear(key)
+		err := s.db.Delete(key)
+		if err == nil {
+			deleted++
+		} else if err != datastore.ErrNotFound {
+			writeDbError(out, err)
+			return
+		}
+	}
+	out.WriteInt(deleted)
+}
+
+func (s *Server) incrBy(out *Writer, args []string) {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		out.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	s.ttl.expireIfDue(args[1])
+	// Як і в Redis, INCRBY зберігає термін життя ключа.
+	previous, _ := s.db.Version(args[1])
+	n, err := s.db.IncrBy(args[1], delta)
+	if err != nil {
+		writeDbError(out, err)
+		return
+	}
+	if version, err := s.db.Version(args[1]); err == nil {
+		s.ttl.keep(args[1], previous, version)
+	}
+	out.WriteInt(n)
+}
+
+func (s *Server) exists(out *Writer, args []string) {
+	var n int64
+	for _, key := range args[1:] {
+		if !s.ttl.expireIfDue(key) && s.db.Exists(key) {
+			n++
+		}
+	}
+	out.WriteInt(n)
+}
+
+// scan supports the MATCH and COUNT options.
+func (s *Server) scan(out *Writer, args []string) {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		out.WriteError("ERR invalid cursor")
+		return
+	}
+	count, pattern := 10, ""
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			out.WriteError("ERR syntax error")
+			return
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				out.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			out.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	keys, next := s.db.Scan(cursor, count)
+	matched := keys[:0]
+	for _, key := range keys {
+		if (pattern == "" || match(pattern, key)) && !s.ttl.expireIfDue(key) {
+			matched = append(matched, key)
+		}
+	}
+	out.WriteArray(2)
+	out.WriteBulk(strconv.FormatUint(next, 10))
+	out.WriteArray(len(matched))
+	for _, key := range matched {
+		out.WriteBulk(key)
+	}
+}
+
+func (s *Server) expire(out *Writer, args []string) {
+	seconds, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		out.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	if seconds > maxExpireSeconds {
+		out.WriteError("ERR invalid expire time in 'expire' command")
+		return
+	}
+	key := args[1]
+	if s.ttl.expireIfDue(key) {
+		out.WriteInt(0)
+		return
+	}
+	version, err := s.db.Version(key)
+	if err == datastore.ErrNotFound {
+		out.WriteInt(0)
+		return
+	}
+	if seconds <= 0 {
+		s.ttl.clear(key)
+		if err := s.db.Delete(key); err != nil && err != datastore.ErrNotFound {
+			writeDbError(out, err)
+			return
+		}
+		out.WriteInt(1)
+		return
+	}
+	s.ttl.set(key, version, time.Now().Add(time.Duration(seconds)*time.Second))
+	out.WriteInt(1)
+}
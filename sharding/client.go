@@ -0,0 +1,74 @@
+package sharding
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client routes requests for a key to the db node owning it. While keys are
+// being moved by Rebalance, the client is created with the previous list of
+// nodes too: writes go to the new owners and reads fall back to the old
+// ones for the keys that haven't been copied yet.
+type Client struct {
+	ring     *Ring
+	previous *Ring
+	// Scheme of the node URLs, "http" by default.
+	Scheme string
+	HTTP   *http.Client
+}
+
+// NewClient creates a client of the nodes given as host:port addresses.
+// previous is the list of nodes before the ongoing rebalancing, or nil.
+func NewClient(nodes, previous []string) *Client {
+	c := &Client{
+		ring:   NewRing(nodes, DefaultReplicas),
+		Scheme: "http",
+		HTTP:   http.DefaultClient,
+	}
+	if previous != nil {
+		c.previous = NewRing(previous, DefaultReplicas)
+	}
+	return c
+}
+
+// ParseNodes splits a comma separated list of addresses.
+func ParseNodes(s string) []string {
+	var nodes []string
+	for _, node := range strings.Split(s, ",") {
+		if node = strings.TrimSpace(node); node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// Owner returns the address of the node owning the key.
+func (c *Client) Owner(key string) string {
+	return c.ring.Owner(key)
+}
+
+// URL returns the address of the key on the given node.
+func (c *Client) URL(node, key string) *url.URL {
+	return &url.URL{Scheme: c.Scheme, Host: node, Path: "/db/" + key}
+}
+
+// AdminURL returns the address of the admin endpoint, such as "export", on
+// the given node.
+func (c *Client) AdminURL(node, endpoint string) *url.URL {
+	return &url.URL{Scheme: c.Scheme, Host: node, Path: "/admin/" + endpoint}
+}
+
+// Route returns the owner of the key followed by its previous owner while
+// the keys are being rebalanced. It makes the client a dbclient.Router, so
+// reads that find nothing on the new owner fall back to the old one.
+func (c *Client) Route(key string) []string {
+	owner := c.Owner(key)
+	if c.previous == nil {
+		return []string{owner}
+	}
+	if previous := c.previous.Owner(key); previous != owner && previous != "" {
+		return []string{owner, previous}
+	}
+	return []string{owner}
+}
@@ -0,0 +1,145 @@
+package sharding
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+// rebalanceBatch is the number of records sent to a node in one import.
+const rebalanceBatch = 1000
+
+// RebalanceStats reports the work done by Rebalance.
+type RebalanceStats struct {
+	// Scanned is the number of keys read from the previous owners.
+	Scanned int `json:"scanned"`
+	// Moved is the number of keys sent to their new owners, by node.
+	Moved map[string]int `json:"moved"`
+}
+
+// Rebalance copies the keys whose owner changed between the previous and
+// the current ring. Every previous node streams its keyspace with
+// /admin/export and the keys of the ranges it no longer owns are imported
+// into the new owners in batches.
+//
+// It is safe to run while the cluster serves traffic through clients
+// created with the same lists of nodes: the imports skip keys that were
+// already written to the new owner, so a newer value is never replaced.
+// Once a batch is imported, its keys are deleted from the previous owner,
+// which no longer receives writes for them.
+func (c *Client) Rebalance(ctx context.Context) (RebalanceStats, error) {
+	stats := RebalanceStats{Moved: make(map[string]int)}
+	if c.previous == nil {
+		return stats, fmt.Errorf("no previous nodes to rebalance from")
+	}
+	for _, node := range c.previous.Nodes() {
+		if err := c.moveFrom(ctx, node, &stats); err != nil {
+			return stats, fmt.Errorf("rebalancing %s: %w", node, err)
+		}
+	}
+	return stats, nil
+}
+
+func (c *Client) moveFrom(ctx context.Context, node string, stats *RebalanceStats) error {
+	u := c.AdminURL(node, "export")
+	u.RawQuery = "format=jsonl"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("export: unexpected status %s", resp.Status)
+	}
+
+	source := dbclient.New(dbclient.SingleNode(node), dbclient.DefaultOptions())
+	source.Scheme = c.Scheme
+
+	// Записи пересилаються як є, тож тип значення зберігається.
+	batches := make(map[string][][]byte)
+	keys := make(map[string][]string)
+	flush := func(target string) error {
+		batch := batches[target]
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := c.importInto(ctx, target, bytes.Join(batch, nil)); err != nil {
+			return err
+		}
+		for _, key := range keys[target] {
+			if err := source.Delete(ctx, key); err != nil && !errors.Is(err, dbclient.ErrNotFound) {
+				return fmt.Errorf("deleting moved key %s: %w", key, err)
+			}
+		}
+		stats.Moved[target] += len(batch)
+		batches[target] = batch[:0]
+		keys[target] = keys[target][:0]
+		return nil
+	}
+
+	in := bufio.NewReader(resp.Body)
+	for {
+		line, err := in.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		var record struct {
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		stats.Scanned++
+		target := c.Owner(record.Key)
+		if target == node {
+			continue
+		}
+		batches[target] = append(batches[target], append(bytes.TrimRight(line, "\n"), '\n'))
+		keys[target] = append(keys[target], record.Key)
+		if len(batches[target]) >= rebalanceBatch {
+			if err := flush(target); err != nil {
+				return err
+			}
+		}
+	}
+	for target := range batches {
+		if err := flush(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) importInto(ctx context.Context, node string, records []byte) error {
+	u := c.AdminURL(node, "import")
+	u.RawQuery = "format=jsonl&overwrite=false"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(records))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/x-ndjson")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("import into %s: unexpected status %s: %s", node, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
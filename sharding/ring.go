@@ -0,0 +1,19 @@
+// Package sharding partitions the keyspace across several cmd/db nodes with
+// consistent hashing and routes requests for a key to the node owning it.
+package sharding
+
+import "github.com/roman-mazur/architecture-practice-4-template/hashring"
+
+// DefaultReplicas is the number of points every node gets on the ring.
+const DefaultReplicas = 128
+
+// Ring maps keys to nodes with consistent hashing, see hashring.Ring.
+type Ring = hashring.Ring
+
+// NewRing places nodes on the ring with the given number of points each.
+func NewRing(nodes []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return hashring.New(nodes, nil, replicas)
+}
@@ -0,0 +1,194 @@
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-practice-4-template/datastore"
+	"github.com/roman-mazur/architecture-practice-4-template/dbclient"
+)
+
+func TestRing_Spread(t *testing.T) {
+	nodes := []string{"db1:8100", "db2:8100", "db3:8100", "db4:8100"}
+	ring := NewRing(nodes, DefaultReplicas)
+	counts := make(map[string]int)
+	const keys = 40000
+	for i := 0; i < keys; i++ {
+		counts[ring.Owner(fmt.Sprintf("key-%d", i))]++
+	}
+	for _, node := range nodes {
+		share := float64(counts[node]) / keys
+		if share < 0.15 || share > 0.35 {
+			t.Errorf("Node %s owns %.2f of the keys: %v", node, share, counts)
+		}
+	}
+
+	reordered := NewRing([]string{"db3:8100", "db1:8100", "db4:8100", "db2:8100"}, DefaultReplicas)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if ring.Owner(key) != reordered.Owner(key) {
+			t.Fatalf("Owner of %s depends on the order of nodes", key)
+		}
+	}
+}
+
+func TestRing_Remapping(t *testing.T) {
+	before := NewRing([]string{"db1", "db2", "db3"}, DefaultReplicas)
+	after := NewRing([]string{"db1", "db2", "db3", "db4"}, DefaultReplicas)
+	moved := 0
+	const keys = 30000
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if before.Owner(key) != after.Owner(key) {
+			moved++
+			if after.Owner(key) != "db4" {
+				t.Fatalf("Key %s moved between old nodes", key)
+			}
+		}
+	}
+	if share := float64(moved) / keys; share < 0.15 || share > 0.35 {
+		t.Errorf("Adding a fourth node moved %.2f of the keys", share)
+	}
+}
+
+// testNode serves a part of the cmd/db API backed by a datastore.
+func testNode(t *testing.T) (*datastore.Db, string) {
+	dir, err := ioutil.TempDir("", "test-sharding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := datastore.NewDb(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.NewServeMux()
+	h.HandleFunc("/admin/export", func(rw http.ResponseWriter, r *http.Request) {
+		_ = db.Export(rw, datastore.FormatJSONL)
+	})
+	h.HandleFunc("/admin/import", func(rw http.ResponseWriter, r *http.Request) {
+		importRecords := db.Import
+		if r.URL.Query().Get("overwrite") == "false" {
+			importRecords = db.ImportMissing
+		}
+		if _, err := importRecords(r.Body, datastore.FormatJSONL); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		}
+	})
+	h.HandleFunc("/db/", func(rw http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		if r.Method == http.MethodDelete {
+			if err := db.Delete(key); err != nil {
+				http.Error(rw, err.Error(), http.StatusNotFound)
+			}
+			return
+		}
+		if r.Method == http.MethodPost {
+			if err := db.Put(key, r.FormValue("value")); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		value, err := db.Get(key)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(rw).Encode(map[string]string{"key": key, "value": value})
+	})
+	server := httptest.NewServer(h)
+	t.Cleanup(func() {
+		server.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db, strings.TrimPrefix(server.URL, "http://")
+}
+
+func getValue(t *testing.T, c *Client, key string) (string, bool) {
+	t.Helper()
+	value, err := dbclient.New(c, dbclient.DefaultOptions()).Get(context.Background(), key)
+	if errors.Is(err, dbclient.ErrNotFound) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value, true
+}
+
+func TestClient_Rebalance(t *testing.T) {
+	var nodes []string
+	dbs := make(map[string]*datastore.Db)
+	for i := 0; i < 3; i++ {
+		db, node := testNode(t)
+		nodes = append(nodes, node)
+		dbs[node] = db
+	}
+	ctx := context.Background()
+
+	before := dbclient.New(NewClient(nodes[:2], nil), dbclient.DefaultOptions())
+	for i := 0; i < 200; i++ {
+		if err := before.Put(ctx, fmt.Sprintf("key%d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for node, db := range dbs {
+		if node != nodes[2] && db.Stats().Keys == 0 {
+			t.Errorf("Node %s got no keys", node)
+		}
+	}
+
+	migrating := NewClient(nodes, nodes[:2])
+	var moving []string
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if migrating.Owner(key) == nodes[2] {
+			moving = append(moving, key)
+		}
+	}
+	if len(moving) == 0 {
+		t.Fatal("No keys move to the new node")
+	}
+	// Читання під час перенесення знаходять ключі на попередніх власниках.
+	if value, ok := getValue(t, migrating, moving[0]); !ok || value == "" {
+		t.Errorf("Key %s is not found during rebalancing", moving[0])
+	}
+	if err := dbclient.New(migrating, dbclient.DefaultOptions()).Put(ctx, moving[1], "updated"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := migrating.Rebalance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Scanned != 200 || stats.Moved[nodes[2]] != len(moving) {
+		t.Errorf("Unexpected stats %+v, expected %d keys moved", stats, len(moving))
+	}
+
+	for _, key := range moving {
+		if _, err := dbs[migrating.previous.Owner(key)].Get(key); err != datastore.ErrNotFound {
+			t.Errorf("Moved key %s is left on the previous owner: %v", key, err)
+		}
+	}
+
+	after := NewClient(nodes, nil)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%d", i)
+		expected := fmt.Sprint(i)
+		if key == moving[1] {
+			expected = "updated"
+		}
+		if value, ok := getValue(t, after, key); !ok || value != expected {
+			t.Errorf("Bad value of %s after rebalancing: %q", key, value)
+		}
+	}
+}
@@ -8,8 +8,16 @@ import (
 )
 
 func WaitForTerminationSignal() {
-	intChannel := make(chan os.Signal)
+	intChannel := make(chan os.Signal, 1)
 	signal.Notify(intChannel, syscall.SIGINT, syscall.SIGTERM)
 	<-intChannel
 	log.Println("Shutting down...")
 }
+
+// ReloadSignals returns a channel that receives SIGHUP, which asks a
+// process to reload its configuration.
+func ReloadSignals() <-chan os.Signal {
+	hupChannel := make(chan os.Signal, 1)
+	signal.Notify(hupChannel, syscall.SIGHUP)
+	return hupChannel
+}